@@ -0,0 +1,417 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AscClass wraps a Go struct (or pointer to a struct) so that it's passed to the
+// AssemblyScript module as an instance of the class it describes. The layout of the class
+// is controlled through `asc` struct tags:
+//
+//	type Transfer struct {
+//		_      struct{} `asc:"class=12"`
+//		From   []byte   `asc:"class=8"`
+//		To     []byte   `asc:"class=8,nullable"`
+//		Amount uint64
+//		Memo   *string  `asc:"order=0,nullable"`
+//	}
+//
+// The blank field carries the class id of the struct. On regular fields, `order=N`
+// overrides the field position (defaults to the declaration position), `nullable` accepts
+// `null` references, `class=N` gives the class id of array or typed array fields, `array`
+// lays out a `[]byte` as an `Array<u8>` instead of a typed array and `-` skips the field.
+// Fields are aligned on their natural size like the AssemblyScript compiler does.
+type AscClass struct {
+	Value interface{}
+}
+
+// WriteClass writes `value`, a struct or a pointer to a struct, as an AssemblyScript class
+// instance and returns its pointer. Nested structs, strings and slices are written as
+// separate managed objects and referenced from the class.
+func (h *AscHeap) WriteClass(value interface{}) (int32, error) {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return 0, fmt.Errorf("cannot write nil %T", value)
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return 0, fmt.Errorf("expected a struct, got %T", value)
+	}
+
+	return h.writeClass(rv)
+}
+
+// ReadClass reads the AssemblyScript class instance at `ptr` into `out` which must be a
+// pointer to a struct annotated the same way as for WriteClass.
+func (h *AscHeap) ReadClass(ptr int32, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected a non-nil pointer to a struct, got %T", out)
+	}
+
+	return h.readClass(ptr, rv.Elem())
+}
+
+func (h *AscHeap) writeClass(rv reflect.Value) (int32, error) {
+	return h.writeRoot(func() (int32, error) {
+		return h.writeClassObjects(rv)
+	})
+}
+
+func (h *AscHeap) writeClassObjects(rv reflect.Value) (int32, error) {
+	layout, err := ascLayoutOf(rv.Type())
+	if err != nil {
+		return 0, err
+	}
+
+	if !layout.hasClassID {
+		return 0, fmt.Errorf("struct %s has no class id, add a `_ struct{} `asc:\"class=<id>\"`` field", rv.Type())
+	}
+
+	payload := make([]byte, layout.size)
+	for _, field := range layout.fields {
+		if err := h.writeAscValue(payload[field.offset:], rv.FieldByIndex(field.index), field.ascFieldOptions); err != nil {
+			return 0, fmt.Errorf("field %s.%s: %w", rv.Type(), field.name, err)
+		}
+	}
+
+	ptr, err := h.NewObject(len(payload), layout.classID)
+	if err != nil {
+		return 0, fmt.Errorf("allocate class %s: %w", rv.Type(), err)
+	}
+
+	if err := h.write(ptr, payload); err != nil {
+		return 0, fmt.Errorf("write class %s: %w", rv.Type(), err)
+	}
+
+	return ptr, nil
+}
+
+func (h *AscHeap) readClass(ptr int32, rv reflect.Value) error {
+	layout, err := ascLayoutOf(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	payload, err := h.read(ptr, layout.size)
+	if err != nil {
+		return fmt.Errorf("read class %s: %w", rv.Type(), err)
+	}
+
+	for _, field := range layout.fields {
+		if err := h.readAscValue(payload[field.offset:], rv.FieldByIndex(field.index), field.ascFieldOptions); err != nil {
+			return fmt.Errorf("field %s.%s: %w", rv.Type(), field.name, err)
+		}
+	}
+
+	return nil
+}
+
+// writeAscValue writes `rv` in `out` which is at least `ascSizeOf(rv.Type())` bytes long,
+// references are written as separate objects and their pointer is stored in `out`.
+func (h *AscHeap) writeAscValue(out []byte, rv reflect.Value, options ascFieldOptions) error {
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			out[0] = 1
+		}
+	case reflect.Int8:
+		out[0] = byte(rv.Int())
+	case reflect.Uint8:
+		out[0] = byte(rv.Uint())
+	case reflect.Int16:
+		encoding.PutUint16(out, uint16(rv.Int()))
+	case reflect.Uint16:
+		encoding.PutUint16(out, uint16(rv.Uint()))
+	case reflect.Int32, reflect.Int:
+		encoding.PutUint32(out, uint32(rv.Int()))
+	case reflect.Uint32, reflect.Uint:
+		encoding.PutUint32(out, uint32(rv.Uint()))
+	case reflect.Int64:
+		encoding.PutUint64(out, uint64(rv.Int()))
+	case reflect.Uint64:
+		encoding.PutUint64(out, rv.Uint())
+	case reflect.Float32:
+		encoding.PutUint32(out, math.Float32bits(float32(rv.Float())))
+	case reflect.Float64:
+		encoding.PutUint64(out, math.Float64bits(rv.Float()))
+	default:
+		ptr, err := h.writeAscReference(rv, options)
+		if err != nil {
+			return err
+		}
+		encoding.PutUint32(out, uint32(ptr))
+	}
+
+	return nil
+}
+
+func (h *AscHeap) writeAscReference(rv reflect.Value, options ascFieldOptions) (int32, error) {
+	switch rv.Kind() {
+	case reflect.String:
+		return h.WriteString(rv.String())
+
+	case reflect.Ptr:
+		if rv.IsNil() {
+			if !options.nullable {
+				return 0, fmt.Errorf("nil value for non-nullable reference")
+			}
+			return 0, nil
+		}
+		return h.writeAscReference(rv.Elem(), options)
+
+	case reflect.Struct:
+		return h.writeClass(rv)
+
+	case reflect.Slice:
+		if rv.IsNil() && options.nullable {
+			return 0, nil
+		}
+
+		if !options.hasClassID {
+			return 0, fmt.Errorf("missing class id for %s, add a `class=<id>` option", rv.Type())
+		}
+
+		elementSize, err := ascSizeOf(rv.Type().Elem())
+		if err != nil {
+			return 0, err
+		}
+
+		buffer := make([]byte, int(elementSize)*rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err := h.writeAscValue(buffer[int32(i)*elementSize:], rv.Index(i), ascFieldOptions{}); err != nil {
+				return 0, fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+
+		if rv.Type().Elem().Kind() == reflect.Uint8 && !options.array {
+			return h.WriteTypedArray(options.classID, buffer)
+		}
+		return h.WriteArray(options.classID, buffer, rv.Len())
+	}
+
+	return 0, fmt.Errorf("unhandled type %s", rv.Type())
+}
+
+func (h *AscHeap) readAscValue(in []byte, rv reflect.Value, options ascFieldOptions) error {
+	switch rv.Kind() {
+	case reflect.Bool:
+		rv.SetBool(in[0] != 0)
+	case reflect.Int8:
+		rv.SetInt(int64(int8(in[0])))
+	case reflect.Uint8:
+		rv.SetUint(uint64(in[0]))
+	case reflect.Int16:
+		rv.SetInt(int64(int16(encoding.Uint16(in))))
+	case reflect.Uint16:
+		rv.SetUint(uint64(encoding.Uint16(in)))
+	case reflect.Int32, reflect.Int:
+		rv.SetInt(int64(int32(encoding.Uint32(in))))
+	case reflect.Uint32, reflect.Uint:
+		rv.SetUint(uint64(encoding.Uint32(in)))
+	case reflect.Int64:
+		rv.SetInt(int64(encoding.Uint64(in)))
+	case reflect.Uint64:
+		rv.SetUint(encoding.Uint64(in))
+	case reflect.Float32:
+		rv.SetFloat(float64(math.Float32frombits(encoding.Uint32(in))))
+	case reflect.Float64:
+		rv.SetFloat(math.Float64frombits(encoding.Uint64(in)))
+	default:
+		ptr := int32(encoding.Uint32(in))
+		if ptr == 0 {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		return h.readAscReference(ptr, rv, options)
+	}
+
+	return nil
+}
+
+func (h *AscHeap) readAscReference(ptr int32, rv reflect.Value, options ascFieldOptions) error {
+	switch rv.Kind() {
+	case reflect.String:
+		value, err := h.ReadString(ptr)
+		if err != nil {
+			return err
+		}
+		rv.SetString(value)
+
+	case reflect.Ptr:
+		element := reflect.New(rv.Type().Elem())
+		if err := h.readAscReference(ptr, element.Elem(), options); err != nil {
+			return err
+		}
+		rv.Set(element)
+
+	case reflect.Struct:
+		return h.readClass(ptr, rv)
+
+	case reflect.Slice:
+		elementSize, err := ascSizeOf(rv.Type().Elem())
+		if err != nil {
+			return err
+		}
+
+		buffer, err := h.ReadArray(ptr, elementSize)
+		if err != nil {
+			return err
+		}
+
+		length := len(buffer) / int(elementSize)
+		slice := reflect.MakeSlice(rv.Type(), length, length)
+		for i := 0; i < length; i++ {
+			if err := h.readAscValue(buffer[int32(i)*elementSize:], slice.Index(i), ascFieldOptions{}); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		rv.Set(slice)
+
+	default:
+		return fmt.Errorf("unhandled type %s", rv.Type())
+	}
+
+	return nil
+}
+
+type ascFieldOptions struct {
+	classID    uint32
+	hasClassID bool
+	nullable   bool
+	array      bool
+}
+
+type ascField struct {
+	ascFieldOptions
+
+	name   string
+	index  []int
+	order  int
+	offset int32
+}
+
+type ascClassLayout struct {
+	classID    uint32
+	hasClassID bool
+	size       int32
+	fields     []*ascField
+}
+
+var ascLayouts sync.Map
+
+func ascLayoutOf(typ reflect.Type) (*ascClassLayout, error) {
+	if cached, found := ascLayouts.Load(typ); found {
+		return cached.(*ascClassLayout), nil
+	}
+
+	layout := &ascClassLayout{}
+	for i := 0; i < typ.NumField(); i++ {
+		structField := typ.Field(i)
+		tag, hasTag := structField.Tag.Lookup("asc")
+		if tag == "-" {
+			continue
+		}
+
+		field := &ascField{name: structField.Name, index: structField.Index, order: i}
+		if hasTag {
+			if err := parseAscTag(tag, field); err != nil {
+				return nil, fmt.Errorf("field %s.%s: %w", typ, structField.Name, err)
+			}
+		}
+
+		if structField.Name == "_" {
+			layout.classID, layout.hasClassID = field.classID, field.hasClassID
+			continue
+		}
+
+		if structField.PkgPath != "" {
+			continue
+		}
+
+		layout.fields = append(layout.fields, field)
+	}
+
+	sort.SliceStable(layout.fields, func(i, j int) bool {
+		return layout.fields[i].order < layout.fields[j].order
+	})
+
+	for _, field := range layout.fields {
+		size, err := ascSizeOf(typ.FieldByIndex(field.index).Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s.%s: %w", typ, field.name, err)
+		}
+
+		field.offset = alignTo(layout.size, size)
+		layout.size = field.offset + size
+	}
+
+	ascLayouts.Store(typ, layout)
+	return layout, nil
+}
+
+func parseAscTag(tag string, field *ascField) error {
+	for _, option := range strings.Split(tag, ",") {
+		key, value := option, ""
+		if i := strings.Index(option, "="); i >= 0 {
+			key, value = option[:i], option[i+1:]
+		}
+
+		switch key {
+		case "":
+		case "nullable":
+			field.nullable = true
+		case "array":
+			field.array = true
+		case "order":
+			order, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid order %q: %w", value, err)
+			}
+			field.order = order
+		case "class":
+			classID, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid class id %q: %w", value, err)
+			}
+			field.classID, field.hasClassID = uint32(classID), true
+		default:
+			return fmt.Errorf("unknown asc tag option %q", key)
+		}
+	}
+
+	return nil
+}
+
+// ascSizeOf returns the size (which is also the alignment) of a value of type `typ` when
+// stored in a class field or an array element, references are 32 bits pointers.
+func ascSizeOf(typ reflect.Type) (int32, error) {
+	switch typ.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		return 1, nil
+	case reflect.Int16, reflect.Uint16:
+		return 2, nil
+	case reflect.Int32, reflect.Uint32, reflect.Int, reflect.Uint, reflect.Float32:
+		return 4, nil
+	case reflect.Int64, reflect.Uint64, reflect.Float64:
+		return 8, nil
+	case reflect.String, reflect.Slice, reflect.Struct:
+		return 4, nil
+	case reflect.Ptr:
+		switch typ.Elem().Kind() {
+		case reflect.String, reflect.Slice, reflect.Struct:
+			return 4, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unhandled type %s", typ)
+}
@@ -0,0 +1,128 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+type testAscToken struct {
+	_       struct{} `asc:"class=10"`
+	Symbol  string
+	Decimal uint8
+}
+
+type testAscTransfer struct {
+	_        struct{} `asc:"class=12"`
+	From     []byte   `asc:"class=8"`
+	To       []byte   `asc:"class=8,nullable"`
+	Amount   uint64
+	Memo     *string `asc:"order=0,nullable"`
+	Token    *testAscToken
+	Tags     []string `asc:"class=9"`
+	Internal bool     `asc:"-"`
+}
+
+func TestAscHeap_Class(t *testing.T) {
+	heap := newTestAscHeap(t)
+
+	memo := "payment"
+	in := &testAscTransfer{
+		From:   []byte{0x01, 0x02},
+		Amount: 1_000_000_000_000,
+		Memo:   &memo,
+		Token:  &testAscToken{Symbol: "ETH", Decimal: 18},
+		Tags:   []string{"a", "ü"},
+	}
+
+	ptr, err := heap.WriteClass(in)
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), ptr%ascObjectAlignment)
+
+	classID, err := heap.ObjectClassID(ptr)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(12), classID)
+
+	// memo(4) from(4) to(4) pad(4) amount(8) token(4) tags(4)
+	size, err := heap.ObjectSize(ptr)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(32), size)
+
+	out := &testAscTransfer{}
+	require.NoError(t, heap.ReadClass(ptr, out))
+	assert.Equal(t, in, out)
+}
+
+func TestAscHeap_ClassErrors(t *testing.T) {
+	heap := newTestAscHeap(t)
+
+	_, err := heap.WriteClass(&testAscTransfer{From: []byte{}})
+	assert.EqualError(t, err, "field wasm.testAscTransfer.Token: nil value for non-nullable reference")
+
+	_, err = heap.WriteClass(struct{ A int32 }{})
+	assert.EqualError(t, err, "struct struct { A int32 } has no class id, add a `_ struct{} `asc:\"class=<id>\"`` field")
+}
+
+func newTestAscHeap(t testing.TB) *AscHeap {
+	t.Helper()
+
+	limits, err := wasmer.NewLimits(1, 16)
+	require.NoError(t, err)
+
+	store := wasmer.NewStore(wasmer.NewEngine())
+	return newAscHeap(wasmer.NewMemory(store, wasmer.NewMemoryType(limits)), zlog)
+}
+
+// The module traps when allocating while an object it allocated before is not pinned, its
+// collector could free it.
+const ascPinTestModule = `
+(module
+  (memory (export "memory") 1)
+  (global $next (mut i32) (i32.const 4096))
+  (global $allocated (export "allocated") (mut i32) (i32.const 0))
+  (global $pinned (export "pinned") (mut i32) (i32.const 0))
+
+  (func (export "__new") (param $size i32) (param $id i32) (result i32) (local $ptr i32)
+    (if (i32.ne (global.get $allocated) (global.get $pinned)) (then (unreachable)))
+    (global.set $allocated (i32.add (global.get $allocated) (i32.const 1)))
+    (local.set $ptr (i32.add (global.get $next) (i32.const 32)))
+    (i32.store (i32.sub (local.get $ptr) (i32.const 8)) (local.get $id))
+    (i32.store (i32.sub (local.get $ptr) (i32.const 4)) (local.get $size))
+    (global.set $next (i32.add (local.get $ptr) (i32.and (i32.add (local.get $size) (i32.const 15)) (i32.const -16))))
+    (local.get $ptr))
+
+  (func (export "__pin") (param $ptr i32) (result i32)
+    (global.set $pinned (i32.add (global.get $pinned) (i32.const 1)))
+    (local.get $ptr))
+
+  (func (export "__unpin") (param $ptr i32)
+    (global.set $pinned (i32.sub (global.get $pinned) (i32.const 1)))
+    (global.set $allocated (i32.sub (global.get $allocated) (i32.const 1)))))
+`
+
+func TestAscHeap_ClassPinning(t *testing.T) {
+	instance, err := NewRuntime(&RustEnvironment{}).Instantiate(writeTestModule(t, ascPinTestModule))
+	require.NoError(t, err)
+	defer instance.Close()
+
+	in := &testAscTransfer{
+		From:  []byte{0x01, 0x02},
+		Token: &testAscToken{Symbol: "ETH", Decimal: 18},
+		Tags:  []string{"a", "b"},
+	}
+
+	ptr, err := instance.heap.WriteClass(in)
+	require.NoError(t, err)
+
+	for _, name := range []string{"allocated", "pinned"} {
+		value, err := readGlobal(instance, name)
+		require.NoError(t, err)
+		assert.Equal(t, int32(0), value, name)
+	}
+
+	out := &testAscTransfer{}
+	require.NoError(t, instance.heap.ReadClass(ptr, out))
+	assert.Equal(t, in, out)
+}
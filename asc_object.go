@@ -0,0 +1,346 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf16"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// AssemblyScript managed objects are preceded by a 20 bytes header (mmInfo, gcInfo,
+// gcInfo2, rtId, rtSize), the object pointer itself points right after the header
+// and is always 16 bytes aligned.
+const (
+	ascObjectHeaderSize = 20
+	ascObjectAlignment  = 16
+
+	ascRtIDOffset   = -8
+	ascRtSizeOffset = -4
+
+	ascArrayBufferViewSize = 12
+	ascArraySize           = 16
+)
+
+// Class ids of the built-in AssemblyScript classes, those are fixed by the compiler.
+const (
+	AscArrayBufferID     uint32 = 0
+	AscStringID          uint32 = 1
+	AscArrayBufferViewID uint32 = 2
+)
+
+// NewObject allocates an AssemblyScript managed object of `size` bytes for the given
+// class id and returns the pointer to its (zeroed) payload. When the module exports
+// `__new`, the allocation is delegated to the module runtime, otherwise the object header
+// is written by us in host managed memory.
+func (h *AscHeap) NewObject(size int, classID uint32) (int32, error) {
+	if h.ascNew != nil {
+		out, err := h.ascNew(int32(size), int32(classID))
+		if err != nil {
+			return 0, fmt.Errorf("calling __new(%d, %d): %w", size, classID, err)
+		}
+
+		ptr, ok := out.(int32)
+		if !ok {
+			return 0, fmt.Errorf("__new returned %T, expected i32", out)
+		}
+		return ptr, h.pin(ptr)
+	}
+
+	start := alignTo(h.nextPtrLocation+ascObjectHeaderSize, ascObjectAlignment) - ascObjectHeaderSize
+	if _, err := h.reserve(int(start-h.nextPtrLocation) + ascObjectHeaderSize + size); err != nil {
+		return 0, err
+	}
+
+	ptr := start + ascObjectHeaderSize
+	header := make([]byte, ascObjectHeaderSize)
+	encoding.PutUint32(header[0:], uint32(ascObjectHeaderSize-4+size))
+	encoding.PutUint32(header[12:], classID)
+	encoding.PutUint32(header[16:], uint32(size))
+
+	if err := h.write(start, header); err != nil {
+		return 0, err
+	}
+
+	if err := h.write(ptr, make([]byte, size)); err != nil {
+		return 0, err
+	}
+
+	return ptr, nil
+}
+
+// WriteString writes `value` as an AssemblyScript `String` (UTF-16LE encoded) and returns
+// its pointer.
+func (h *AscHeap) WriteString(value string) (int32, error) {
+	chars := utf16.Encode([]rune(value))
+
+	ptr, err := h.NewObject(len(chars)*2, AscStringID)
+	if err != nil {
+		return 0, fmt.Errorf("allocate string: %w", err)
+	}
+
+	data, err := h.writable(ptr, int32(len(chars)*2))
+	if err != nil {
+		return 0, fmt.Errorf("write string: %w", err)
+	}
+
+	for i, char := range chars {
+		encoding.PutUint16(data[i*2:], char)
+	}
+
+	return ptr, nil
+}
+
+// WriteArrayBuffer writes `value` as an AssemblyScript `ArrayBuffer` and returns its pointer.
+func (h *AscHeap) WriteArrayBuffer(value []byte) (int32, error) {
+	ptr, err := h.NewObject(len(value), AscArrayBufferID)
+	if err != nil {
+		return 0, fmt.Errorf("allocate array buffer: %w", err)
+	}
+
+	if err := h.write(ptr, value); err != nil {
+		return 0, fmt.Errorf("write array buffer: %w", err)
+	}
+	return ptr, nil
+}
+
+// WriteTypedArray writes `buffer` as the backing store of an AssemblyScript typed array
+// (`Uint8Array`, `Int32Array`, ...) of class `classID` and returns the view pointer.
+func (h *AscHeap) WriteTypedArray(classID uint32, buffer []byte) (int32, error) {
+	return h.writeArrayView(classID, buffer, -1)
+}
+
+// WriteArray writes `buffer` as the backing store of an AssemblyScript `Array<T>` of class
+// `classID` containing `length` elements and returns the array pointer.
+func (h *AscHeap) WriteArray(classID uint32, buffer []byte, length int) (int32, error) {
+	return h.writeArrayView(classID, buffer, length)
+}
+
+func (h *AscHeap) writeArrayView(classID uint32, buffer []byte, length int) (int32, error) {
+	return h.writeRoot(func() (int32, error) {
+		return h.writeArrayViewObjects(classID, buffer, length)
+	})
+}
+
+func (h *AscHeap) writeArrayViewObjects(classID uint32, buffer []byte, length int) (int32, error) {
+	bufferPtr, err := h.WriteArrayBuffer(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	size := ascArrayBufferViewSize
+	if length >= 0 {
+		size = ascArraySize
+	}
+
+	ptr, err := h.NewObject(size, classID)
+	if err != nil {
+		return 0, fmt.Errorf("allocate array view: %w", err)
+	}
+
+	data, err := h.writable(ptr, int32(size))
+	if err != nil {
+		return 0, fmt.Errorf("write array view: %w", err)
+	}
+
+	encoding.PutUint32(data[0:], uint32(bufferPtr))
+	encoding.PutUint32(data[4:], uint32(bufferPtr))
+	encoding.PutUint32(data[8:], uint32(len(buffer)))
+	if length >= 0 {
+		encoding.PutUint32(data[12:], uint32(length))
+	}
+
+	return ptr, nil
+}
+
+// ObjectClassID returns the class id recorded in the header of the managed object at `ptr`.
+func (h *AscHeap) ObjectClassID(ptr int32) (uint32, error) {
+	bytes, err := h.read(ptr+ascRtIDOffset, 4)
+	if err != nil {
+		return 0, fmt.Errorf("read object class id: %w", err)
+	}
+	return encoding.Uint32(bytes), nil
+}
+
+// ObjectSize returns the payload size recorded in the header of the managed object at `ptr`.
+func (h *AscHeap) ObjectSize(ptr int32) (uint32, error) {
+	bytes, err := h.read(ptr+ascRtSizeOffset, 4)
+	if err != nil {
+		return 0, fmt.Errorf("read object size: %w", err)
+	}
+	return encoding.Uint32(bytes), nil
+}
+
+// ReadString reads the AssemblyScript `String` object at `ptr`.
+func (h *AscHeap) ReadString(ptr int32) (string, error) {
+	size, err := h.ObjectSize(ptr)
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := h.read(ptr, int32(size))
+	if err != nil {
+		return "", fmt.Errorf("read string content: %w", err)
+	}
+
+	chars := make([]uint16, len(bytes)/2)
+	for i := range chars {
+		chars[i] = encoding.Uint16(bytes[i*2:])
+	}
+
+	return string(utf16.Decode(chars)), nil
+}
+
+// ReadArrayBuffer reads the AssemblyScript `ArrayBuffer` object at `ptr`.
+func (h *AscHeap) ReadArrayBuffer(ptr int32) ([]byte, error) {
+	size, err := h.ObjectSize(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := h.read(ptr, int32(size))
+	if err != nil {
+		return nil, fmt.Errorf("read array buffer content: %w", err)
+	}
+
+	return append([]byte(nil), bytes...), nil
+}
+
+// ReadArrayView reads the backing data of the typed array or `Array<T>` at `ptr`. For an
+// `Array<T>` this is its whole buffer, unused capacity included, see ReadArray.
+func (h *AscHeap) ReadArrayView(ptr int32) ([]byte, error) {
+	view, err := h.read(ptr, ascArrayBufferViewSize)
+	if err != nil {
+		return nil, fmt.Errorf("read array view: %w", err)
+	}
+
+	dataStart := int32(encoding.Uint32(view[4:]))
+	byteLength := int32(encoding.Uint32(view[8:]))
+
+	bytes, err := h.read(dataStart, byteLength)
+	if err != nil {
+		return nil, fmt.Errorf("read array view content: %w", err)
+	}
+
+	return append([]byte(nil), bytes...), nil
+}
+
+// ReadArray reads the elements of `elementSize` bytes of the typed array or `Array<T>` at
+// `ptr`. The buffer of an `Array<T>` grows ahead of its content, only its first `length`
+// elements are returned.
+func (h *AscHeap) ReadArray(ptr int32, elementSize int32) ([]byte, error) {
+	size, err := h.ObjectSize(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < ascArraySize {
+		return h.ReadArrayView(ptr)
+	}
+
+	view, err := h.read(ptr, ascArraySize)
+	if err != nil {
+		return nil, fmt.Errorf("read array: %w", err)
+	}
+
+	dataStart := int32(encoding.Uint32(view[4:]))
+	byteLength := int64(encoding.Uint32(view[8:]))
+	length := int64(encoding.Uint32(view[12:]))
+	if length*int64(elementSize) > byteLength {
+		return nil, fmt.Errorf("array length %d of %d byte(s) elements exceeds its buffer of %d byte(s)", length, elementSize, byteLength)
+	}
+
+	bytes, err := h.read(dataStart, int32(length*int64(elementSize)))
+	if err != nil {
+		return nil, fmt.Errorf("read array content: %w", err)
+	}
+
+	return append([]byte(nil), bytes...), nil
+}
+
+func (h *AscHeap) read(ptr int32, length int32) ([]byte, error) {
+	data, err := memorySegment(h.memory.Data(), ptr, length)
+	if err != nil {
+		return nil, err
+	}
+
+	h.tracker.read(length)
+	return data, nil
+}
+
+func (h *AscHeap) write(ptr int32, bytes []byte) error {
+	if int64(len(bytes)) > math.MaxInt32 {
+		return fmt.Errorf("%d bytes exceed the memory address space", len(bytes))
+	}
+
+	data, err := h.writable(ptr, int32(len(bytes)))
+	if err != nil {
+		return err
+	}
+
+	copy(data, bytes)
+	return nil
+}
+
+// writable returns the segment of `length` bytes at `ptr` for the host to write in it.
+func (h *AscHeap) writable(ptr int32, length int32) ([]byte, error) {
+	data, err := memorySegment(h.memory.Data(), ptr, length)
+	if err != nil {
+		return nil, err
+	}
+
+	h.tracker.written(length)
+	return data, nil
+}
+
+func alignTo(value int32, alignment int32) int32 {
+	return (value + alignment - 1) &^ (alignment - 1)
+}
+
+// writeRoot runs `write`, allocating an object along with the objects it references. The
+// collector of the module may run on any allocation: the objects allocated through `__new`
+// are pinned until the root object is written and references them all, nested calls
+// leaving them to the outermost one.
+func (h *AscHeap) writeRoot(write func() (int32, error)) (int32, error) {
+	h.rootDepth++
+	ptr, err := write()
+	h.rootDepth--
+
+	if h.rootDepth > 0 {
+		return ptr, err
+	}
+
+	pinned := h.pinned
+	h.pinned = nil
+	for _, object := range pinned {
+		if _, unpinErr := h.ascUnpin(object); unpinErr != nil && err == nil {
+			err = fmt.Errorf("calling __unpin(%d): %w", object, unpinErr)
+		}
+	}
+
+	return ptr, err
+}
+
+// pin pins the object at `ptr` while a root object is written, see writeRoot.
+func (h *AscHeap) pin(ptr int32) error {
+	if h.rootDepth == 0 || h.ascPin == nil || h.ascUnpin == nil {
+		return nil
+	}
+
+	if _, err := h.ascPin(ptr); err != nil {
+		return fmt.Errorf("calling __pin(%d): %w", ptr, err)
+	}
+
+	h.pinned = append(h.pinned, ptr)
+	return nil
+}
+
+// ascRuntimeFunction returns the `name` function of the AssemblyScript runtime exported by
+// the module, nil when it is not exported.
+func ascRuntimeFunction(instance *wasmer.Instance, name string) wasmer.NativeFunction {
+	function, err := instance.Exports.GetFunction(name)
+	if err != nil {
+		return nil
+	}
+	return function
+}
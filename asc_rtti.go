@@ -0,0 +1,376 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"reflect"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// AscTypeFlags describes the shape of an AssemblyScript class as recorded in the runtime
+// type information (`__rtti_base`) emitted by the compiler.
+type AscTypeFlags uint32
+
+const (
+	AscTypeArrayBufferView AscTypeFlags = 1 << 0
+	AscTypeArray           AscTypeFlags = 1 << 1
+	AscTypeStaticArray     AscTypeFlags = 1 << 2
+	AscTypeSet             AscTypeFlags = 1 << 3
+	AscTypeMap             AscTypeFlags = 1 << 4
+	AscTypePointerFree     AscTypeFlags = 1 << 5
+	AscTypeValueSigned     AscTypeFlags = 1 << 11
+	AscTypeValueFloat      AscTypeFlags = 1 << 12
+	AscTypeValueNullable   AscTypeFlags = 1 << 13
+	AscTypeValueManaged    AscTypeFlags = 1 << 14
+	AscTypeKeySigned       AscTypeFlags = 1 << 20
+	AscTypeKeyFloat        AscTypeFlags = 1 << 21
+	AscTypeKeyNullable     AscTypeFlags = 1 << 22
+	AscTypeKeyManaged      AscTypeFlags = 1 << 23
+
+	ascTypeValueAlignOffset = 6
+	ascTypeKeyAlignOffset   = 15
+
+	// ascTypeInfoSize is the size of an entry of the type information table, holding the
+	// flags of the type only since AssemblyScript 0.18.
+	ascTypeInfoSize = 4
+)
+
+// ValueAlign returns the log2 of the size of the values (elements) held by the type, false
+// when the flags carry no value alignment.
+func (f AscTypeFlags) ValueAlign() (uint32, bool) {
+	return ascAlign(uint32(f) >> ascTypeValueAlignOffset)
+}
+
+// KeyAlign returns the log2 of the size of the keys held by a `Map` or `Set` type, false
+// when the flags carry no key alignment.
+func (f AscTypeFlags) KeyAlign() (uint32, bool) {
+	return ascAlign(uint32(f) >> ascTypeKeyAlignOffset)
+}
+
+func ascAlign(alignBits uint32) (uint32, bool) {
+	if alignBits&31 == 0 {
+		return 0, false
+	}
+	return uint32(31 - bits.LeadingZeros32(alignBits&31)), true
+}
+
+type AscTypeInfo struct {
+	ID    uint32
+	Flags AscTypeFlags
+}
+
+// AscRTTI is the runtime type information table of an AssemblyScript module.
+type AscRTTI struct {
+	types []AscTypeInfo
+}
+
+// LoadAscRTTI reads the runtime type information table located at `base`, the value of
+// the `__rtti_base` global exported by the module.
+func LoadAscRTTI(heap *AscHeap, base int32) (*AscRTTI, error) {
+	countBytes, err := heap.read(base, 4)
+	if err != nil {
+		return nil, fmt.Errorf("read rtti count: %w", err)
+	}
+
+	// The count comes from the guest, it is checked against the memory before allocating
+	count := encoding.Uint32(countBytes)
+	available := (int64(len(heap.memory.Data())) - int64(uint32(base)) - 4) / ascTypeInfoSize
+	if int64(count) > available {
+		return nil, fmt.Errorf("rtti table of %d types at %d does not fit in memory", count, uint32(base))
+	}
+
+	table, err := heap.read(base+4, int32(count)*ascTypeInfoSize)
+	if err != nil {
+		return nil, fmt.Errorf("read rtti table of %d types: %w", count, err)
+	}
+
+	rtti := &AscRTTI{types: make([]AscTypeInfo, count)}
+	for i := range rtti.types {
+		rtti.types[i] = AscTypeInfo{
+			ID:    uint32(i),
+			Flags: AscTypeFlags(encoding.Uint32(table[i*ascTypeInfoSize:])),
+		}
+	}
+
+	return rtti, nil
+}
+
+func (r *AscRTTI) TypeInfo(classID uint32) (AscTypeInfo, bool) {
+	if int(classID) >= len(r.types) {
+		return AscTypeInfo{}, false
+	}
+	return r.types[classID], true
+}
+
+// AscObject is an instance of a class that has no generic representation, it's returned
+// as is with its raw payload.
+type AscObject struct {
+	ClassID uint32
+	Ptr     int32
+	Data    []byte
+}
+
+func newAscRTTI(instance *wasmer.Instance, heap *AscHeap) (*AscRTTI, error) {
+	global, err := instance.Exports.GetGlobal("__rtti_base")
+	if err != nil {
+		return nil, nil
+	}
+
+	base, err := global.Get()
+	if err != nil {
+		return nil, fmt.Errorf("get __rtti_base value: %w", err)
+	}
+
+	ptr, ok := base.(int32)
+	if !ok {
+		return nil, fmt.Errorf("__rtti_base global is %T, expected i32", base)
+	}
+
+	return LoadAscRTTI(heap, ptr)
+}
+
+// maxAscDecodeDepth bounds the recursion when decoding nested managed values, protecting
+// us against reference cycles.
+const maxAscDecodeDepth = 64
+
+// Decode turns the managed object at `ptr` into a generic Go value using the runtime type
+// information of the module: `String` becomes a `string`, `ArrayBuffer` and `Uint8Array`
+// become `[]byte`, other arrays, typed arrays and sets become `[]interface{}`, maps become
+// `map[interface{}]interface{}` and any other class is returned as an `*AscObject`. A null
+// pointer decodes to `nil`.
+func (h *AscHeap) Decode(ptr int32) (interface{}, error) {
+	if h.rtti == nil {
+		return nil, fmt.Errorf("no runtime type information available, module does not export __rtti_base")
+	}
+
+	return h.decode(ptr, 0)
+}
+
+func (h *AscHeap) decode(ptr int32, depth int) (interface{}, error) {
+	if ptr == 0 {
+		return nil, nil
+	}
+
+	if depth > maxAscDecodeDepth {
+		return nil, fmt.Errorf("maximum decoding depth %d reached", maxAscDecodeDepth)
+	}
+
+	classID, err := h.ObjectClassID(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch classID {
+	case AscArrayBufferID:
+		return h.ReadArrayBuffer(ptr)
+	case AscStringID:
+		return h.ReadString(ptr)
+	}
+
+	info, found := h.rtti.TypeInfo(classID)
+	if !found {
+		return nil, fmt.Errorf("unknown class id %d at %d", classID, ptr)
+	}
+
+	switch {
+	case info.Flags&AscTypeArrayBufferView != 0:
+		align, ok := info.Flags.ValueAlign()
+		if !ok {
+			return nil, fmt.Errorf("class %d has no value alignment", classID)
+		}
+
+		buffer, err := h.ReadArray(ptr, 1<<align)
+		if err != nil {
+			return nil, err
+		}
+
+		if info.Flags&AscTypeArray == 0 && align == 0 && info.Flags&(AscTypeValueSigned|AscTypeValueFloat) == 0 {
+			return buffer, nil
+		}
+		return h.decodeElements(buffer, info.Flags, align, depth)
+
+	case info.Flags&AscTypeStaticArray != 0:
+		align, ok := info.Flags.ValueAlign()
+		if !ok {
+			return nil, fmt.Errorf("class %d has no value alignment", classID)
+		}
+
+		size, err := h.ObjectSize(ptr)
+		if err != nil {
+			return nil, err
+		}
+
+		buffer, err := h.read(ptr, int32(size))
+		if err != nil {
+			return nil, fmt.Errorf("read static array content: %w", err)
+		}
+		return h.decodeElements(buffer, info.Flags, align, depth)
+
+	case info.Flags&(AscTypeMap|AscTypeSet) != 0:
+		return h.decodeMapOrSet(ptr, classID, info.Flags, depth)
+	}
+
+	size, err := h.ObjectSize(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := h.read(ptr, int32(size))
+	if err != nil {
+		return nil, fmt.Errorf("read object content: %w", err)
+	}
+
+	return &AscObject{ClassID: classID, Ptr: ptr, Data: append([]byte(nil), data...)}, nil
+}
+
+func (h *AscHeap) decodeElements(buffer []byte, flags AscTypeFlags, align uint32, depth int) ([]interface{}, error) {
+	elementSize := 1 << align
+	managed := flags&AscTypeValueManaged != 0
+	float := flags&AscTypeValueFloat != 0
+	signed := flags&AscTypeValueSigned != 0
+
+	out := make([]interface{}, len(buffer)/elementSize)
+	for i := range out {
+		value, err := h.decodeValue(buffer[i*elementSize:], elementSize, managed, float, signed, depth)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		out[i] = value
+	}
+
+	return out, nil
+}
+
+// decodeMapOrSet walks the entries buffer of a `Map<K, V>` or `Set<K>`, each entry being
+// laid out as `{ key: K, value: V, taggedNext: usize }` (no value for sets) and aligned on
+// the largest of its members.
+func (h *AscHeap) decodeMapOrSet(ptr int32, classID uint32, flags AscTypeFlags, depth int) (interface{}, error) {
+	header, err := h.read(ptr, 24)
+	if err != nil {
+		return nil, fmt.Errorf("read map header: %w", err)
+	}
+
+	entriesPtr := int32(encoding.Uint32(header[8:]))
+	entriesOffset := int32(encoding.Uint32(header[16:]))
+
+	isMap := flags&AscTypeMap != 0
+	keyAlign, ok := flags.KeyAlign()
+	if !ok {
+		return nil, fmt.Errorf("class %d has no key alignment", classID)
+	}
+
+	keySize := int32(1) << keyAlign
+	valueSize := int32(0)
+	if isMap {
+		valueAlign, ok := flags.ValueAlign()
+		if !ok {
+			return nil, fmt.Errorf("class %d has no value alignment", classID)
+		}
+		valueSize = 1 << valueAlign
+	}
+
+	entryAlign := maxInt32(keySize, valueSize, 4)
+	valueOffset := alignTo(keySize, maxInt32(valueSize, 1))
+	nextOffset := alignTo(valueOffset+valueSize, 4)
+	entrySize := alignTo(nextOffset+4, entryAlign)
+
+	if entriesOffset < 0 || int64(entriesOffset)*int64(entrySize) > int64(len(h.memory.Data())) {
+		return nil, fmt.Errorf("map of %d entries of %d bytes does not fit in memory", entriesOffset, entrySize)
+	}
+
+	entries, err := h.read(entriesPtr, entriesOffset*entrySize)
+	if err != nil {
+		return nil, fmt.Errorf("read map entries: %w", err)
+	}
+
+	keyManaged, keyFloat, keySigned := flags&AscTypeKeyManaged != 0, flags&AscTypeKeyFloat != 0, flags&AscTypeKeySigned != 0
+	valueManaged, valueFloat, valueSigned := flags&AscTypeValueManaged != 0, flags&AscTypeValueFloat != 0, flags&AscTypeValueSigned != 0
+
+	var mapOut map[interface{}]interface{}
+	var setOut []interface{}
+	if isMap {
+		mapOut = map[interface{}]interface{}{}
+	}
+
+	for i := int32(0); i < entriesOffset; i++ {
+		entry := entries[i*entrySize:]
+		if encoding.Uint32(entry[nextOffset:])&1 != 0 {
+			// Entry was deleted
+			continue
+		}
+
+		key, err := h.decodeValue(entry, int(keySize), keyManaged, keyFloat, keySigned, depth)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d key: %w", i, err)
+		}
+
+		if !isMap {
+			setOut = append(setOut, key)
+			continue
+		}
+
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return nil, fmt.Errorf("entry %d key of type %T cannot be used as a map key", i, key)
+		}
+
+		value, err := h.decodeValue(entry[valueOffset:], int(valueSize), valueManaged, valueFloat, valueSigned, depth)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d value: %w", i, err)
+		}
+		mapOut[key] = value
+	}
+
+	if isMap {
+		return mapOut, nil
+	}
+	return setOut, nil
+}
+
+func (h *AscHeap) decodeValue(in []byte, size int, managed, float, signed bool, depth int) (interface{}, error) {
+	if managed {
+		return h.decode(int32(encoding.Uint32(in)), depth+1)
+	}
+
+	switch {
+	case float && size == 4:
+		return math.Float32frombits(encoding.Uint32(in)), nil
+	case float && size == 8:
+		return math.Float64frombits(encoding.Uint64(in)), nil
+	}
+
+	switch size {
+	case 1:
+		if signed {
+			return int8(in[0]), nil
+		}
+		return in[0], nil
+	case 2:
+		if signed {
+			return int16(encoding.Uint16(in)), nil
+		}
+		return encoding.Uint16(in), nil
+	case 4:
+		if signed {
+			return int32(encoding.Uint32(in)), nil
+		}
+		return encoding.Uint32(in), nil
+	case 8:
+		if signed {
+			return int64(encoding.Uint64(in)), nil
+		}
+		return encoding.Uint64(in), nil
+	}
+
+	return nil, fmt.Errorf("unhandled value of %d bytes (float %t)", size, float)
+}
+
+func maxInt32(values ...int32) (out int32) {
+	for _, value := range values {
+		if value > out {
+			out = value
+		}
+	}
+	return
+}
@@ -0,0 +1,150 @@
+package wasm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAscHeap_Decode(t *testing.T) {
+	heap := newTestAscHeap(t)
+
+	const (
+		int32ArrayID  = 3
+		stringArrayID = 4
+		objectID      = 5
+	)
+
+	rtti := make([]byte, 4+6*ascTypeInfoSize)
+	encoding.PutUint32(rtti, 6)
+	encoding.PutUint32(rtti[4+int32ArrayID*ascTypeInfoSize:], uint32(AscTypeArrayBufferView|4<<ascTypeValueAlignOffset|AscTypeValueSigned))
+	encoding.PutUint32(rtti[4+stringArrayID*ascTypeInfoSize:], uint32(AscTypeArrayBufferView|AscTypeArray|4<<ascTypeValueAlignOffset|AscTypeValueManaged))
+
+	rttiBase, err := heap.Write(rtti)
+	require.NoError(t, err)
+	heap.rtti, err = LoadAscRTTI(heap, rttiBase)
+	require.NoError(t, err)
+
+	first, err := heap.WriteString("hello")
+	require.NoError(t, err)
+	second, err := heap.WriteString("world")
+	require.NoError(t, err)
+
+	strings := make([]byte, 12)
+	encoding.PutUint32(strings[0:], uint32(first))
+	encoding.PutUint32(strings[8:], uint32(second))
+	stringsPtr, err := heap.WriteArray(stringArrayID, strings, 3)
+	require.NoError(t, err)
+
+	integers := make([]byte, 8)
+	encoding.PutUint32(integers[0:], uint32(1))
+	encoding.PutUint32(integers[4:], uint32(0xFFFFFFFF))
+	integersPtr, err := heap.WriteTypedArray(int32ArrayID, integers)
+	require.NoError(t, err)
+
+	bytesPtr, err := heap.WriteArrayBuffer([]byte{0xAA})
+	require.NoError(t, err)
+
+	objectPtr, err := heap.NewObject(4, objectID)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		ptr      int32
+		expected interface{}
+	}{
+		{"null", 0, nil},
+		{"string", first, "hello"},
+		{"array buffer", bytesPtr, []byte{0xAA}},
+		{"array of strings", stringsPtr, []interface{}{"hello", nil, "world"}},
+		{"typed array", integersPtr, []interface{}{int32(1), int32(-1)}},
+		{"object", objectPtr, &AscObject{ClassID: objectID, Ptr: objectPtr, Data: []byte{0, 0, 0, 0}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := heap.Decode(test.ptr)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestAscHeap_DecodeArrayCapacity(t *testing.T) {
+	heap := newTestAscHeap(t)
+
+	const int32ArrayID = 3
+
+	rtti := make([]byte, 4+4*ascTypeInfoSize)
+	encoding.PutUint32(rtti, 4)
+	encoding.PutUint32(rtti[4+int32ArrayID*ascTypeInfoSize:], uint32(AscTypeArrayBufferView|AscTypeArray|4<<ascTypeValueAlignOffset|AscTypeValueSigned))
+
+	rttiBase, err := heap.Write(rtti)
+	require.NoError(t, err)
+	heap.rtti, err = LoadAscRTTI(heap, rttiBase)
+	require.NoError(t, err)
+
+	// Room for 4 elements, as after pushing a third one in an array of 2, holding 2.
+	integers := make([]byte, 16)
+	encoding.PutUint32(integers[0:], uint32(7))
+	encoding.PutUint32(integers[4:], uint32(0xFFFFFFFF))
+	encoding.PutUint32(integers[8:], uint32(9))
+	ptr, err := heap.WriteArray(int32ArrayID, integers, 2)
+	require.NoError(t, err)
+
+	actual, err := heap.Decode(ptr)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{int32(7), int32(-1)}, actual)
+
+	var slice []int32
+	require.NoError(t, heap.readAscReference(ptr, reflect.ValueOf(&slice).Elem(), ascFieldOptions{}))
+	assert.Equal(t, []int32{7, -1}, slice)
+
+	overflow, err := heap.WriteArray(int32ArrayID, integers, 5)
+	require.NoError(t, err)
+	_, err = heap.Decode(overflow)
+	assert.EqualError(t, err, "array length 5 of 4 byte(s) elements exceeds its buffer of 16 byte(s)")
+}
+
+func TestLoadAscRTTI_CountOutOfMemory(t *testing.T) {
+	heap := newTestAscHeap(t)
+
+	rtti := make([]byte, 4)
+	encoding.PutUint32(rtti, 0x20000001)
+
+	base, err := heap.Write(rtti)
+	require.NoError(t, err)
+	_, err = LoadAscRTTI(heap, base)
+	require.Error(t, err)
+}
+
+func TestAscHeap_DecodeNoAlignment(t *testing.T) {
+	heap := newTestAscHeap(t)
+
+	const (
+		viewID = 3
+		mapID  = 4
+	)
+
+	rtti := make([]byte, 4+5*ascTypeInfoSize)
+	encoding.PutUint32(rtti, 5)
+	encoding.PutUint32(rtti[4+viewID*ascTypeInfoSize:], uint32(AscTypeArrayBufferView))
+	encoding.PutUint32(rtti[4+mapID*ascTypeInfoSize:], uint32(AscTypeMap|2<<ascTypeValueAlignOffset))
+
+	rttiBase, err := heap.Write(rtti)
+	require.NoError(t, err)
+	heap.rtti, err = LoadAscRTTI(heap, rttiBase)
+	require.NoError(t, err)
+
+	viewPtr, err := heap.WriteTypedArray(viewID, []byte{1, 2, 3})
+	require.NoError(t, err)
+	_, err = heap.Decode(viewPtr)
+	assert.EqualError(t, err, "class 3 has no value alignment")
+
+	mapPtr, err := heap.NewObject(24, mapID)
+	require.NoError(t, err)
+	_, err = heap.Decode(mapPtr)
+	assert.EqualError(t, err, "class 4 has no key alignment")
+}
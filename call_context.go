@@ -0,0 +1,73 @@
+package wasm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+	"go.uber.org/zap"
+)
+
+// CallContext describes the invocation a host function is called for. A new one is created
+// for each call into a module (see Instance.ExecuteContext), it must not be retained once
+// the host function returned.
+type CallContext struct {
+	// Context is the context the call was made with, caller supplied values (block number,
+	// request id, ...) are retrieved through Value.
+	Context context.Context
+
+	Module       string
+	Function     string
+	InvocationID uint64
+
+	// Memory accesses the memory of the instance running the call, allocating through its
+	// allocator.
+	Memory MemoryAccess
+
+	// Environment is the environment the runtime was created with.
+	Environment Environment
+
+	invocation *invocation
+}
+
+// Value returns the value associated with `key` in the context of the call.
+func (c *CallContext) Value(key interface{}) interface{} {
+	return c.Context.Value(key)
+}
+
+// Logger returns the runtime logger annotated with the module, function and invocation id
+// of the call.
+func (c *CallContext) Logger() *zap.Logger {
+	return c.invocation.logger()
+}
+
+// LogSegment logs the `length` bytes at `ptr` in hexadecimal at debug level.
+func (c *CallContext) LogSegment(message string, ptr int32, length int32) {
+	bytes, err := c.Memory.ReadBytes(ptr, length)
+	if err != nil {
+		c.Logger().Info("unable to obtain data segment for "+message, zap.Int32("ptr", ptr), zap.Int32("length", length), zap.Error(err))
+		return
+	}
+
+	c.Logger().Debug(message, zap.Stringer("bytes", hexBytes(bytes)))
+}
+
+// HostFunction implements a function imported by modules, called with the context of the
+// invocation it serves. Returning an error aborts the invocation, the error is returned by
+// the call into the module.
+type HostFunction func(ctx *CallContext, args []wasmer.Value) ([]wasmer.Value, error)
+
+// WithHostFunction makes `function` importable by modules as `name` from `module`, taking
+// `params` and returning `results`. It replaces the built-in function of the same name if
+// any.
+func WithHostFunction(module, name string, params []wasmer.ValueKind, results []wasmer.ValueKind, function HostFunction) RuntimeOption {
+	if function == nil {
+		panic(fmt.Errorf("host function %s/%s is nil", module, name))
+	}
+
+	return func(r *Runtime) {
+		r.hostFunctions = append(r.hostFunctions, intrinsics(module, name, wasmer.NewValueTypes(params...), wasmer.NewValueTypes(results...), func(call *invocation, args []wasmer.Value) ([]wasmer.Value, error) {
+			return function(call.context, args)
+		}))
+	}
+}
@@ -0,0 +1,80 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+const callContextTestModule = `
+(module
+  (import "chain" "block_number" (func $block_number (result i64)))
+  (import "chain" "record" (func $record (param i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "transfer")
+
+  (func (export "handle") (result i64)
+    (call $record (i32.const 1024) (i32.const 8))
+    (call $block_number)))
+`
+
+type blockNumberKey struct{}
+
+func TestCallContext(t *testing.T) {
+	var contexts []CallContext
+	var records []string
+
+	runtime := NewRuntime(&RustEnvironment{},
+		WithHostFunction("chain", "block_number", nil, []wasmer.ValueKind{wasmer.I64}, func(ctx *CallContext, args []wasmer.Value) ([]wasmer.Value, error) {
+			contexts = append(contexts, *ctx)
+
+			blockNumber, ok := ctx.Value(blockNumberKey{}).(int64)
+			if !ok {
+				return nil, errors.New("no block number")
+			}
+			return []wasmer.Value{wasmer.NewI64(blockNumber)}, nil
+		}),
+		WithHostFunction("chain", "record", []wasmer.ValueKind{wasmer.I32, wasmer.I32}, nil, func(ctx *CallContext, args []wasmer.Value) ([]wasmer.Value, error) {
+			record, err := ctx.Memory.ReadString(args[0].I32(), args[1].I32())
+			if err != nil {
+				return nil, err
+			}
+
+			records = append(records, record)
+			return nil, nil
+		}),
+	)
+
+	instance, err := runtime.Instantiate(writeTestModule(t, callContextTestModule))
+	require.NoError(t, err)
+	defer instance.Close()
+
+	actual, err := instance.ExecuteContext(context.WithValue(context.Background(), blockNumberKey{}, int64(42)), "handle", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), actual.Value)
+
+	actual, err = instance.ExecuteContext(context.WithValue(context.Background(), blockNumberKey{}, int64(43)), "handle", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(43), actual.Value)
+
+	assert.Equal(t, []string{"transfer", "transfer"}, records)
+	require.Len(t, contexts, 2)
+	for _, ctx := range contexts {
+		assert.Equal(t, "module", ctx.Module)
+		assert.Equal(t, "handle", ctx.Function)
+	}
+	assert.NotEqual(t, contexts[0].InvocationID, contexts[1].InvocationID)
+
+	_, err = instance.Execute("handle", nil)
+	assert.EqualError(t, err, `unable to execute wasm module function "handle" from "`+instance.wasmFile+`": no block number`)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = instance.ExecuteContext(cancelled, "handle", nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Len(t, records, 3)
+}
@@ -0,0 +1,53 @@
+// Command wasm-preinit pre-initializes a wasm module: it runs the module initialization
+// function and writes a new module starting with the memory and globals the initialization
+// left, see Runtime.PreInitialize.
+//
+//	wasm-preinit -init init -o module.preinit.wasm module.wasm
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	wasm "github.com/streamingfast/wasm-runtime"
+)
+
+func main() {
+	initFunction := flag.String("init", "_initialize", "export initializing the module")
+	output := flag.String("o", "", "output file, defaults to the input file with the .preinit.wasm extension")
+	withWASI := flag.Bool("wasi", false, "provide the WASI imports to the module, inheriting the standard streams")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <module.wasm>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	wasmFile := flag.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(wasmFile, ".wasm") + ".preinit.wasm"
+	}
+
+	var options []wasm.RuntimeOption
+	if *withWASI {
+		options = append(options, wasm.WithWASI(wasm.WASIConfig{}))
+	}
+
+	out, err := wasm.NewRuntime(&wasm.RustEnvironment{}, options...).PreInitialize(wasmFile, *initFunction)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := ioutil.WriteFile(*output, out, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,104 @@
+package wasm
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Codec serializes Go values into the bytes a guest expects and back, it's used to pass
+// parameters (see Encode and WithCodec) and to decode output slots (see NewCodecOutput).
+type Codec interface {
+	Name() string
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, out interface{}) error
+}
+
+// Built-in codecs following the wire format of their Rust counterpart (`serde_json`,
+// `borsh` and `bincode` with its default options).
+var (
+	JSONCodec    Codec = jsonCodec{}
+	BorshCodec   Codec = &binaryCodec{name: "borsh", lengthSize: 4, rejectNaN: true}
+	BincodeCodec Codec = &binaryCodec{name: "bincode", lengthSize: 8}
+)
+
+// WithCodec sets the codec used to serialize parameters that have no native wasm
+// representation (structs, maps, slices other than `[]byte`, ...), they are passed as a
+// `(ptr, len)` pair pointing to the serialized bytes.
+func WithCodec(codec Codec) RuntimeOption {
+	return func(r *Runtime) {
+		r.codec = codec
+	}
+}
+
+// Encode returns a parameter serializing `value` with `codec`, it's passed as a
+// `(ptr, len)` pair pointing to the serialized bytes.
+func Encode(codec Codec, value interface{}) Marshaler {
+	return encodedParameter{codec, value}
+}
+
+type encodedParameter struct {
+	codec Codec
+	value interface{}
+}
+
+func (p encodedParameter) MarshalWASM(heap *AscHeap) ([]interface{}, error) {
+	bytes, err := p.codec.Marshal(p.value)
+	if err != nil {
+		return nil, fmt.Errorf("%s marshal %T: %w", p.codec.Name(), p.value, err)
+	}
+
+	ptr, err := heap.reserve(len(bytes))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := heap.write(ptr, bytes); err != nil {
+		return nil, err
+	}
+
+	return []interface{}{ptr, int32(len(bytes))}, nil
+}
+
+// NewCodecOutput creates an output slot receiving a `(ptr, len)` pair pointing to bytes
+// serialized with `codec`, decoded as a value of the same type as `prototype`.
+func NewCodecOutput(name string, codec Codec, prototype interface{}) *AscReturnValue {
+	return NewOutput(name, codecOutput{codec, reflect.TypeOf(prototype)})
+}
+
+type codecOutput struct {
+	codec Codec
+	typ   reflect.Type
+}
+
+func (o codecOutput) SlotSize() int32 {
+	return 8
+}
+
+func (o codecOutput) Decode(slot []byte, read MemoryReader) (interface{}, error) {
+	data, err := bytesOutput{}.Decode(slot, read)
+	if err != nil {
+		return nil, err
+	}
+
+	out := reflect.New(o.typ)
+	if err := o.codec.Unmarshal(data.([]byte), out.Interface()); err != nil {
+		return nil, fmt.Errorf("%s unmarshal %s: %w", o.codec.Name(), o.typ, err)
+	}
+
+	return out.Elem().Interface(), nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, out interface{}) error {
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,470 @@
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// binaryCodec implements the Borsh and bincode wire formats which only differ in the
+// size of their length prefixes: integers and floats are little-endian fixed size values,
+// strings, slices and maps are prefixed by their length (u32 for Borsh, u64 for bincode),
+// fixed size arrays and structs are their elements/fields in order and pointers are
+// `Option<T>`, a u8 tag (0 for `None`, 1 for `Some`) followed by the value. Map entries are
+// written in key order so the encoding is deterministic. Go `int` and `uint` are encoded as
+// 64 bits integers.
+type binaryCodec struct {
+	name       string
+	lengthSize int
+	rejectNaN  bool
+}
+
+func (c *binaryCodec) Name() string {
+	return c.name
+}
+
+func (c *binaryCodec) Marshal(value interface{}) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	if err := c.encode(buffer, reflect.ValueOf(value)); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (c *binaryCodec) Unmarshal(data []byte, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("expected a non-nil pointer, got %T", out)
+	}
+
+	decoder := &binaryDecoder{codec: c, data: data}
+	if err := decoder.decode(rv.Elem()); err != nil {
+		return err
+	}
+
+	if len(decoder.data) > 0 {
+		return fmt.Errorf("%d trailing byte(s) after value", len(decoder.data))
+	}
+
+	return nil
+}
+
+func (c *binaryCodec) encode(buffer *bytes.Buffer, rv reflect.Value) error {
+	scratch := make([]byte, 8)
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			buffer.WriteByte(1)
+		} else {
+			buffer.WriteByte(0)
+		}
+	case reflect.Int8, reflect.Uint8:
+		buffer.WriteByte(byte(integerBits(rv)))
+	case reflect.Int16, reflect.Uint16:
+		encoding.PutUint16(scratch, uint16(integerBits(rv)))
+		buffer.Write(scratch[:2])
+	case reflect.Int32, reflect.Uint32:
+		encoding.PutUint32(scratch, uint32(integerBits(rv)))
+		buffer.Write(scratch[:4])
+	case reflect.Int64, reflect.Uint64, reflect.Int, reflect.Uint:
+		encoding.PutUint64(scratch, integerBits(rv))
+		buffer.Write(scratch)
+	case reflect.Float32, reflect.Float64:
+		if c.rejectNaN && math.IsNaN(rv.Float()) {
+			return fmt.Errorf("NaN is not allowed by %s", c.name)
+		}
+
+		if rv.Kind() == reflect.Float32 {
+			encoding.PutUint32(scratch, math.Float32bits(float32(rv.Float())))
+			buffer.Write(scratch[:4])
+		} else {
+			encoding.PutUint64(scratch, math.Float64bits(rv.Float()))
+			buffer.Write(scratch)
+		}
+
+	case reflect.String:
+		c.writeLength(buffer, rv.Len())
+		buffer.WriteString(rv.String())
+
+	case reflect.Slice:
+		c.writeLength(buffer, rv.Len())
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			buffer.Write(rv.Bytes())
+			return nil
+		}
+		return c.encodeElements(buffer, rv)
+
+	case reflect.Array:
+		return c.encodeElements(buffer, rv)
+
+	case reflect.Ptr:
+		if rv.IsNil() {
+			buffer.WriteByte(0)
+			return nil
+		}
+		buffer.WriteByte(1)
+		return c.encode(buffer, rv.Elem())
+
+	case reflect.Interface:
+		if rv.IsNil() {
+			return fmt.Errorf("cannot encode nil interface")
+		}
+		return c.encode(buffer, rv.Elem())
+
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			if rv.Type().Field(i).PkgPath != "" {
+				continue
+			}
+
+			if err := c.encode(buffer, rv.Field(i)); err != nil {
+				return fmt.Errorf("field %s.%s: %w", rv.Type(), rv.Type().Field(i).Name, err)
+			}
+		}
+
+	case reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return lessValue(keys[i], keys[j]) })
+
+		c.writeLength(buffer, len(keys))
+		for _, key := range keys {
+			if err := c.encode(buffer, key); err != nil {
+				return fmt.Errorf("map key: %w", err)
+			}
+			if err := c.encode(buffer, rv.MapIndex(key)); err != nil {
+				return fmt.Errorf("map value: %w", err)
+			}
+		}
+
+	default:
+		return fmt.Errorf("unhandled type %s", rv.Type())
+	}
+
+	return nil
+}
+
+func (c *binaryCodec) encodeElements(buffer *bytes.Buffer, rv reflect.Value) error {
+	for i := 0; i < rv.Len(); i++ {
+		if err := c.encode(buffer, rv.Index(i)); err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (c *binaryCodec) writeLength(buffer *bytes.Buffer, length int) {
+	scratch := make([]byte, 8)
+	encoding.PutUint64(scratch, uint64(length))
+	buffer.Write(scratch[:c.lengthSize])
+}
+
+// maxZeroSizedLength bounds the length of the sequences of zero-sized elements (`[]struct{}`,
+// `Vec<()>`) which cannot be checked against the remaining data. It also keeps the bincode u64
+// lengths within an int on every platform.
+const maxZeroSizedLength = math.MaxInt32
+
+type binaryDecoder struct {
+	codec *binaryCodec
+	data  []byte
+}
+
+func (d *binaryDecoder) next(size int) ([]byte, error) {
+	if size > len(d.data) {
+		return nil, fmt.Errorf("unexpected end of data, needed %d byte(s), %d left", size, len(d.data))
+	}
+
+	out := d.data[:size]
+	d.data = d.data[size:]
+	return out, nil
+}
+
+func (d *binaryDecoder) readLength(elementMinSize int) (int, error) {
+	bytes, err := d.next(d.codec.lengthSize)
+	if err != nil {
+		return 0, err
+	}
+
+	length := uint64(encoding.Uint32(bytes))
+	if d.codec.lengthSize == 8 {
+		length = encoding.Uint64(bytes)
+	}
+
+	// Every element takes at least one byte (except zero-sized ones), a longer length is
+	// necessarily invalid and would only make us allocate for nothing. Zero-sized elements
+	// consume no data, their count is only bounded by maxZeroSizedLength.
+	if elementMinSize > 0 && length > uint64(len(d.data)/elementMinSize) {
+		return 0, fmt.Errorf("length %d exceeds remaining %d byte(s)", length, len(d.data))
+	}
+	if elementMinSize == 0 && length > maxZeroSizedLength {
+		return 0, fmt.Errorf("length %d of zero-sized elements exceeds the maximum of %d", length, maxZeroSizedLength)
+	}
+
+	return int(length), nil
+}
+
+func (d *binaryDecoder) decode(rv reflect.Value) error {
+	switch rv.Kind() {
+	case reflect.Bool:
+		bytes, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		if bytes[0] > 1 {
+			return fmt.Errorf("invalid bool value %d", bytes[0])
+		}
+		rv.SetBool(bytes[0] == 1)
+
+	case reflect.Int8, reflect.Uint8, reflect.Int16, reflect.Uint16, reflect.Int32, reflect.Uint32,
+		reflect.Int64, reflect.Uint64, reflect.Int, reflect.Uint:
+		size := int(rv.Type().Size())
+		bytes, err := d.next(size)
+		if err != nil {
+			return err
+		}
+
+		var bits uint64
+		switch size {
+		case 1:
+			bits = uint64(bytes[0])
+		case 2:
+			bits = uint64(encoding.Uint16(bytes))
+		case 4:
+			bits = uint64(encoding.Uint32(bytes))
+		default:
+			bits = encoding.Uint64(bytes)
+		}
+		setIntegerBits(rv, bits)
+
+	case reflect.Float32:
+		bytes, err := d.next(4)
+		if err != nil {
+			return err
+		}
+		rv.SetFloat(float64(math.Float32frombits(encoding.Uint32(bytes))))
+
+	case reflect.Float64:
+		bytes, err := d.next(8)
+		if err != nil {
+			return err
+		}
+		rv.SetFloat(math.Float64frombits(encoding.Uint64(bytes)))
+
+	case reflect.String:
+		length, err := d.readLength(1)
+		if err != nil {
+			return err
+		}
+		bytes, err := d.next(length)
+		if err != nil {
+			return err
+		}
+		rv.SetString(string(bytes))
+
+	case reflect.Slice:
+		elementType := rv.Type().Elem()
+		length, err := d.readLength(minEncodedSize(elementType))
+		if err != nil {
+			return err
+		}
+
+		if elementType.Kind() == reflect.Uint8 {
+			bytes, err := d.next(length)
+			if err != nil {
+				return err
+			}
+			rv.SetBytes(append([]byte{}, bytes...))
+			return nil
+		}
+
+		slice := reflect.MakeSlice(rv.Type(), length, length)
+		if err := d.decodeElements(slice); err != nil {
+			return err
+		}
+		rv.Set(slice)
+
+	case reflect.Array:
+		return d.decodeElements(rv)
+
+	case reflect.Ptr:
+		tag, err := d.next(1)
+		if err != nil {
+			return err
+		}
+
+		switch tag[0] {
+		case 0:
+			rv.Set(reflect.Zero(rv.Type()))
+		case 1:
+			element := reflect.New(rv.Type().Elem())
+			if err := d.decode(element.Elem()); err != nil {
+				return err
+			}
+			rv.Set(element)
+		default:
+			return fmt.Errorf("invalid option tag %d", tag[0])
+		}
+
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			if rv.Type().Field(i).PkgPath != "" {
+				continue
+			}
+
+			if err := d.decode(rv.Field(i)); err != nil {
+				return fmt.Errorf("field %s.%s: %w", rv.Type(), rv.Type().Field(i).Name, err)
+			}
+		}
+
+	case reflect.Map:
+		length, err := d.readLength(minEncodedSize(rv.Type().Key()) + minEncodedSize(rv.Type().Elem()))
+		if err != nil {
+			return err
+		}
+
+		// Zero-sized entries take no data, the size hint is bounded by what is left instead.
+		size := length
+		if size > len(d.data) {
+			size = len(d.data)
+		}
+		out := reflect.MakeMapWithSize(rv.Type(), size)
+		for i := 0; i < length; i++ {
+			key := reflect.New(rv.Type().Key()).Elem()
+			if err := d.decode(key); err != nil {
+				return fmt.Errorf("map key: %w", err)
+			}
+
+			value := reflect.New(rv.Type().Elem()).Elem()
+			if err := d.decode(value); err != nil {
+				return fmt.Errorf("map value: %w", err)
+			}
+			out.SetMapIndex(key, value)
+		}
+		rv.Set(out)
+
+	default:
+		return fmt.Errorf("unhandled type %s", rv.Type())
+	}
+
+	return nil
+}
+
+func (d *binaryDecoder) decodeElements(rv reflect.Value) error {
+	for i := 0; i < rv.Len(); i++ {
+		if err := d.decode(rv.Index(i)); err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// minEncodedSize returns the minimum number of bytes a value of type `typ` takes once
+// encoded, used to reject bogus lengths before allocating.
+func minEncodedSize(typ reflect.Type) int {
+	switch typ.Kind() {
+	case reflect.Struct:
+		size := 0
+		for i := 0; i < typ.NumField(); i++ {
+			size += minEncodedSize(typ.Field(i).Type)
+		}
+		return size
+	case reflect.Array:
+		return typ.Len() * minEncodedSize(typ.Elem())
+	case reflect.Bool, reflect.Int8, reflect.Uint8, reflect.Ptr:
+		return 1
+	case reflect.Int16, reflect.Uint16:
+		return 2
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		return 4
+	case reflect.Int64, reflect.Uint64, reflect.Int, reflect.Uint, reflect.Float64:
+		return 8
+	}
+
+	// Strings, slices and maps have at least their length prefix
+	return 4
+}
+
+func integerBits(rv reflect.Value) uint64 {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(rv.Int())
+	}
+	return rv.Uint()
+}
+
+func setIntegerBits(rv reflect.Value, bits uint64) {
+	switch rv.Kind() {
+	case reflect.Int8:
+		rv.SetInt(int64(int8(bits)))
+	case reflect.Int16:
+		rv.SetInt(int64(int16(bits)))
+	case reflect.Int32:
+		rv.SetInt(int64(int32(bits)))
+	case reflect.Int, reflect.Int64:
+		rv.SetInt(int64(bits))
+	default:
+		rv.SetUint(bits)
+	}
+}
+
+// lessValue orders map keys the way Rust's derived `Ord` would for the equivalent types,
+// numbers by value, strings lexicographically, fixed size arrays element by element, structs
+// field by field and `Option<T>` with `None` first.
+func lessValue(a, b reflect.Value) bool {
+	return compareValue(a, b) < 0
+}
+
+func compareValue(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareOrdered(a.Int() < b.Int(), a.Int() > b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareOrdered(a.Uint() < b.Uint(), a.Uint() > b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return compareOrdered(a.Float() < b.Float(), a.Float() > b.Float())
+	case reflect.String:
+		return compareOrdered(a.String() < b.String(), a.String() > b.String())
+	case reflect.Bool:
+		return compareOrdered(!a.Bool() && b.Bool(), a.Bool() && !b.Bool())
+
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < a.Len() && i < b.Len(); i++ {
+			if c := compareValue(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+		return compareOrdered(a.Len() < b.Len(), a.Len() > b.Len())
+
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if a.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if c := compareValue(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return compareOrdered(a.IsNil() && !b.IsNil(), !a.IsNil() && b.IsNil())
+		}
+		return compareValue(a.Elem(), b.Elem())
+	}
+
+	return compareOrdered(fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface()), fmt.Sprint(a.Interface()) > fmt.Sprint(b.Interface()))
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
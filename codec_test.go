@@ -0,0 +1,95 @@
+package wasm
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type codecTestStruct struct {
+	A uint8
+	B string
+	C []uint16
+	D *uint32
+	E map[string]int64
+}
+
+func TestCodecs_WireFormat(t *testing.T) {
+	five := uint32(5)
+	value := codecTestStruct{A: 1, B: "hi", C: []uint16{1, 2}, D: &five, E: map[string]int64{"z": -1, "a": 2}}
+
+	tests := []struct {
+		codec    Codec
+		expected string
+	}{
+		{BorshCodec, "01" + "02000000" + "6869" + "02000000" + "0100" + "0200" + "01" + "05000000" +
+			"02000000" + "01000000" + "61" + "0200000000000000" + "01000000" + "7a" + "ffffffffffffffff"},
+		{BincodeCodec, "01" + "0200000000000000" + "6869" + "0200000000000000" + "0100" + "0200" + "01" + "05000000" +
+			"0200000000000000" + "0100000000000000" + "61" + "0200000000000000" + "0100000000000000" + "7a" + "ffffffffffffffff"},
+		{JSONCodec, hex.EncodeToString([]byte(`{"A":1,"B":"hi","C":[1,2],"D":5,"E":{"a":2,"z":-1}}`))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.codec.Name(), func(t *testing.T) {
+			bytes, err := test.codec.Marshal(value)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, hex.EncodeToString(bytes))
+
+			actual := codecTestStruct{}
+			require.NoError(t, test.codec.Unmarshal(bytes, &actual))
+			assert.Equal(t, value, actual)
+		})
+	}
+}
+
+func TestCodecs_MapKeyOrder(t *testing.T) {
+	type key struct {
+		A uint8
+		B string
+	}
+
+	bytes, err := BorshCodec.Marshal(map[[2]byte]uint8{{10, 0}: 2, {9, 0}: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "02000000"+"0900"+"01"+"0a00"+"02", hex.EncodeToString(bytes))
+
+	bytes, err = BorshCodec.Marshal(map[key]uint8{{A: 10, B: "a"}: 3, {A: 9, B: "b"}: 2, {A: 9, B: "a"}: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "03000000"+"09"+"0100000061"+"01"+"09"+"0100000062"+"02"+"0a"+"0100000061"+"03", hex.EncodeToString(bytes))
+}
+
+func TestCodecs_InvalidInput(t *testing.T) {
+	var out []uint64
+	assert.EqualError(t, BorshCodec.Unmarshal([]byte{0xff, 0xff, 0xff, 0xff, 0x00}, &out), "length 4294967295 exceeds remaining 1 byte(s)")
+	assert.EqualError(t, BincodeCodec.Unmarshal([]byte{0x01}, &out), "unexpected end of data, needed 8 byte(s), 1 left")
+
+	var empty []struct{}
+	assert.EqualError(t, BincodeCodec.Unmarshal([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, &empty), "length 18446744073709551615 of zero-sized elements exceeds the maximum of 2147483647")
+	require.NoError(t, BorshCodec.Unmarshal([]byte{0x03, 0x00, 0x00, 0x00}, &empty))
+	assert.Len(t, empty, 3)
+}
+
+const codecTestModule = `
+(module
+  (memory (export "memory") 1)
+
+  (func (export "echo") (param $ptr i32) (param $len i32) (param $echo i32)
+    (i32.store (local.get $echo) (local.get $ptr))
+    (i32.store offset=4 (local.get $echo) (local.get $len))))
+`
+
+func TestRuntime_Codec(t *testing.T) {
+	wasmFile := writeTestModule(t, codecTestModule)
+	value := codecTestStruct{A: 1, B: "hi", C: []uint16{}, E: map[string]int64{}}
+
+	echo := NewCodecOutput("echo", BorshCodec, codecTestStruct{})
+	_, err := NewRuntime(&RustEnvironment{}, WithCodec(BorshCodec)).Execute(wasmFile, "echo", []interface{}{value}, echo)
+	require.NoError(t, err)
+	assert.Equal(t, value, echo.Value())
+
+	echo = NewCodecOutput("echo", JSONCodec, map[string]interface{}{})
+	_, err = NewRuntime(&RustEnvironment{}).Execute(wasmFile, "echo", []interface{}{Encode(JSONCodec, value)}, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "hi", echo.Value().(map[string]interface{})["B"])
+}
@@ -2,24 +2,25 @@ package wasm
 
 import (
 	"encoding/binary"
-	"encoding/hex"
-	"fmt"
-	"github.com/wasmerio/wasmer-go/wasmer"
-	"go.uber.org/zap"
 )
 
 var encoding = binary.LittleEndian
 
+// Environment is the long-lived environment of a Runtime, shared by all its invocations.
+// What is specific to an invocation, like the memory of the instance it runs on, is given
+// to the host functions through their CallContext.
+//
+// Environment no longer holds the memory of the last instance used, which concurrent
+// invocations overwrote, this is a breaking change: its memory methods are gone and host
+// functions use the CallContext they are called with instead.
+//
+//	env.SetMemory(memory), env.GetMemory()  the runtime binds CallContext.Memory to the instance
+//	env.ReadBytes(ptr, length)              ctx.Memory.ReadBytes(ptr, length)
+//	env.ReadString(ptr, length)             ctx.Memory.ReadString(ptr, length)
+//	env.ReadI32(ptr)                        ctx.Memory.ReadI32(ptr)
+//	env.LogSegment(message, ptr, length)    ctx.LogSegment(message, ptr, length)
+//	env.Debug()                             ctx.LogSegment on the region of interest
 type Environment interface {
-	SetMemory(memory *wasmer.Memory)
-	GetMemory() *wasmer.Memory
-
-	ReadBytes(offset int32, len int32) ([]byte, error)
-	ReadString(offset int32, len int32) (string, error)
-
-	ReadI32(ptr int32) (int32, error)
-
-	LogSegment(message string, offset int32, length int32)
 	RecordCall(module, function string, params []interface{}, returns interface{})
 }
 
@@ -29,46 +30,6 @@ type CallRecorder interface {
 
 type RustEnvironment struct {
 	CallRecorder CallRecorder
-	memory       *wasmer.Memory
-}
-
-func (e *RustEnvironment) SetMemory(memory *wasmer.Memory) {
-	e.memory = memory
-}
-
-func (e *RustEnvironment) GetMemory() *wasmer.Memory {
-	return e.memory
-}
-
-func (e *RustEnvironment) ReadBytes(offset int32, length int32) ([]byte, error) {
-	return e.segment(offset, length)
-}
-
-func (e *RustEnvironment) ReadString(offset int32, len int32) (string, error) {
-	bytes, err := e.segment(offset, len)
-	if err != nil {
-		return "", fmt.Errorf("read content: %w", err)
-	}
-	return string(bytes), nil
-}
-
-func (e *RustEnvironment) ReadI32(offset int32) (int32, error) {
-	return e.readI32("", offset)
-}
-
-func (e *RustEnvironment) LogSegment(message string, offset int32, length int32) {
-	// a82700000000000003000000000000000300000000000000
-	// e6f5af
-
-	// a8270000 00000000 03000000   000000000300000000000000
-	// e6f5af
-
-	bytes, err := e.segment(offset, length)
-	if err != nil {
-		zlog.Info("unable to obtain data segment %env to %env for "+message, zap.Error(err))
-	} else {
-		zlog.Info(message, zap.Stringer("bytes", hexBytes(bytes)))
-	}
 }
 
 func (e *RustEnvironment) RecordCall(module, function string, params []interface{}, returns interface{}) {
@@ -76,88 +37,3 @@ func (e *RustEnvironment) RecordCall(module, function string, params []interface
 		e.CallRecorder.Record(module, function, params, returns)
 	}
 }
-
-func (e *RustEnvironment) Debug() string {
-	if e.memory == nil {
-		return "<empty>"
-	}
-
-	return hex.EncodeToString(e.memory.Data())
-}
-
-func (e *RustEnvironment) readI32(tag string, offset int32) (int32, error) {
-	bytes, err := e.segment(offset, 4)
-	if err != nil {
-		return 0, err
-	}
-
-	// It's an actual i32 here, should we parse it differently than a Uint32?
-	out := int32(encoding.Uint32(bytes))
-
-	if ztracer.Enabled() {
-		zlog.Debug("read "+tag+" i32 bytes", zap.Int32("value", out), zap.Stringer("bytes", hexBytes(bytes)), zap.Int32("offset", offset))
-	}
-
-	return out, nil
-}
-
-func (e *RustEnvironment) dataAt(offset int32) ([]byte, error) {
-	bytes := e.memory.Data()
-	if offset < 0 {
-		return nil, fmt.Errorf("offset %d env must be positive", offset)
-	}
-
-	if offset > int32(len(bytes)) {
-		return nil, fmt.Errorf("offset %d env out of memory bounds ending at %d env", offset, len(bytes))
-	}
-
-	return e.memory.Data()[offset:], nil
-}
-
-func (e *RustEnvironment) segment(offset int32, length int32) ([]byte, error) {
-	bytes := e.memory.Data()
-	if offset < 0 {
-		return nil, fmt.Errorf("offset %d env must be positive", offset)
-	}
-
-	if offset >= int32(len(bytes)) {
-		return nil, fmt.Errorf("offset %d env out of memory bounds ending at %d env", offset, len(bytes))
-	}
-
-	end := offset + length
-	if end > int32(len(bytes)) {
-		return nil, fmt.Errorf("end %d env out of memory bounds ending at %d env", end, len(bytes))
-	}
-
-	return bytes[offset : offset+length], nil
-}
-
-func (e *RustEnvironment) readU32(tag string, offset int32) (uint32, error) {
-	bytes, err := e.segment(offset, 4)
-	if err != nil {
-		return 0, err
-	}
-
-	out := encoding.Uint32(bytes)
-	if ztracer.Enabled() {
-		zlog.Debug("read "+tag+" u32 bytes", zap.Uint32("value", out), zap.Stringer("bytes", hexBytes(bytes)), zap.Int32("offset", offset))
-	}
-
-	return out, nil
-}
-
-func (e *RustEnvironment) readI64(tag string, offset int32) (int64, error) {
-	bytes, err := e.segment(offset, 8)
-	if err != nil {
-		return 0, err
-	}
-
-	// It's an actual i64 here, should we parse it differently than a Uint64?
-	out := int64(encoding.Uint64(bytes))
-
-	if ztracer.Enabled() {
-		zlog.Debug("read "+tag+" i64 bytes", zap.Int64("value", out), zap.Stringer("bytes", hexBytes(bytes)))
-	}
-
-	return out, nil
-}
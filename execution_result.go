@@ -0,0 +1,46 @@
+package wasm
+
+import (
+	"time"
+)
+
+// ExecutionResult is the outcome of a call into a module export along with what happened
+// while it ran.
+type ExecutionResult struct {
+	// Value is the value returned by the export, lifted (see WithResultType) or decoded (see
+	// WithRTTIDecoding) when configured, nil when the export returns nothing.
+	Value interface{}
+
+	// Outputs are the decoded values of the output slots passed to the call, by name.
+	Outputs map[string]interface{}
+
+	// Duration is the total time taken by the execution, from the lookup of the export to
+	// the decoding of its result. GuestDuration is the time spent running the export, host
+	// functions excluded, HostDuration the time spent in the host functions it called.
+	Duration      time.Duration
+	GuestDuration time.Duration
+	HostDuration  time.Duration
+
+	// HostCalls is the number of host functions called by the export.
+	HostCalls int
+
+	Memory MemoryStats
+
+	// Logs are the messages logged by the module, in order. Messages printed through
+	// `env.println` are at the info level.
+	Logs []GuestLog
+
+	// DroppedLogs is the number of messages missing from Logs, dropped by the rate limit
+	// (see WithGuestOutputRateLimit) or over the retention limits (see WithGuestLogRetention).
+	DroppedLogs int
+
+	// ModuleHash is the hex encoded SHA-256 of the module binary.
+	ModuleHash string
+}
+
+// GuestLog is a message logged by a module during an execution.
+type GuestLog struct {
+	Level   GuestLogLevel
+	Message string
+	Fields  map[string]interface{}
+}
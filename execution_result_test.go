@@ -0,0 +1,52 @@
+package wasm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const executionResultTestModule = `
+(module
+  (import "env" "println" (func $println (param i32 i32)))
+  (import "log" "log_structured" (func $log_structured (param i32 i32 i32 i32 i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "hello")
+  (data (i32.const 1040) "{\"block\":42}")
+
+  (func (export "handle") (param $out i32) (result i32)
+    (call $println (i32.const 1024) (i32.const 5))
+    (call $log_structured (i32.const 2) (i32.const 1024) (i32.const 5) (i32.const 1040) (i32.const 12) (i32.const 0))
+    (i32.store (local.get $out) (i32.const 1024))
+    (i32.store offset=4 (local.get $out) (i32.const 4))
+    (i32.const 7)))
+`
+
+func TestInstance_ExecutionResult(t *testing.T) {
+	wasmFile := writeTestModule(t, executionResultTestModule)
+	wasmBytes, err := os.ReadFile(wasmFile)
+	require.NoError(t, err)
+	hash := sha256.Sum256(wasmBytes)
+
+	result, err := NewRuntime(&RustEnvironment{}, WithParameterPointSize(), WithGuestOutput(io.Discard)).Execute(wasmFile, "handle", nil, NewStringOutput("greeting"))
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(7), result.Value)
+	assert.Equal(t, map[string]interface{}{"greeting": "hell"}, result.Outputs)
+	assert.Equal(t, 2, result.HostCalls)
+	assert.Equal(t, []GuestLog{
+		{Level: GuestLogInfo, Message: "hello"},
+		{Level: GuestLogWarning, Message: "hello", Fields: map[string]interface{}{"block": float64(42)}},
+	}, result.Logs)
+	assert.Equal(t, uint32(1), result.Memory.FinalPages)
+	assert.Equal(t, uint64(8), result.Memory.HostWrittenBytes)
+	assert.Equal(t, hex.EncodeToString(hash[:]), result.ModuleHash)
+
+	assert.True(t, result.HostDuration > 0)
+	assert.True(t, result.Duration >= result.GuestDuration+result.HostDuration)
+}
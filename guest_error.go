@@ -0,0 +1,62 @@
+package wasm
+
+import (
+	"fmt"
+)
+
+// GuestError is returned when a module rejected a call by reporting an error, as opposed to
+// trapping or aborting. A module reports an error either by calling `env.set_error` with
+// its message, or by returning a non-zero status code from a function called with an
+// output slot created by NewErrorOutput, receiving the message.
+type GuestError struct {
+	// Code is the status code returned by the function, 0 when reported through
+	// `env.set_error`.
+	Code    int64
+	Message string
+}
+
+func (e *GuestError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("guest error (code %d): %s", e.Code, e.Message)
+	}
+	return "guest error: " + e.Message
+}
+
+// NewErrorOutput creates an output slot receiving a `(ptr, len)` pair of u32 pointing to
+// the UTF-8 error message of the function. When the function returns a non-zero status
+// code, the call fails with a GuestError holding that message.
+func NewErrorOutput(name string) *AscReturnValue {
+	return NewOutput(name, errorOutput{bytesOutput{asString: true}})
+}
+
+type errorOutput struct {
+	bytesOutput
+}
+
+// guestErrorOf returns the error reported by the module during `call`, if any.
+func guestErrorOf(call *invocation, result interface{}, returns []*AscReturnValue) *GuestError {
+	if call.guestErr != nil {
+		return call.guestErr
+	}
+
+	for _, returnValue := range returns {
+		if _, ok := returnValue.decoder.(errorOutput); !ok {
+			continue
+		}
+
+		var code int64
+		switch v := result.(type) {
+		case int32:
+			code = int64(v)
+		case int64:
+			code = v
+		}
+
+		if code != 0 {
+			message, _ := returnValue.Value().(string)
+			return &GuestError{Code: code, Message: message}
+		}
+	}
+
+	return nil
+}
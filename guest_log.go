@@ -0,0 +1,161 @@
+package wasm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// GuestLogLevel is the level of a message logged by a module through the `log` host
+// module, numbered as graph-ts numbers its levels.
+type GuestLogLevel int32
+
+const (
+	// GuestLogCritical logs the message and aborts the execution with a CriticalLogError.
+	GuestLogCritical GuestLogLevel = iota
+	GuestLogError
+	GuestLogWarning
+	GuestLogInfo
+	GuestLogDebug
+)
+
+func (l GuestLogLevel) String() string {
+	switch l {
+	case GuestLogCritical:
+		return "critical"
+	case GuestLogError:
+		return "error"
+	case GuestLogWarning:
+		return "warning"
+	case GuestLogInfo:
+		return "info"
+	case GuestLogDebug:
+		return "debug"
+	}
+	return fmt.Sprintf("GuestLogLevel(%d)", int32(l))
+}
+
+func (l GuestLogLevel) zapLevel() zapcore.Level {
+	switch l {
+	case GuestLogCritical, GuestLogError:
+		return zapcore.ErrorLevel
+	case GuestLogWarning:
+		return zapcore.WarnLevel
+	case GuestLogInfo:
+		return zapcore.InfoLevel
+	}
+	return zapcore.DebugLevel
+}
+
+// Default limits of the messages kept in ExecutionResult.Logs, see WithGuestLogRetention.
+const (
+	DefaultGuestLogMaxEntries = 1000
+	DefaultGuestLogMaxBytes   = 1024 * 1024
+)
+
+// WithGuestLogRetention keeps at most `maxEntries` of the messages logged or printed by a
+// module during an execution in ExecutionResult.Logs, totalling at most `maxBytes` of
+// message and fields. The following messages are still logged but not kept, their count is
+// reported in ExecutionResult.DroppedLogs.
+func WithGuestLogRetention(maxEntries int, maxBytes int) RuntimeOption {
+	return func(r *Runtime) {
+		r.guestLogMaxEntries = maxEntries
+		r.guestLogMaxBytes = maxBytes
+	}
+}
+
+// GuestLogFieldsEncoding is the encoding of the fields given to `log.log_structured`.
+type GuestLogFieldsEncoding int32
+
+const (
+	// GuestLogFieldsJSON fields are a JSON object.
+	GuestLogFieldsJSON GuestLogFieldsEncoding = iota
+
+	// GuestLogFieldsProtobuf fields are a serialized `google.protobuf.Struct` message.
+	GuestLogFieldsProtobuf
+)
+
+// CriticalLogError is returned when a module logged a message at the critical level,
+// which aborts its execution.
+type CriticalLogError struct {
+	Message string
+	Fields  map[string]interface{}
+}
+
+func (e *CriticalLogError) Error() string {
+	return "critical error logged by module: " + e.Message
+}
+
+// guestLog routes a message logged by the module to the logger of the runtime, returning
+// a CriticalLogError for critical messages. Messages over the rate limit of the guest output
+// are dropped, critical ones excepted. `fieldsSize` is the size of the encoded fields.
+func (c *invocation) guestLog(level GuestLogLevel, message string, fields map[string]interface{}, fieldsSize int) error {
+	if level < GuestLogCritical || level > GuestLogDebug {
+		return fmt.Errorf("invalid log level %d", int32(level))
+	}
+
+	if limiter := c.runtime.guestOutputLimiter; level != GuestLogCritical && limiter != nil && !limiter.Allow() {
+		c.dropped++
+		return nil
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	zapFields := make([]zap.Field, 0, len(fields)+1)
+	zapFields = append(zapFields, zap.Stringer("level", level))
+	for _, key := range keys {
+		zapFields = append(zapFields, zap.Any(key, fields[key]))
+	}
+
+	c.retainLog(GuestLog{Level: level, Message: message, Fields: fields}, len(message)+fieldsSize)
+
+	if entry := c.logger().Check(level.zapLevel(), message); entry != nil {
+		entry.Write(zapFields...)
+	}
+
+	if level == GuestLogCritical {
+		return &CriticalLogError{Message: message, Fields: fields}
+	}
+	return nil
+}
+
+// retainLog keeps `entry` of `size` bytes for ExecutionResult.Logs, unless it is over the
+// retention limits of the runtime.
+func (c *invocation) retainLog(entry GuestLog, size int) {
+	if len(c.logs) >= c.runtime.guestLogMaxEntries || c.logsSize+size > c.runtime.guestLogMaxBytes {
+		c.unretained++
+		return
+	}
+
+	c.logs = append(c.logs, entry)
+	c.logsSize += size
+}
+
+func decodeGuestLogFields(encoding GuestLogFieldsEncoding, data []byte) (map[string]interface{}, error) {
+	switch encoding {
+	case GuestLogFieldsJSON:
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("invalid json object: %w", err)
+		}
+		return fields, nil
+
+	case GuestLogFieldsProtobuf:
+		fields := &structpb.Struct{}
+		if err := proto.Unmarshal(data, fields); err != nil {
+			return nil, fmt.Errorf("invalid protobuf struct: %w", err)
+		}
+		return fields.AsMap(), nil
+	}
+
+	return nil, fmt.Errorf("unknown fields encoding %d", int32(encoding))
+}
@@ -0,0 +1,85 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const guestLogTestModule = `
+(module
+  (import "log" "log" (func $log (param i32 i32 i32)))
+  (import "log" "log_structured" (func $log_structured (param i32 i32 i32 i32 i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "started")
+  (data (i32.const 1040) "block processed")
+  (data (i32.const 1072) "{\"block\":42,\"hash\":\"0xab\"}")
+  (data (i32.const 1120) "%s")
+
+  (func (export "process") (param $critical i32)
+    (call $log (i32.const 3) (i32.const 1024) (i32.const 7))
+    (call $log_structured (i32.const 2) (i32.const 1040) (i32.const 15) (i32.const 1072) (i32.const 26) (i32.const 0))
+    (call $log_structured (local.get $critical) (i32.const 1040) (i32.const 15) (i32.const 1120) (i32.const %d) (i32.const 1))))
+`
+
+func TestRuntime_GuestLog(t *testing.T) {
+	fields, err := structpb.NewStruct(map[string]interface{}{"reason": "bad input"})
+	require.NoError(t, err)
+	encoded, err := proto.Marshal(fields)
+	require.NoError(t, err)
+
+	escaped := &strings.Builder{}
+	for _, b := range encoded {
+		fmt.Fprintf(escaped, "\\%02x", b)
+	}
+	wasmFile := writeTestModule(t, fmt.Sprintf(guestLogTestModule, escaped, len(encoded)))
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	runtime := NewRuntime(&RustEnvironment{}, WithLogger(zap.New(core)))
+
+	_, err = runtime.Execute(wasmFile, "process", []interface{}{int32(GuestLogError)})
+	require.NoError(t, err)
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 4)
+	assert.Equal(t, "started", entries[0].Message)
+	assert.Equal(t, zapcore.InfoLevel, entries[0].Level)
+	assert.Equal(t, "process", entries[0].ContextMap()["function"])
+	assert.Equal(t, "block processed", entries[1].Message)
+	assert.Equal(t, zapcore.WarnLevel, entries[1].Level)
+	assert.Equal(t, float64(42), entries[1].ContextMap()["block"])
+	assert.Equal(t, "0xab", entries[1].ContextMap()["hash"])
+	assert.Equal(t, zapcore.ErrorLevel, entries[2].Level)
+	assert.Equal(t, "bad input", entries[2].ContextMap()["reason"])
+
+	_, err = runtime.Execute(wasmFile, "process", []interface{}{int32(GuestLogCritical)})
+	var criticalErr *CriticalLogError
+	require.True(t, errors.As(err, &criticalErr), "expected a CriticalLogError, got %v", err)
+	assert.Equal(t, "block processed", criticalErr.Message)
+	assert.Equal(t, map[string]interface{}{"reason": "bad input"}, criticalErr.Fields)
+}
+
+func TestRuntime_GuestLogRetention(t *testing.T) {
+	wasmFile := writeTestModule(t, guestOutputTestModule)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	result, err := NewRuntime(&RustEnvironment{}, WithLogger(zap.New(core)), WithGuestLogRetention(3, 1024)).Execute(wasmFile, "greet", []interface{}{int32(5)})
+	require.NoError(t, err)
+	assert.Len(t, result.Logs, 3)
+	assert.Equal(t, 2, result.DroppedLogs)
+	assert.Len(t, logs.FilterMessage("guest output").All(), 5, "messages over the retention limits are still logged")
+
+	result, err = NewRuntime(&RustEnvironment{}, WithLogger(zap.New(core)), WithGuestLogRetention(10, 12)).Execute(wasmFile, "greet", []interface{}{int32(5)})
+	require.NoError(t, err)
+	assert.Len(t, result.Logs, 2)
+	assert.Equal(t, 3, result.DroppedLogs)
+}
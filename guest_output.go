@@ -0,0 +1,79 @@
+package wasm
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// GuestOutput is a message printed by a module through `env.println`.
+type GuestOutput struct {
+	Module       string
+	Function     string
+	InvocationID uint64
+	Message      string
+}
+
+type GuestOutputFunc func(output GuestOutput)
+
+// WithLogger routes the logs of the runtime, and the output of modules unless configured
+// otherwise, to `logger` instead of the package logger.
+func WithLogger(logger *zap.Logger) RuntimeOption {
+	return func(r *Runtime) {
+		r.logger = logger
+	}
+}
+
+// WithGuestOutput writes the messages printed by modules to `writer`, one per line prefixed
+// by the module name and the invocation id.
+func WithGuestOutput(writer io.Writer) RuntimeOption {
+	lock := &sync.Mutex{}
+
+	return WithGuestOutputFunc(func(output GuestOutput) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		fmt.Fprintf(writer, "[%s#%d] %s\n", output.Module, output.InvocationID, output.Message)
+	})
+}
+
+// WithGuestOutputFunc hands the messages printed by modules to `handler`, by default they
+// are logged at info level.
+func WithGuestOutputFunc(handler GuestOutputFunc) RuntimeOption {
+	return func(r *Runtime) {
+		r.guestOutput = handler
+	}
+}
+
+// WithGuestOutputRateLimit forwards at most `perSecond` messages printed or logged by
+// modules, with bursts of up to `burst` messages, across all the invocations of the runtime.
+// Messages over the limit are dropped, their count is logged once the invocation completes.
+func WithGuestOutputRateLimit(perSecond float64, burst int) RuntimeOption {
+	return func(r *Runtime) {
+		r.guestOutputLimiter = rate.NewLimiter(rate.Limit(perSecond), burst)
+	}
+}
+
+func (c *invocation) printGuestOutput(message string) {
+	if limiter := c.runtime.guestOutputLimiter; limiter != nil && !limiter.Allow() {
+		c.dropped++
+		return
+	}
+
+	c.retainLog(GuestLog{Level: GuestLogInfo, Message: message}, len(message))
+
+	if c.runtime.guestOutput == nil {
+		c.logger().Info("guest output", zap.String("message", message))
+		return
+	}
+
+	c.runtime.guestOutput(GuestOutput{
+		Module:       c.module,
+		Function:     c.function,
+		InvocationID: c.id,
+		Message:      message,
+	})
+}
@@ -0,0 +1,61 @@
+package wasm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const guestOutputTestModule = `
+(module
+  (import "env" "println" (func $println (param i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "hello")
+
+  (func (export "greet") (param $count i32)
+    (loop $again
+      (call $println (i32.const 1024) (i32.const 5))
+      (local.set $count (i32.sub (local.get $count) (i32.const 1)))
+      (br_if $again (local.get $count)))))
+`
+
+func TestRuntime_GuestOutput(t *testing.T) {
+	wasmFile := writeTestModule(t, guestOutputTestModule)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	output := &bytes.Buffer{}
+	runtime := NewRuntime(&RustEnvironment{}, WithLogger(zap.New(core)), WithGuestOutput(output), WithGuestOutputRateLimit(0.001, 2))
+
+	result, err := runtime.Execute(wasmFile, "greet", []interface{}{int32(5)})
+	require.NoError(t, err)
+	assert.Len(t, result.Logs, 2)
+	assert.Equal(t, 3, result.DroppedLogs)
+
+	lines := bytes.Split(bytes.TrimSpace(output.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	assert.Regexp(t, `^\[module#\d+\] hello$`, string(lines[0]))
+	assert.Equal(t, lines[0], lines[1])
+
+	dropped := logs.FilterMessage("guest output over the rate limit was dropped").All()
+	require.Len(t, dropped, 1)
+	assert.Equal(t, int64(3), dropped[0].ContextMap()["dropped"])
+	assert.Equal(t, "greet", dropped[0].ContextMap()["function"])
+}
+
+func TestRuntime_GuestOutputLogged(t *testing.T) {
+	wasmFile := writeTestModule(t, guestOutputTestModule)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	_, err := NewRuntime(&RustEnvironment{}, WithLogger(zap.New(core))).Execute(wasmFile, "greet", []interface{}{int32(1)})
+	require.NoError(t, err)
+
+	entries := logs.FilterMessage("guest output").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "hello", entries[0].ContextMap()["message"])
+	assert.Equal(t, "module", entries[0].ContextMap()["module"])
+}
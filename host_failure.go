@@ -0,0 +1,238 @@
+package wasm
+
+import (
+	"fmt"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// Host functions never return their errors to wasmer: wasmer-go (up to v1.0.4, the latest)
+// frees the trap it builds out of such an error twice, once in the engine and once in a Go
+// finalizer, crashing the process whenever the garbage collector runs afterwards. Instead,
+// the failing host function records its error on the invocation and sets a global of the
+// module, which the module checks after each call to an import, executing `unreachable` when
+// set: the resulting trap is raised by the engine itself, then replaced by the recorded error
+// once the call returns (see Instance.end).
+const (
+	hostFailedGlobalExport = "__wasm_runtime_host_failed"
+
+	// startFunctionExport exports the start function of the module, which is removed from its
+	// start section to run within an invocation rather than while instantiating, where host
+	// functions could not fail.
+	startFunctionExport = "__wasm_runtime_start"
+)
+
+// instrumentedModule is the binary of a module as rewritten for its instances, see instrument.
+type instrumentedModule struct {
+	wasmBytes         []byte
+	profiledFunctions []string
+}
+
+// instrument returns the binary of the module `wasmBytes` instrumented for host failures and,
+// when the runtime has a profiler, for profiling. The rewrite only depends on the binary, it
+// is done once per module hash.
+func (r *Runtime) instrument(hash string, wasmBytes []byte) (*instrumentedModule, error) {
+	if cached, found := r.instrumented.Load(hash); found {
+		return cached.(*instrumentedModule), nil
+	}
+
+	out, err := instrumentForHostFailures(wasmBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	module := &instrumentedModule{wasmBytes: out}
+	if r.profiler != nil {
+		if module.wasmBytes, module.profiledFunctions, err = instrumentForProfiling(out); err != nil {
+			return nil, fmt.Errorf("profiling: %w", err)
+		}
+	}
+
+	r.instrumented.Store(hash, module)
+	return module, nil
+}
+
+func instrumentForHostFailures(wasmBytes []byte) ([]byte, error) {
+	module, err := parseWASMModule(wasmBytes)
+	if err != nil {
+		return nil, err
+	}
+	return instrumentHostFailures(module)
+}
+
+// instrumentHostFailures returns the binary of `module` trapping right after the host
+// functions it calls fail, exporting its start function instead of running it. Its mutable
+// globals are exported as well, see instrumentGlobals, and its memory growths are counted,
+// see memoryGrowsGlobalExport.
+func instrumentHostFailures(module *wasmModule) ([]byte, error) {
+	imports, err := module.imports()
+	if err != nil {
+		return nil, fmt.Errorf("import section: %w", err)
+	}
+
+	importedFunctions, importedGlobals := uint32(0), uint32(0)
+	for _, imported := range imports {
+		switch imported.kind {
+		case externFunction:
+			importedFunctions++
+		case externGlobal:
+			importedGlobals++
+		}
+	}
+
+	globals, err := module.globals()
+	if err != nil {
+		return nil, fmt.Errorf("global section: %w", err)
+	}
+
+	exports, err := module.exports()
+	if err != nil {
+		return nil, fmt.Errorf("export section: %w", err)
+	}
+
+	bodies, err := module.functionBodies()
+	if err != nil {
+		return nil, fmt.Errorf("code section: %w", err)
+	}
+
+	exports = instrumentGlobals(exports, globals, importedGlobals)
+
+	failed := importedGlobals + uint32(len(globals))
+	globals = append(globals, wasmGlobal{valueType: valueI32, mutable: true, init: []byte{opI32Const, 0x00, opEnd}})
+	exports = append(exports, wasmExport{hostFailedGlobalExport, externGlobal, failed})
+
+	grows, growResult := failed+1, failed+2
+	globals = append(globals,
+		wasmGlobal{valueType: valueI32, mutable: true, init: []byte{opI32Const, 0x00, opEnd}},
+		wasmGlobal{valueType: valueI32, mutable: true, init: []byte{opI32Const, 0x00, opEnd}},
+	)
+	exports = append(exports, wasmExport{memoryGrowsGlobalExport, externGlobal, grows})
+
+	for i := range bodies {
+		if bodies[i].code, err = instrumentCode(bodies[i].code, importedFunctions, failed, grows, growResult); err != nil {
+			return nil, fmt.Errorf("function %d: %w", importedFunctions+uint32(i), err)
+		}
+	}
+
+	instrumented := &wasmModule{sections: append([]wasmSection(nil), module.sections...)}
+	if payload := module.section(sectionStart); payload != nil {
+		start, err := newWASMReader(payload).u32()
+		if err != nil {
+			return nil, fmt.Errorf("start section: %w", err)
+		}
+
+		exports = append(exports, wasmExport{startFunctionExport, externFunction, start})
+		instrumented.removeSection(sectionStart)
+	}
+
+	instrumented.setSection(sectionGlobal, encodeGlobals(globals))
+	instrumented.setSection(sectionExport, encodeExports(exports))
+	instrumented.setSection(sectionCode, encodeFunctionBodies(bodies))
+
+	return instrumented.encode(), nil
+}
+
+// instrumentCode returns `code` checking the global `failed` after each call that may reach
+// a host function, a direct call to one of the `importedFunctions` or an indirect call. Tail
+// calls leave the function before the check could run, they are not checked. Each successful
+// `memory.grow` increments the global `grows`, its result is kept in `growResult` meanwhile.
+func instrumentCode(code []byte, importedFunctions uint32, failed, grows, growResult uint32) ([]byte, error) {
+	check := appendU32([]byte{opGlobalGet}, failed)
+	check = append(check, opIf, blockTypeEmpty, opUnreachable, opEnd)
+
+	// memory.grow leaves the previous size of the memory, or -1 when it could not grow
+	count := appendU32([]byte{opGlobalSet}, growResult)
+	count = appendU32(append(count, opGlobalGet), grows)
+	count = appendU32(append(count, opGlobalGet), growResult)
+	count = append(count, opI32Const, 0x7f, opI32Ne, opI32Add)
+	count = appendU32(append(count, opGlobalSet), grows)
+	count = appendU32(append(count, opGlobalGet), growResult)
+
+	out := make([]byte, 0, len(code))
+	reader := newWASMReader(code)
+	for !reader.done() {
+		start := reader.offset
+		opcode, err := reader.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		hostCall := opcode == opCallIndirect
+		if opcode == opCall {
+			index, err := reader.u32()
+			if err != nil {
+				return nil, fmt.Errorf("instruction %#x at offset %d: %w", opcode, start, err)
+			}
+			hostCall = index < importedFunctions
+		} else if err := reader.immediates(opcode); err != nil {
+			return nil, fmt.Errorf("instruction %#x at offset %d: %w", opcode, start, err)
+		}
+
+		out = append(out, code[start:reader.offset]...)
+		if hostCall {
+			out = append(out, check...)
+		}
+		if opcode == opMemoryGrow {
+			out = append(out, count...)
+		}
+	}
+
+	return out, nil
+}
+
+// bindFailures gives the global of `instance` through which host functions make it trap.
+func (h *hostContext) bindFailures(instance *wasmer.Instance) error {
+	global, err := instance.Exports.GetGlobal(hostFailedGlobalExport)
+	if err != nil {
+		return err
+	}
+
+	h.failedGlobal = global
+	return nil
+}
+
+// fail records `err` as the error of the running invocation and makes the module trap once
+// the host function returns, returning the zero values of `results` for the host function
+// to return meanwhile.
+func (h *hostContext) fail(err error, results []wasmer.ValueKind) []wasmer.Value {
+	if h.invocation != nil {
+		h.invocation.fail(err)
+	}
+
+	if !h.failed && h.failedGlobal != nil {
+		// The global is a mutable i32 created by instrumentHostFailures, setting it cannot fail
+		_ = h.failedGlobal.Set(int32(1), wasmer.I32)
+		h.failed = true
+	}
+
+	values := make([]wasmer.Value, len(results))
+	for i, kind := range results {
+		switch kind {
+		case wasmer.I64:
+			values[i] = wasmer.NewI64(0)
+		case wasmer.F32:
+			values[i] = wasmer.NewF32(0)
+		case wasmer.F64:
+			values[i] = wasmer.NewF64(0)
+		default:
+			values[i] = wasmer.NewI32(0)
+		}
+	}
+	return values
+}
+
+// clearFailure clears the failure left by the previous invocation, whose call trapped.
+func (h *hostContext) clearFailure() {
+	if h.failed {
+		_ = h.failedGlobal.Set(int32(0), wasmer.I32)
+		h.failed = false
+	}
+}
+
+func valueKinds(types []*wasmer.ValueType) []wasmer.ValueKind {
+	kinds := make([]wasmer.ValueKind, len(types))
+	for i, valueType := range types {
+		kinds[i] = valueType.Kind()
+	}
+	return kinds
+}
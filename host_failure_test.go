@@ -0,0 +1,134 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The garbage collector runs after each failure: the traps of the host function errors used
+// to be freed twice, crashing the process once collected.
+func TestInstance_HostFailureSurvivesGC(t *testing.T) {
+	pool := NewRuntime(&RustEnvironment{}, WithGuestOutput(io.Discard)).NewInstancePool(writeTestModule(t, metricsTestModule), 1)
+	defer pool.Close()
+
+	for i := 0; i < 50; i++ {
+		_, err := pool.Execute(context.Background(), "handle", []interface{}{int32(2)})
+		var accessErr *MemoryAccessError
+		require.True(t, errors.As(err, &accessErr), "expected a MemoryAccessError, got %v", err)
+		runtime.GC()
+
+		// The failure of the previous call does not leak into the next one
+		_, err = pool.Execute(context.Background(), "handle", []interface{}{int32(0)})
+		require.NoError(t, err)
+	}
+
+	wasiRuntime := NewRuntime(&RustEnvironment{}, WithWASI(WASIConfig{
+		Mounts: map[string]VirtualFS{"/": NewMemFS()},
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+	}))
+	wasmFile := writeTestModule(t, wasiTestModule)
+	for i := 0; i < 20; i++ {
+		_, err := wasiRuntime.Execute(wasmFile, "run", []interface{}{int32(3)})
+		var exitErr *ProcExitError
+		require.True(t, errors.As(err, &exitErr), "expected a ProcExitError, got %v", err)
+		runtime.GC()
+	}
+}
+
+const startFunctionTestModule = `
+(module
+  (import "env" "println" (func $println (param i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "started")
+
+  (func $init
+    (call $println (i32.const 1024) (i32.const 7))
+    (call $println (i32.const -1) (i32.const 7)))
+
+  (start $init))
+`
+
+func TestInstance_StartFunction(t *testing.T) {
+	var outputs []GuestOutput
+	runtime := NewRuntime(&RustEnvironment{}, WithGuestOutputFunc(func(output GuestOutput) {
+		outputs = append(outputs, output)
+	}))
+
+	_, err := runtime.Instantiate(writeTestModule(t, startFunctionTestModule))
+	var accessErr *MemoryAccessError
+	require.True(t, errors.As(err, &accessErr), "expected a MemoryAccessError, got %v", err)
+
+	require.Len(t, outputs, 1)
+	assert.Equal(t, "started", outputs[0].Message)
+	assert.Equal(t, startFunctionExport, outputs[0].Function)
+}
+
+func TestInstance_HostCallOutOfInvocation(t *testing.T) {
+	instance, err := NewRuntime(&RustEnvironment{}, WithGuestOutput(io.Discard)).Instantiate(writeTestModule(t, metricsTestModule))
+	require.NoError(t, err)
+	defer instance.Close()
+
+	handle, err := instance.instance.Exports.GetFunction("handle")
+	require.NoError(t, err)
+
+	_, err = handle(int32(0))
+	require.Error(t, err)
+
+	_, err = instance.Execute("handle", []interface{}{int32(0)})
+	require.NoError(t, err)
+}
+
+func TestWASMReader_Immediates(t *testing.T) {
+	tests := []struct {
+		name   string
+		opcode byte
+		data   []byte
+		offset int
+	}{
+		{"try", opTry, []byte{0x40}, 1},
+		{"catch", opCatch, []byte{0x80, 0x01}, 2},
+		{"catch_all", opCatchAll, nil, 0},
+		{"delegate", opDelegate, []byte{0x02}, 1},
+		{"try_table", opTryTable, []byte{0x40, 0x02, 0x00, 0x01, 0x03, 0x03, 0x04}, 7},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := newWASMReader(test.data)
+			require.NoError(t, reader.immediates(test.opcode))
+			assert.Equal(t, test.offset, reader.offset)
+		})
+	}
+
+	err := newWASMReader(nil).immediates(0xe0)
+	var unsupported *unsupportedOpcodeError
+	require.True(t, errors.As(err, &unsupported))
+	assert.Contains(t, err.Error(), "unsupported opcode 0xe0, the module may be valid")
+}
+
+func TestRuntime_InstrumentOncePerModule(t *testing.T) {
+	r := NewRuntime(&RustEnvironment{}, WithGuestOutput(io.Discard))
+	file := writeTestModule(t, metricsTestModule)
+
+	first, err := r.Instantiate(file)
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := r.Instantiate(file)
+	require.NoError(t, err)
+	defer second.Close()
+
+	count := 0
+	r.instrumented.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 1, count)
+}
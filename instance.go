@@ -0,0 +1,425 @@
+package wasm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	// reactorInitializer is exported by WASI reactor modules (e.g. Rust `cdylib`) to run
+	// their static constructors, it must be called before any other export.
+	reactorInitializer = "_initialize"
+
+	// commandEntrypoint is exported by WASI command modules, it runs the whole program.
+	commandEntrypoint = "_start"
+)
+
+// Instance is a module instantiated by a Runtime, kept alive across calls so that its
+// memory and globals persist from one call to the other. An Instance is not safe for
+// concurrent use.
+type Instance struct {
+	runtime    *Runtime
+	wasmFile   string
+	name       string
+	moduleHash string
+
+	store    *wasmer.Store
+	module   *wasmer.Module
+	instance *wasmer.Instance
+	memory   *wasmer.Memory
+	heap     *AscHeap
+	access   *Memory
+	wasi     wasiProvider
+	host     *hostContext
+	snapshot *snapshot
+
+	memoryStats MemoryStats
+	memoryGrows *wasmer.Global
+	started     bool
+}
+
+// Instantiate compiles and instantiates the module found in `wasmFile`. When the module
+// is a reactor, exporting `_initialize`, its initializer is run once here before any other
+// call.
+func (r *Runtime) Instantiate(wasmFile string) (*Instance, error) {
+	wasmBytes, err := ioutil.ReadFile(wasmFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load wasm file %q: %w", wasmFile, err)
+	}
+
+	return r.instantiate(context.Background(), wasmFile, wasmBytes)
+}
+
+// instantiate instantiates the module `wasmBytes`, `wasmFile` being the file it was read from
+// or derived from.
+func (r *Runtime) instantiate(ctx context.Context, wasmFile string, wasmBytes []byte) (_ *Instance, err error) {
+	start := time.Now()
+	name := moduleName(wasmFile)
+	hash := moduleHash(wasmBytes)
+	attributes := trace.WithAttributes(attributeModule.String(name), attributeModuleHash.String(hash))
+
+	engine := wasmer.NewEngine()
+	store := wasmer.NewStore(engine)
+
+	instrumented, err := r.instrument(hash, wasmBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instrument wasm file %q: %w", wasmFile, err)
+	}
+	wasmBytes, profiledFunctions := instrumented.wasmBytes, instrumented.profiledFunctions
+
+	_, compileSpan := r.tracer.Start(ctx, spanCompile, attributes)
+	module, err := wasmer.NewModule(store, wasmBytes)
+	endSpan(compileSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile wasm file %q: %w", wasmFile, err)
+	}
+	r.metrics.observeCompile(name, time.Since(start))
+
+	ctx, span := r.tracer.Start(ctx, spanInstantiate, attributes)
+	defer func() { endSpan(span, err) }()
+
+	importObject := wasmer.NewImportObject()
+	host := &hostContext{}
+
+	var wasi wasiProvider
+	if r.wasi != nil {
+		wasi, err = newWASIProvider(r.wasi, filepath.Base(wasmFile), r.logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create wasi environment for %q: %w", wasmFile, err)
+		}
+
+		importObject, err = wasi.importObject(store, module, host)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create wasi imports for %q: %w", wasmFile, err)
+		}
+	}
+
+	functions := append(append([]impl(nil), intrinsicFunctions...), r.hostFunctions...)
+	registerImports(importObject, functions, host, store)
+	if r.profiler != nil {
+		r.profiler.registerImports(importObject, r.profiler.functionIDs(name, profiledFunctions), host, store)
+	}
+	instance, err := wasmer.NewInstance(module, importObject)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get wasm module instance from %q: %w", wasmFile, err)
+	}
+
+	memory, err := instance.Exports.GetMemory("memory")
+	if err != nil {
+		instance.Close()
+		return nil, fmt.Errorf("unable to get the wasm module memory: %w", err)
+	}
+
+	if err := host.bindFailures(instance); err != nil {
+		instance.Close()
+		return nil, fmt.Errorf("unable to get the wasm module failure global: %w", err)
+	}
+
+	memoryGrows, err := instance.Exports.GetGlobal(memoryGrowsGlobalExport)
+	if err != nil {
+		instance.Close()
+		return nil, fmt.Errorf("unable to get the wasm module memory growths global: %w", err)
+	}
+
+	if wasi != nil {
+		wasi.bind(memory)
+	}
+
+	if ztracer.Enabled() {
+		pages := memory.Size()
+
+		r.logger.Debug("memory information for invocation",
+			zap.Uint32("pages_count", pages.ToUint32()),
+			zap.Uint("pages_bytes", pages.ToBytes()),
+			zap.Uint("date_size_bytes", memory.DataSize()),
+		)
+	}
+
+	heap := newAscHeap(memory, r.logger)
+	heap.ascNew = ascRuntimeFunction(instance, "__new")
+	heap.ascPin = ascRuntimeFunction(instance, "__pin")
+	heap.ascUnpin = ascRuntimeFunction(instance, "__unpin")
+	if r.memoryAllocFactory != nil {
+		heap.allocator = r.memoryAllocFactory(instance)
+	}
+
+	if r.rttiDecoding {
+		heap.rtti, err = newAscRTTI(instance, heap)
+		if err != nil {
+			instance.Close()
+			return nil, fmt.Errorf("unable to load runtime type information from %q: %w", wasmFile, err)
+		}
+	}
+
+	i := &Instance{
+		runtime:    r,
+		wasmFile:   wasmFile,
+		name:       name,
+		moduleHash: hash,
+		store:      store,
+		module:     module,
+		instance:   instance,
+		memory:     memory,
+		heap:       heap,
+		access:     NewMemory(memory, heap.allocate),
+		wasi:       wasi,
+		host:       host,
+
+		memoryGrows: memoryGrows,
+	}
+
+	if start, err := instance.Exports.GetRawFunction(startFunctionExport); err == nil {
+		call := i.begin(ctx, startFunctionExport)
+		_, err = start.Call()
+		err = i.end(call, err)
+		if err != nil {
+			i.Close()
+			return nil, fmt.Errorf("unable to run the start function of wasm module %q: %w", wasmFile, asProcExitError(err))
+		}
+	}
+
+	if initializer, err := instance.Exports.GetRawFunction(reactorInitializer); err == nil {
+		r.logger.Debug("running reactor module initializer", zap.String("wasm_file", wasmFile))
+
+		call := i.begin(ctx, reactorInitializer)
+		_, err = initializer.Call()
+		err = i.end(call, err)
+		if err != nil {
+			i.Close()
+			return nil, fmt.Errorf("unable to initialize wasm module %q: %w", wasmFile, asProcExitError(err))
+		}
+	}
+
+	r.metrics.observeInstantiate(name, time.Since(start))
+	return i, nil
+}
+
+// Execute calls `functionName` with `parameters`, decoding `returns` once it completes.
+func (i *Instance) Execute(functionName string, parameters []interface{}, returns ...*AscReturnValue) (*ExecutionResult, error) {
+	return i.ExecuteContext(context.Background(), functionName, parameters, returns...)
+}
+
+// ExecuteContext is Execute handing `ctx` to the host functions called during the call
+// through their CallContext. The call is not made if `ctx` is already done, a running call
+// cannot be interrupted though.
+func (i *Instance) ExecuteContext(ctx context.Context, functionName string, parameters []interface{}, returns ...*AscReturnValue) (result *ExecutionResult, err error) {
+	ctx, span := i.runtime.tracer.Start(ctx, spanExecute, trace.WithAttributes(
+		attributeModule.String(i.name),
+		attributeFunction.String(functionName),
+	))
+	defer func() { endSpan(span, err) }()
+
+	return i.execute(ctx, functionName, parameters, returns)
+}
+
+// execute calls `functionName` within a `wasm.call` span.
+func (i *Instance) execute(ctx context.Context, functionName string, parameters []interface{}, returns []*AscReturnValue) (*ExecutionResult, error) {
+	r := i.runtime
+	start := time.Now()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("unable to execute wasm module function %q from %q: %w", functionName, i.wasmFile, err)
+	}
+
+	entrypointFunction, err := i.instance.Exports.GetRawFunction(functionName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get wasm module function %q from %q: %w", functionName, i.wasmFile, err)
+	}
+
+	if ztracer.Enabled() {
+		r.logger.Debug("entrypoint function loaded", zap.Stringer("def", namedFunctionDefinition{functionName, entrypointFunction}))
+	}
+
+	ctx, span := r.tracer.Start(ctx, spanCall, trace.WithAttributes(
+		attributeModule.String(i.name),
+		attributeModuleHash.String(i.moduleHash),
+		attributeFunction.String(functionName),
+		attributeParameterCount.Int(len(parameters)),
+	))
+
+	call := i.begin(ctx, functionName)
+	result, err := r.callFunction(call, i.heap, functionName, entrypointFunction, parameters, returns)
+	err = i.complete(call, err)
+
+	// The result is read from the memory below, it counts in the statistics of the call
+	defer i.release(call)
+
+	span.SetAttributes(
+		attributeInvocationID.Int64(int64(call.id)),
+		attributeParameterBytes.Int64(int64(call.parameterBytes)),
+		attributeHostCalls.Int(call.hostCalls),
+		attributeMemoryPages.Int64(int64(call.tracker.stats.FinalPages)),
+		attributeMemoryGrowEvent.Int(call.tracker.stats.GrowEvents),
+	)
+	if kind := trapKind(call, err); kind != "" {
+		span.SetAttributes(attributeTrapKind.String(kind))
+	}
+	endSpan(span, err)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute wasm module function %q from %q: %w", functionName, i.wasmFile, err)
+	}
+
+	if guestErr := guestErrorOf(call, result, returns); guestErr != nil {
+		return nil, fmt.Errorf("wasm module function %q from %q rejected the call: %w", functionName, i.wasmFile, guestErr)
+	}
+
+	if prototype := r.resultTypes[functionName]; prototype != nil {
+		result, err = r.liftResult(i.heap, result, prototype)
+		if err != nil {
+			return nil, fmt.Errorf("unable to lift wasm module function %q result from %q: %w", functionName, i.wasmFile, err)
+		}
+	} else if ptr, ok := result.(int32); ok && i.heap.rtti != nil {
+		result, err = i.heap.Decode(ptr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode wasm module function %q result from %q: %w", functionName, i.wasmFile, err)
+		}
+	}
+
+	r.logger.Info("execution result", zap.Reflect("result", result))
+
+	outputs := make(map[string]interface{}, len(returns))
+	for _, returnValue := range returns {
+		outputs[returnValue.Name()] = returnValue.Value()
+	}
+
+	return &ExecutionResult{
+		Value:         result,
+		Outputs:       outputs,
+		Duration:      time.Since(start),
+		GuestDuration: call.guestDuration,
+		HostDuration:  call.hostDuration,
+		HostCalls:     call.hostCalls,
+		Memory:        call.tracker.stats,
+		Logs:          call.logs,
+		DroppedLogs:   call.dropped + call.unretained,
+		ModuleHash:    i.moduleHash,
+	}, nil
+}
+
+// ModuleHash returns the hex encoded SHA-256 of the module binary.
+func (i *Instance) ModuleHash() string {
+	return i.moduleHash
+}
+
+// Run executes the whole program of a WASI command module by calling its `_start` export
+// and returns its exit status, 0 when the program returned without calling `proc_exit`. A
+// command module can only be run once per instance.
+func (i *Instance) Run() (exitCode int32, err error) {
+	if i.started {
+		return 0, fmt.Errorf("wasm module %q already ran", i.wasmFile)
+	}
+	i.started = true
+
+	start, err := i.instance.Exports.GetRawFunction(commandEntrypoint)
+	if err != nil {
+		return 0, fmt.Errorf("wasm module %q is not a command module: %w", i.wasmFile, err)
+	}
+
+	call := i.begin(context.Background(), commandEntrypoint)
+	_, err = start.Call()
+	if err = i.end(call, asProcExitError(err)); err != nil {
+		var exitErr *ProcExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.Code, nil
+		}
+
+		return 0, fmt.Errorf("unable to run wasm module %q: %w", i.wasmFile, err)
+	}
+
+	return 0, nil
+}
+
+// MemoryStats returns the memory statistics of the last call made on the instance.
+func (i *Instance) MemoryStats() MemoryStats {
+	return i.memoryStats
+}
+
+// Close releases the resources held by the instance, it must not be used afterwards.
+func (i *Instance) Close() {
+	i.instance.Close()
+	i.module.Close()
+	i.store.Close()
+}
+
+// begin makes `function` the invocation seen by the host functions of the instance.
+func (i *Instance) begin(ctx context.Context, function string) *invocation {
+	call := newInvocation(ctx, i.runtime, i.name, function, i.access)
+	call.tracker = newMemoryTracker(i.memory, i.memoryGrows)
+	call.heap = i.heap
+	if i.runtime.profiler != nil {
+		call.profile = newCallProfile(i.runtime.profiler)
+	}
+
+	i.heap.tracker = call.tracker
+	i.host.invocation = call
+	i.host.clearFailure()
+	return call
+}
+
+// end completes `call` then stops tracking its memory accesses, see complete and release.
+func (i *Instance) end(call *invocation, err error) error {
+	err = i.complete(call, err)
+	i.release(call)
+	return err
+}
+
+// release stops tracking the memory accesses of `call` and reports its memory statistics,
+// once the host is done reading its results.
+func (i *Instance) release(call *invocation) {
+	i.heap.tracker = nil
+
+	i.memoryStats = call.tracker.stats
+	i.runtime.metrics.observeMemory(i.name, call.function, i.memoryStats)
+	if i.runtime.memoryStats != nil {
+		i.runtime.memoryStats(i.name, call.function, i.memoryStats)
+	}
+}
+
+// complete terminates `call` and forwards the buffered WASI output of the module, returning
+// `err` if set (preferring the error of the host function that aborted the call) or the
+// flushing error otherwise.
+func (i *Instance) complete(call *invocation, err error) error {
+	call.end()
+	i.host.invocation = nil
+	if call.profile != nil {
+		call.profile.finish()
+	}
+	call.tracker.finish()
+
+	if err != nil && call.err != nil {
+		// The trap raised by a failing host function lost the original error
+		err = call.err
+	}
+	i.runtime.metrics.observeCall(call, time.Since(call.started), err)
+
+	if i.wasi == nil {
+		return err
+	}
+
+	if flushErr := i.wasi.flush(); flushErr != nil && err == nil {
+		return flushErr
+	}
+	return err
+}
+
+func moduleHash(wasmBytes []byte) string {
+	hash := sha256.Sum256(wasmBytes)
+	return hex.EncodeToString(hash[:])
+}
+
+// moduleName identifies the module found in `wasmFile` by its file name without extension.
+func moduleName(wasmFile string) string {
+	return strings.TrimSuffix(filepath.Base(wasmFile), filepath.Ext(wasmFile))
+}
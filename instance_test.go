@@ -0,0 +1,75 @@
+package wasm
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const reactorTestModule = `
+(module
+  (memory (export "memory") 1)
+  (global $initialized (mut i32) (i32.const 0))
+  (global $calls (mut i32) (i32.const 0))
+
+  (func (export "_initialize")
+    (global.set $initialized (i32.add (global.get $initialized) (i32.const 1))))
+
+  (func (export "state") (result i32)
+    (global.set $calls (i32.add (global.get $calls) (i32.const 1)))
+    (i32.add (i32.mul (global.get $initialized) (i32.const 100)) (global.get $calls))))
+`
+
+func TestInstance_ReactorInitializer(t *testing.T) {
+	wasmFile := writeTestModule(t, reactorTestModule)
+	runtime := NewRuntime(&RustEnvironment{})
+
+	instance, err := runtime.Instantiate(wasmFile)
+	require.NoError(t, err)
+	defer instance.Close()
+
+	for _, expected := range []int32{101, 102, 103} {
+		actual, err := instance.Execute("state", nil)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual.Value)
+	}
+
+	actual, err := runtime.Execute(wasmFile, "state", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(101), actual.Value)
+}
+
+const commandTestModule = `
+(module
+  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))
+  (memory (export "memory") 1)
+  (global $code (export "code") (mut i32) (i32.const %d))
+
+  (func (export "_start")
+    (if (global.get $code) (then (call $proc_exit (global.get $code))))))
+`
+
+func TestInstance_Run(t *testing.T) {
+	runtime := NewRuntime(&RustEnvironment{}, WithWASI(WASIConfig{Stdout: io.Discard, Stderr: io.Discard}))
+
+	for _, code := range []int32{0, 7} {
+		exitCode, err := runtime.Run(writeTestModule(t, fmt.Sprintf(commandTestModule, code)))
+		require.NoError(t, err)
+		assert.Equal(t, code, exitCode)
+	}
+
+	instance, err := runtime.Instantiate(writeTestModule(t, fmt.Sprintf(commandTestModule, 0)))
+	require.NoError(t, err)
+	defer instance.Close()
+
+	_, err = instance.Run()
+	require.NoError(t, err)
+	_, err = instance.Run()
+	assert.Error(t, err)
+
+	_, err = NewRuntime(&RustEnvironment{}).Run(writeTestModule(t, reactorTestModule))
+	assert.Error(t, err)
+}
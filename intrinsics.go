@@ -4,14 +4,13 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/wasmerio/wasmer-go/wasmer"
 	"go.uber.org/zap"
 )
 
-func newImports(runtimeEnv Environment, store *wasmer.Store) *wasmer.ImportObject {
-	importObject := wasmer.NewImportObject()
-
+func registerImports(importObject *wasmer.ImportObject, functions []impl, host *hostContext, store *wasmer.Store) {
 	byModule := map[string][]impl{}
 	for _, function := range functions {
 		byModule[function.module] = append(byModule[function.module], function)
@@ -35,26 +34,50 @@ func newImports(runtimeEnv Environment, store *wasmer.Store) *wasmer.ImportObjec
 		for _, i := range impls {
 			impl := i
 			function := impl.function
+			results := valueKinds(impl.functionDef.Results())
 			if ztracer.Enabled() {
-				function = func(env Environment, args []wasmer.Value) (out []wasmer.Value, err error) {
+				function = func(call *invocation, args []wasmer.Value) (out []wasmer.Value, err error) {
 					name := impl.module + "/" + impl.name
-					defer func() { zlog.Debug("terminated "+name+" returned "+valueSet(out).String(), zap.Error(err)) }()
+					defer func() { call.logger().Debug("terminated "+name+" returned "+valueSet(out).String(), zap.Error(err)) }()
 
-					zlog.Debug("invoking " + name + valueSet(args).String())
-					out, err = impl.function(env, args)
+					call.logger().Debug("invoking " + name + valueSet(args).String())
+					out, err = impl.function(call, args)
 					return
 				}
 			}
 
-			namespace[impl.name] = wasmer.NewFunctionWithEnvironment(store, impl.functionDef, runtimeEnv, func(env interface{}, args []wasmer.Value) ([]wasmer.Value, error) {
-				return function(env.(Environment), args)
+			namespace[impl.name] = wasmer.NewFunctionWithEnvironment(store, impl.functionDef, host, func(env interface{}, args []wasmer.Value) ([]wasmer.Value, error) {
+				call := env.(*hostContext).invocation
+				if call == nil {
+					return host.fail(fmt.Errorf("host function %s.%s called out of any invocation", impl.module, impl.name), results), nil
+				}
+				call.tracker.observe()
+
+				if call.profile != nil {
+					call.profile.enterHost(impl.module + "." + impl.name)
+				}
+
+				span := startHostCallSpan(call, impl.module+"."+impl.name)
+				start := time.Now()
+				out, err := function(call, args)
+				call.hostDuration += time.Since(start)
+				call.hostCalls++
+				call.runtime.metrics.observeHostCall(call, impl.module, impl.name)
+				endSpan(span, err)
+
+				if call.profile != nil {
+					call.profile.exit()
+				}
+
+				if err != nil {
+					return host.fail(err, results), nil
+				}
+				return out, nil
 			})
 		}
 
 		importObject.Register(module, namespace)
 	}
-
-	return importObject
 }
 
 type impl struct {
@@ -72,20 +95,20 @@ func (i impl) alias(module string, name string) impl {
 	return impl{module, name, i.functionDef, i.function}
 }
 
-var functions = []impl{
+var intrinsicFunctions = []impl{
 	// Env module
 
 	intrinsics(
 		"env", "abort",
 		params(wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32),
 		returns(),
-		func(env Environment, args []wasmer.Value) ([]wasmer.Value, error) {
-			message, err := env.ReadString(args[0].I32(), 0) // FIXME
+		func(call *invocation, args []wasmer.Value) ([]wasmer.Value, error) {
+			message, err := call.readAscString(args[0].I32())
 			if err != nil {
 				return nil, fmt.Errorf("read message argument: %w", err)
 			}
 
-			filename, err := env.ReadString(args[1].I32(), 0) // FIXME
+			filename, err := call.readAscString(args[1].I32())
 			if err != nil {
 				return nil, fmt.Errorf("read filename argument: %w", err)
 			}
@@ -100,17 +123,93 @@ var functions = []impl{
 		"env", "println",
 		params(wasmer.I32, wasmer.I32),
 		returns(),
-		func(env Environment, args []wasmer.Value) ([]wasmer.Value, error) {
-			message, err := env.ReadString(args[0].I32(), args[1].I32())
+		func(call *invocation, args []wasmer.Value) ([]wasmer.Value, error) {
+			message, err := call.memory.ReadString(args[0].I32(), args[1].I32())
+			if err != nil {
+				return nil, fmt.Errorf("read message argument: %w", err)
+			}
+
+			call.printGuestOutput(message)
+
+			return nil, nil
+		},
+	),
+
+	intrinsics(
+		"env", "register_panic",
+		params(wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32),
+		returns(),
+		func(call *invocation, args []wasmer.Value) ([]wasmer.Value, error) {
+			message, err := call.memory.ReadString(args[0].I32(), args[1].I32())
 			if err != nil {
 				return nil, fmt.Errorf("read message argument: %w", err)
 			}
 
-			fmt.Println(message)
+			filename, err := call.memory.ReadString(args[2].I32(), args[3].I32())
+			if err != nil {
+				return nil, fmt.Errorf("read filename argument: %w", err)
+			}
 
+			// The panic hook returns to the guest which then traps, the recorded panic
+			// replaces the trap once the call failed
+			call.fail(&GuestPanicError{message, filename, int(args[4].I32()), int(args[5].I32())})
 			return nil, nil
 		},
 	),
+
+	intrinsics(
+		"env", "set_error",
+		params(wasmer.I32, wasmer.I32),
+		returns(),
+		func(call *invocation, args []wasmer.Value) ([]wasmer.Value, error) {
+			message, err := call.memory.ReadString(args[0].I32(), args[1].I32())
+			if err != nil {
+				return nil, fmt.Errorf("read message argument: %w", err)
+			}
+
+			call.guestErr = &GuestError{Message: message}
+			return nil, nil
+		},
+	),
+
+	// Log module
+
+	intrinsics(
+		"log", "log",
+		params(wasmer.I32, wasmer.I32, wasmer.I32),
+		returns(),
+		func(call *invocation, args []wasmer.Value) ([]wasmer.Value, error) {
+			message, err := call.memory.ReadString(args[1].I32(), args[2].I32())
+			if err != nil {
+				return nil, fmt.Errorf("read message argument: %w", err)
+			}
+
+			return nil, call.guestLog(GuestLogLevel(args[0].I32()), message, nil, 0)
+		},
+	),
+	intrinsics(
+		"log", "log_structured",
+		params(wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32),
+		returns(),
+		func(call *invocation, args []wasmer.Value) ([]wasmer.Value, error) {
+			message, err := call.memory.ReadString(args[1].I32(), args[2].I32())
+			if err != nil {
+				return nil, fmt.Errorf("read message argument: %w", err)
+			}
+
+			encoded, err := call.memory.ReadBytes(args[3].I32(), args[4].I32())
+			if err != nil {
+				return nil, fmt.Errorf("read fields argument: %w", err)
+			}
+
+			fields, err := decodeGuestLogFields(GuestLogFieldsEncoding(args[5].I32()), encoded)
+			if err != nil {
+				return nil, fmt.Errorf("decode fields argument: %w", err)
+			}
+
+			return nil, call.guestLog(GuestLogLevel(args[0].I32()), message, fields, len(encoded))
+		},
+	),
 }
 
 // Old way of doing things
@@ -173,7 +272,7 @@ func returns(kinds ...wasmer.ValueKind) []*wasmer.ValueType {
 	return wasmer.NewValueTypes(kinds...)
 }
 
-type implFunc func(env Environment, args []wasmer.Value) ([]wasmer.Value, error)
+type implFunc func(call *invocation, args []wasmer.Value) ([]wasmer.Value, error)
 
 type valueSet []wasmer.Value
 
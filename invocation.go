@@ -0,0 +1,105 @@
+package wasm
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+	"go.uber.org/zap"
+)
+
+var lastInvocationID uint64
+
+// invocation is a single call into a module (an export, its initializer or its entrypoint),
+// it is handed to the host functions called while it runs.
+type invocation struct {
+	id       uint64
+	module   string
+	function string
+	runtime  *Runtime
+	memory   MemoryAccess
+	heap     *AscHeap
+	context  *CallContext
+	tracker  *memoryTracker
+	profile  *callProfile
+	started  time.Time
+	dropped  int
+	logs     []GuestLog
+
+	// logsSize is the size of the messages kept in logs, unretained the number of messages
+	// over the retention limits.
+	logsSize   int
+	unretained int
+
+	hostCalls      int
+	hostDuration   time.Duration
+	parameterBytes uint64
+	guestDuration  time.Duration
+
+	// err is the first error returned by a host function, wasmer turns it into a trap
+	// keeping its message only.
+	err error
+
+	// guestErr is the error reported by the module through `env.set_error`.
+	guestErr *GuestError
+}
+
+func newInvocation(ctx context.Context, runtime *Runtime, module, function string, memory MemoryAccess) *invocation {
+	call := &invocation{
+		id:       atomic.AddUint64(&lastInvocationID, 1),
+		module:   module,
+		function: function,
+		runtime:  runtime,
+		memory:   memory,
+		started:  time.Now(),
+	}
+
+	call.context = &CallContext{
+		Context:      ctx,
+		Module:       module,
+		Function:     function,
+		InvocationID: call.id,
+		Memory:       memory,
+		Environment:  runtime.env,
+		invocation:   call,
+	}
+
+	return call
+}
+
+// readAscString reads the AssemblyScript `String` at `ptr`, which may be null.
+func (c *invocation) readAscString(ptr int32) (string, error) {
+	if ptr == 0 {
+		return "", nil
+	}
+	return c.heap.ReadString(ptr)
+}
+
+func (c *invocation) logger() *zap.Logger {
+	return c.runtime.logger.With(zap.String("module", c.module), zap.String("function", c.function), zap.Uint64("invocation_id", c.id))
+}
+
+// fail records the error a host function failed with.
+func (c *invocation) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// end reports what happened during the invocation that was not reported as it ran.
+func (c *invocation) end() {
+	if c.dropped > 0 {
+		c.logger().Warn("guest output over the rate limit was dropped", zap.Int("dropped", c.dropped))
+	}
+}
+
+// hostContext is the environment of the host functions of an instance, giving them access
+// to the invocation currently running.
+type hostContext struct {
+	invocation *invocation
+
+	// failedGlobal is set for the module to trap once a host function failed, see fail.
+	failedGlobal *wasmer.Global
+	failed       bool
+}
@@ -4,4 +4,4 @@ import (
 	"github.com/streamingfast/logging"
 )
 
-var zlog, ztracer = logging.PackageLogger("wasn-runtime", "github.com/streamingfast/wasm-runtime")
+var zlog, ztracer = logging.PackageLogger("wasm-runtime", "github.com/streamingfast/wasm-runtime")
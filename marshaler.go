@@ -0,0 +1,34 @@
+package wasm
+
+// Marshaler is implemented by types that know how to lower themselves when passed as
+// parameters to Execute. MarshalWASM writes whatever the value needs into the module memory
+// through `heap` and returns the wasm values (`int32`, `int64`, `float32` or `float64`) the
+// parameter expands into, in order. A pointer and length pair for example expands into two
+// `int32` values.
+type Marshaler interface {
+	MarshalWASM(heap *AscHeap) ([]interface{}, error)
+}
+
+// Unmarshaler is implemented by types that know how to lift themselves from the values
+// returned by a function, see WithResultType. UnmarshalWASM receives the returned wasm values
+// not consumed yet, along with `heap` to read the module memory, and returns how many of
+// those values it consumed.
+type Unmarshaler interface {
+	UnmarshalWASM(heap *AscHeap, values []interface{}) (consumed int, err error)
+}
+
+// MarshalerFunc adapts a function to the Marshaler interface.
+type MarshalerFunc func(heap *AscHeap) ([]interface{}, error)
+
+func (f MarshalerFunc) MarshalWASM(heap *AscHeap) ([]interface{}, error) {
+	return f(heap)
+}
+
+func (c AscClass) MarshalWASM(heap *AscHeap) ([]interface{}, error) {
+	ptr, err := heap.WriteClass(c.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{ptr}, nil
+}
@@ -0,0 +1,54 @@
+package wasm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const marshalerTestModule = `
+(module
+  (memory (export "memory") 1)
+
+  (func (export "checksum") (param $ptr i32) (param $len i32) (param $salt i64) (result i32 i64)
+    (i32.add (i32.load8_u (local.get $ptr)) (local.get $len))
+    (local.get $salt)))
+`
+
+type testAddress [4]byte
+
+func (a testAddress) MarshalWASM(heap *AscHeap) ([]interface{}, error) {
+	ptr, err := heap.Write(a[:])
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{ptr, int32(len(a)), int64(99)}, nil
+}
+
+type testChecksum struct {
+	Value int32
+	Salt  int64
+}
+
+func (c *testChecksum) UnmarshalWASM(heap *AscHeap, values []interface{}) (int, error) {
+	if len(values) < 2 {
+		return 0, fmt.Errorf("expected 2 values, got %d", len(values))
+	}
+
+	c.Value, c.Salt = values[0].(int32), values[1].(int64)
+	return 2, nil
+}
+
+func TestRuntime_Marshaler(t *testing.T) {
+	wasmFile := writeTestModule(t, marshalerTestModule)
+
+	runtime := NewRuntime(&RustEnvironment{}, WithResultType("checksum", testChecksum{}))
+	actual, err := runtime.Execute(wasmFile, "checksum", []interface{}{testAddress{0x10, 0x20, 0x30, 0x40}})
+	require.NoError(t, err)
+	assert.Equal(t, testChecksum{Value: 0x14, Salt: 99}, actual.Value)
+
+	_, err = runtime.Execute(wasmFile, "checksum", []interface{}{struct{}{}})
+	assert.EqualError(t, err, `unable to execute wasm module function "checksum" from "`+wasmFile+`": convert parameter #0: unhandled type struct {} to WASM, implement the Marshaler interface to pass it`)
+}
@@ -0,0 +1,321 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// Allocator allocates `size` bytes in the memory of a module and returns the pointer to
+// the allocated segment.
+type Allocator func(size int32) (int32, error)
+
+// MemoryAccess reads and writes typed values in the memory of a module, all accesses are
+// bounds checked and values are little-endian as in wasm.
+type MemoryAccess interface {
+	ReadU8(ptr int32) (uint8, error)
+	ReadU16(ptr int32) (uint16, error)
+	ReadU32(ptr int32) (uint32, error)
+	ReadU64(ptr int32) (uint64, error)
+	ReadI32(ptr int32) (int32, error)
+	ReadI64(ptr int32) (int64, error)
+	ReadF32(ptr int32) (float32, error)
+	ReadF64(ptr int32) (float64, error)
+	ReadBytes(ptr int32, length int32) ([]byte, error)
+	ReadString(ptr int32, length int32) (string, error)
+	// ReadStruct decodes the `#[repr(C)]` value at `ptr` into `out`, a pointer to a struct.
+	ReadStruct(ptr int32, out interface{}) error
+
+	WriteU8(ptr int32, value uint8) error
+	WriteU16(ptr int32, value uint16) error
+	WriteU32(ptr int32, value uint32) error
+	WriteU64(ptr int32, value uint64) error
+	WriteI32(ptr int32, value int32) error
+	WriteI64(ptr int32, value int64) error
+	WriteF32(ptr int32, value float32) error
+	WriteF64(ptr int32, value float64) error
+	WriteBytes(ptr int32, data []byte) error
+	WriteString(ptr int32, value string) error
+	// WriteStruct encodes `value` at `ptr` laid out as a `#[repr(C)]` value, the content of
+	// its strings and byte slices is allocated.
+	WriteStruct(ptr int32, value interface{}) error
+
+	// Allocate allocates `size` bytes through the allocator of the module.
+	Allocate(size int32) (int32, error)
+	// AllocateBytes allocates a copy of `data` and returns its pointer and length.
+	AllocateBytes(data []byte) (int32, int32, error)
+	// AllocateString allocates a copy of `value` and returns its pointer and length.
+	AllocateString(value string) (int32, int32, error)
+	// AllocateStruct allocates `value` laid out as a `#[repr(C)]` value and returns its
+	// pointer.
+	AllocateStruct(value interface{}) (int32, error)
+}
+
+// Memory implements MemoryAccess over the memory of a module instance, allocating through
+// the allocator given by the runtime.
+type Memory struct {
+	memory   *wasmer.Memory
+	allocate Allocator
+}
+
+func NewMemory(memory *wasmer.Memory, allocate Allocator) *Memory {
+	return &Memory{memory, allocate}
+}
+
+func (m *Memory) ReadU8(ptr int32) (uint8, error) {
+	bytes, err := m.segment(ptr, 1)
+	if err != nil {
+		return 0, err
+	}
+	return bytes[0], nil
+}
+
+func (m *Memory) ReadU16(ptr int32) (uint16, error) {
+	bytes, err := m.segment(ptr, 2)
+	if err != nil {
+		return 0, err
+	}
+	return encoding.Uint16(bytes), nil
+}
+
+func (m *Memory) ReadU32(ptr int32) (uint32, error) {
+	bytes, err := m.segment(ptr, 4)
+	if err != nil {
+		return 0, err
+	}
+	return encoding.Uint32(bytes), nil
+}
+
+func (m *Memory) ReadU64(ptr int32) (uint64, error) {
+	bytes, err := m.segment(ptr, 8)
+	if err != nil {
+		return 0, err
+	}
+	return encoding.Uint64(bytes), nil
+}
+
+func (m *Memory) ReadI32(ptr int32) (int32, error) {
+	value, err := m.ReadU32(ptr)
+	return int32(value), err
+}
+
+func (m *Memory) ReadI64(ptr int32) (int64, error) {
+	value, err := m.ReadU64(ptr)
+	return int64(value), err
+}
+
+func (m *Memory) ReadF32(ptr int32) (float32, error) {
+	value, err := m.ReadU32(ptr)
+	return math.Float32frombits(value), err
+}
+
+func (m *Memory) ReadF64(ptr int32) (float64, error) {
+	value, err := m.ReadU64(ptr)
+	return math.Float64frombits(value), err
+}
+
+// ReadBytes returns a copy of the `length` bytes at `ptr`.
+func (m *Memory) ReadBytes(ptr int32, length int32) ([]byte, error) {
+	bytes, err := m.segment(ptr, length)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), bytes...), nil
+}
+
+func (m *Memory) ReadString(ptr int32, length int32) (string, error) {
+	bytes, err := m.segment(ptr, length)
+	if err != nil {
+		return "", fmt.Errorf("read content: %w", err)
+	}
+	return string(bytes), nil
+}
+
+func (m *Memory) ReadStruct(ptr int32, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("expected a non-nil pointer, got %T", out)
+	}
+
+	size, _, err := reprCLayout(rv.Elem().Type())
+	if err != nil {
+		return err
+	}
+
+	bytes, err := m.segment(ptr, size)
+	if err != nil {
+		return err
+	}
+
+	return decodeReprC(bytes, rv.Elem(), m.segment)
+}
+
+func (m *Memory) WriteU8(ptr int32, value uint8) error {
+	return m.WriteBytes(ptr, []byte{value})
+}
+
+func (m *Memory) WriteU16(ptr int32, value uint16) error {
+	bytes := make([]byte, 2)
+	encoding.PutUint16(bytes, value)
+	return m.WriteBytes(ptr, bytes)
+}
+
+func (m *Memory) WriteU32(ptr int32, value uint32) error {
+	bytes := make([]byte, 4)
+	encoding.PutUint32(bytes, value)
+	return m.WriteBytes(ptr, bytes)
+}
+
+func (m *Memory) WriteU64(ptr int32, value uint64) error {
+	bytes := make([]byte, 8)
+	encoding.PutUint64(bytes, value)
+	return m.WriteBytes(ptr, bytes)
+}
+
+func (m *Memory) WriteI32(ptr int32, value int32) error {
+	return m.WriteU32(ptr, uint32(value))
+}
+
+func (m *Memory) WriteI64(ptr int32, value int64) error {
+	return m.WriteU64(ptr, uint64(value))
+}
+
+func (m *Memory) WriteF32(ptr int32, value float32) error {
+	return m.WriteU32(ptr, math.Float32bits(value))
+}
+
+func (m *Memory) WriteF64(ptr int32, value float64) error {
+	return m.WriteU64(ptr, math.Float64bits(value))
+}
+
+func (m *Memory) WriteBytes(ptr int32, data []byte) error {
+	if int64(len(data)) > math.MaxInt32 {
+		return fmt.Errorf("%d bytes exceed the memory address space", len(data))
+	}
+
+	bytes, err := m.segment(ptr, int32(len(data)))
+	if err != nil {
+		return err
+	}
+
+	copy(bytes, data)
+	return nil
+}
+
+func (m *Memory) WriteString(ptr int32, value string) error {
+	return m.WriteBytes(ptr, []byte(value))
+}
+
+func (m *Memory) WriteStruct(ptr int32, value interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+
+	size, _, err := reprCLayout(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	bytes := make([]byte, size)
+	if err := encodeReprC(bytes, rv, m.allocateCopy); err != nil {
+		return err
+	}
+
+	return m.WriteBytes(ptr, bytes)
+}
+
+func (m *Memory) Allocate(size int32) (int32, error) {
+	if m.allocate == nil {
+		return 0, errors.New("no allocator available")
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("invalid allocation size %d", size)
+	}
+
+	return m.allocate(size)
+}
+
+func (m *Memory) AllocateBytes(data []byte) (int32, int32, error) {
+	ptr, err := m.allocateCopy(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ptr, int32(len(data)), nil
+}
+
+func (m *Memory) AllocateString(value string) (int32, int32, error) {
+	return m.AllocateBytes([]byte(value))
+}
+
+func (m *Memory) AllocateStruct(value interface{}) (int32, error) {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+
+	size, _, err := reprCLayout(rv.Type())
+	if err != nil {
+		return 0, err
+	}
+
+	ptr, err := m.Allocate(size)
+	if err != nil {
+		return 0, err
+	}
+
+	return ptr, m.WriteStruct(ptr, value)
+}
+
+func (m *Memory) allocateCopy(data []byte) (int32, error) {
+	if int64(len(data)) > math.MaxInt32 {
+		return 0, fmt.Errorf("%d bytes exceed the memory address space", len(data))
+	}
+
+	ptr, err := m.Allocate(int32(len(data)))
+	if err != nil {
+		return 0, err
+	}
+
+	return ptr, m.WriteBytes(ptr, data)
+}
+
+func (m *Memory) segment(ptr int32, length int32) ([]byte, error) {
+	if m.memory == nil {
+		return nil, errors.New("memory not set")
+	}
+
+	return memorySegment(m.memory.Data(), ptr, length)
+}
+
+// MemoryAccessError is returned when accessing a segment of the module memory that is not
+// within its bounds.
+type MemoryAccessError struct {
+	Ptr        uint32
+	Length     int64
+	MemorySize uint64
+}
+
+func (e *MemoryAccessError) Error() string {
+	if e.Length < 0 {
+		return fmt.Sprintf("invalid negative length %d at %d", e.Length, e.Ptr)
+	}
+
+	return fmt.Sprintf("segment [%d, %d) out of memory bounds ending at %d", e.Ptr, uint64(e.Ptr)+uint64(e.Length), e.MemorySize)
+}
+
+// memorySegment is the single accessor through which module memory is accessed, it returns
+// the `length` bytes at `ptr` within `data`. Pointers come from untrusted modules: they are
+// interpreted as the unsigned 32-bit addresses they are in wasm and bounds are computed on
+// 64 bits so that no pointer and length combination overflows. The returned slice capacity
+// ends with the segment so that appending to it cannot write past it.
+func memorySegment(data []byte, ptr int32, length int32) ([]byte, error) {
+	start := uint64(uint32(ptr))
+	if length < 0 {
+		return nil, &MemoryAccessError{Ptr: uint32(ptr), Length: int64(length), MemorySize: uint64(len(data))}
+	}
+
+	end := start + uint64(length)
+	if end > uint64(len(data)) {
+		return nil, &MemoryAccessError{Ptr: uint32(ptr), Length: int64(length), MemorySize: uint64(len(data))}
+	}
+
+	return data[start:end:end], nil
+}
@@ -0,0 +1,78 @@
+//go:build go1.18
+// +build go1.18
+
+package wasm
+
+import (
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addMemoryAccessSeeds(f *testing.F) {
+	f.Add(int32(0), int32(0))
+	f.Add(int32(65535), int32(1))
+	f.Add(int32(65535), int32(2))
+	f.Add(int32(65536), int32(0))
+	f.Add(int32(-1), int32(1))
+	f.Add(int32(1), int32(-1))
+	f.Add(int32(math.MaxInt32), int32(math.MaxInt32))
+	f.Add(int32(math.MinInt32), int32(math.MaxInt32))
+}
+
+// inMemoryBounds is the reference check for an access of `length` bytes at the unsigned
+// address `ptr` of a memory of `size` bytes.
+func inMemoryBounds(ptr, length int32, size int) bool {
+	return length >= 0 && uint64(uint32(ptr))+uint64(length) <= uint64(size)
+}
+
+func FuzzMemory_ReadBytes(f *testing.F) {
+	addMemoryAccessSeeds(f)
+
+	heap := newTestAscHeap(f)
+	memory := NewMemory(heap.memory, heap.allocate)
+	size := len(heap.memory.Data())
+
+	f.Fuzz(func(t *testing.T, ptr int32, length int32) {
+		data, err := memory.ReadBytes(ptr, length)
+		if !inMemoryBounds(ptr, length, size) {
+			var accessErr *MemoryAccessError
+			require.True(t, errors.As(err, &accessErr), "expected a MemoryAccessError, got %v", err)
+			assert.Equal(t, uint32(ptr), accessErr.Ptr)
+			assert.Equal(t, int64(length), accessErr.Length)
+			return
+		}
+
+		require.NoError(t, err)
+		assert.Len(t, data, int(length))
+		assert.Error(t, memory.WriteBytes(ptr, make([]byte, size-int(uint32(ptr))+1)))
+	})
+}
+
+// FuzzInstance_GuestMemoryAccess hands the fuzzed pairs to the module which passes them to
+// env.println, out of bounds pairs must fail the call with a MemoryAccessError.
+func FuzzInstance_GuestMemoryAccess(f *testing.F) {
+	addMemoryAccessSeeds(f)
+
+	runtime := NewRuntime(&RustEnvironment{}, WithGuestOutput(io.Discard))
+	instance, err := runtime.Instantiate(writeTestModule(f, memoryAccessTestModule))
+	require.NoError(f, err)
+	f.Cleanup(instance.Close)
+
+	f.Fuzz(func(t *testing.T, ptr int32, length int32) {
+		_, err := instance.Execute("print", []interface{}{ptr, length})
+		if inMemoryBounds(ptr, length, len(instance.memory.Data())) {
+			require.NoError(t, err)
+			return
+		}
+
+		var accessErr *MemoryAccessError
+		require.True(t, errors.As(err, &accessErr), "expected a MemoryAccessError, got %v", err)
+		assert.Equal(t, uint32(ptr), accessErr.Ptr)
+		assert.Equal(t, int64(length), accessErr.Length)
+	})
+}
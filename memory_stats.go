@@ -0,0 +1,115 @@
+package wasm
+
+import (
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// MemoryStats describes how an invocation used the memory of the instance it ran on.
+type MemoryStats struct {
+	// InitialPages and FinalPages are the sizes of the memory, in 64KiB pages, when the
+	// invocation started and when it ended. A wasm memory never shrinks, FinalPages is also
+	// the largest size it had.
+	InitialPages uint32
+	FinalPages   uint32
+
+	// HostWrittenBytes and HostReadBytes are the bytes the host wrote (parameters, output
+	// slots, objects) and read back (outputs, results) through the AscHeap.
+	HostWrittenBytes uint64
+	HostReadBytes    uint64
+
+	// GrowEvents is the number of times the memory grew, through a successful `memory.grow`
+	// of the module or for the host to write into it.
+	GrowEvents int
+}
+
+// memoryGrowsGlobalExport is the i32 global counting the successful `memory.grow` executed by
+// the module, see instrumentCode.
+const memoryGrowsGlobalExport = "__wasm_runtime_memory_grows"
+
+// GrowthPages returns the number of pages the memory grew by during the invocation.
+func (s MemoryStats) GrowthPages() uint32 {
+	return s.FinalPages - s.InitialPages
+}
+
+// MemoryStatsFunc receives the memory statistics of each invocation of `function` from
+// `module`.
+type MemoryStatsFunc func(module, function string, stats MemoryStats)
+
+// WithMemoryStatsFunc hands the memory statistics of each invocation to `handler` once it
+// completed, successfully or not.
+func WithMemoryStatsFunc(handler MemoryStatsFunc) RuntimeOption {
+	return func(r *Runtime) {
+		r.memoryStats = handler
+	}
+}
+
+// memoryTracker follows the memory of an instance during an invocation.
+type memoryTracker struct {
+	memory *wasmer.Memory
+	stats  MemoryStats
+
+	// grows is the memoryGrowsGlobalExport global, holding initialGrows when the invocation
+	// started.
+	grows        *wasmer.Global
+	initialGrows int32
+	hostGrows    int
+}
+
+func newMemoryTracker(memory *wasmer.Memory, grows *wasmer.Global) *memoryTracker {
+	pages := memoryPages(memory)
+
+	return &memoryTracker{
+		memory: memory,
+		stats: MemoryStats{
+			InitialPages: pages,
+			FinalPages:   pages,
+		},
+		grows:        grows,
+		initialGrows: globalI32(grows),
+	}
+}
+
+// observe records the current size of the memory.
+func (t *memoryTracker) observe() {
+	t.stats.FinalPages = memoryPages(t.memory)
+}
+
+// grown records a growth of the memory made by the host.
+func (t *memoryTracker) grown() {
+	t.hostGrows++
+	t.observe()
+}
+
+// finish records the final size of the memory and the growths made during the invocation.
+func (t *memoryTracker) finish() {
+	t.observe()
+	// The counter wraps around, only its difference matters
+	t.stats.GrowEvents = t.hostGrows + int(uint32(globalI32(t.grows))-uint32(t.initialGrows))
+}
+
+func (t *memoryTracker) read(length int32) {
+	if t != nil {
+		t.stats.HostReadBytes += uint64(length)
+	}
+}
+
+func (t *memoryTracker) written(length int32) {
+	if t != nil {
+		t.stats.HostWrittenBytes += uint64(length)
+	}
+}
+
+func globalI32(global *wasmer.Global) int32 {
+	if global == nil {
+		return 0
+	}
+
+	// The global is an i32 created by instrumentHostFailures
+	value, _ := global.Get()
+	out, _ := value.(int32)
+	return out
+}
+
+func memoryPages(memory *wasmer.Memory) uint32 {
+	return uint32(len(memory.Data()) / int(wasmer.WasmPageSize))
+}
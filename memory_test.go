@@ -0,0 +1,177 @@
+package wasm
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryTestRecord struct {
+	Kind  uint8
+	Name  string
+	Value int64
+	Ratio float32
+	Tags  [2]uint16
+}
+
+func TestMemory_ReadWrite(t *testing.T) {
+	heap := newTestAscHeap(t)
+	heap.nextPtrLocation = 1024
+	memory := NewMemory(heap.memory, heap.allocate)
+
+	require.NoError(t, memory.WriteU8(0, 0xff))
+	require.NoError(t, memory.WriteU16(2, 0xbeef))
+	require.NoError(t, memory.WriteI32(4, -2))
+	require.NoError(t, memory.WriteI64(8, -3))
+	require.NoError(t, memory.WriteF32(16, 1.5))
+	require.NoError(t, memory.WriteF64(24, -0.25))
+	require.NoError(t, memory.WriteString(32, "hello"))
+
+	u8, err := memory.ReadU8(0)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0xff), u8)
+	u16, err := memory.ReadU16(2)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0xbeef), u16)
+	u32, err := memory.ReadU32(4)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0xfffffffe), u32)
+	i64, err := memory.ReadI64(8)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-3), i64)
+	f32, err := memory.ReadF32(16)
+	require.NoError(t, err)
+	assert.Equal(t, float32(1.5), f32)
+	f64, err := memory.ReadF64(24)
+	require.NoError(t, err)
+	assert.Equal(t, -0.25, f64)
+	str, err := memory.ReadString(32, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", str)
+
+	_, err = memory.ReadU64(65535)
+	assert.EqualError(t, err, "segment [65535, 65543) out of memory bounds ending at 65536")
+	assert.Error(t, memory.WriteBytes(-1, []byte{1}))
+}
+
+func TestMemory_Struct(t *testing.T) {
+	heap := newTestAscHeap(t)
+	heap.nextPtrLocation = 1024
+	memory := NewMemory(heap.memory, heap.allocate)
+
+	record := memoryTestRecord{Kind: 2, Name: "transfer", Value: -10, Ratio: 0.5, Tags: [2]uint16{7, 8}}
+	ptr, err := memory.AllocateStruct(record)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1024), ptr)
+
+	kind, err := memory.ReadU8(ptr)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(2), kind)
+	value, err := memory.ReadI64(ptr + 16)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-10), value)
+
+	actual := memoryTestRecord{}
+	require.NoError(t, memory.ReadStruct(ptr, &actual))
+	assert.Equal(t, record, actual)
+
+	ptr, length, err := memory.AllocateString("data")
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), length)
+	data, err := memory.ReadBytes(ptr, length)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	_, _, err = NewMemory(heap.memory, nil).AllocateBytes([]byte{1})
+	assert.EqualError(t, err, "no allocator available")
+}
+
+const memoryAccessTestModule = `
+(module
+  (import "env" "println" (func $println (param i32 i32)))
+  (memory (export "memory") 1)
+
+  (func (export "print") (param $ptr i32) (param $len i32)
+    (call $println (local.get $ptr) (local.get $len))))
+`
+
+func TestInstance_GuestMemoryAccessError(t *testing.T) {
+	runtime := NewRuntime(&RustEnvironment{}, WithGuestOutput(io.Discard))
+	instance, err := runtime.Instantiate(writeTestModule(t, memoryAccessTestModule))
+	require.NoError(t, err)
+	defer instance.Close()
+
+	_, err = instance.Execute("print", []interface{}{int32(-1), int32(2)})
+	var accessErr *MemoryAccessError
+	require.True(t, errors.As(err, &accessErr), "expected a MemoryAccessError, got %v", err)
+	assert.Equal(t, &MemoryAccessError{Ptr: 0xffffffff, Length: 2, MemorySize: 65536}, accessErr)
+
+	_, err = instance.Execute("print", []interface{}{int32(1), int32(-1)})
+	require.True(t, errors.As(err, &accessErr), "expected a MemoryAccessError, got %v", err)
+	assert.EqualError(t, accessErr, "invalid negative length -1 at 1")
+}
+
+const memoryStatsTestModule = `
+(module
+  (import "env" "println" (func $println (param i32 i32)))
+  (memory (export "memory") 1)
+
+  (func (export "work") (param $ptr i32) (param $len i32) (param $out i32)
+    (drop (memory.grow (i32.const 1)))
+    (call $println (local.get $ptr) (local.get $len))
+    (drop (memory.grow (i32.const 1)))
+    (drop (memory.grow (i32.const 1)))
+    (i32.store (local.get $out) (local.get $ptr))
+    (i32.store offset=4 (local.get $out) (local.get $len))))
+`
+
+func TestInstance_MemoryStats(t *testing.T) {
+	var reported []MemoryStats
+	runtime := NewRuntime(&RustEnvironment{}, WithParameterPointSize(), WithGuestOutput(io.Discard), WithMemoryStatsFunc(func(module, function string, stats MemoryStats) {
+		assert.Equal(t, "module", module)
+		assert.Equal(t, "work", function)
+		reported = append(reported, stats)
+	}))
+
+	instance, err := runtime.Instantiate(writeTestModule(t, memoryStatsTestModule))
+	require.NoError(t, err)
+	defer instance.Close()
+
+	out := NewAscReturnValue("out")
+	_, err = instance.Execute("work", []interface{}{[]byte("hello")}, out)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), out.Value())
+
+	expected := MemoryStats{
+		InitialPages:     1,
+		FinalPages:       4,
+		HostWrittenBytes: 5 + 8,
+		HostReadBytes:    8 + 5,
+		GrowEvents:       3,
+	}
+	assert.Equal(t, expected, instance.MemoryStats())
+	assert.Equal(t, uint32(3), instance.MemoryStats().GrowthPages())
+	assert.Equal(t, []MemoryStats{expected}, reported)
+}
+
+func TestInstance_MemoryStatsResult(t *testing.T) {
+	var reported []MemoryStats
+	runtime := NewRuntime(&RustEnvironment{}, WithParameterPointSize(), WithResultType("pair", ""), WithMemoryStatsFunc(func(module, function string, stats MemoryStats) {
+		reported = append(reported, stats)
+	}))
+
+	instance, err := runtime.Instantiate(writeTestModule(t, resultTestModule))
+	require.NoError(t, err)
+	defer instance.Close()
+
+	result, err := instance.Execute("pair", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", result.Value)
+
+	assert.Equal(t, uint64(5), result.Memory.HostReadBytes, "reading the result counts in the statistics of the call")
+	assert.Equal(t, result.Memory, instance.MemoryStats())
+	assert.Equal(t, []MemoryStats{result.Memory}, reported)
+}
@@ -0,0 +1,228 @@
+package wasm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// Metrics collects the Prometheus metrics of the runtimes it is given to (see WithMetrics).
+// It is a prometheus.Collector, to be registered by the caller on the registry of its
+// choice. Modules are identified by their file name without extension.
+type Metrics struct {
+	compileDuration     *prometheus.HistogramVec
+	instantiateDuration *prometheus.HistogramVec
+	callDuration        *prometheus.HistogramVec
+	traps               *prometheus.CounterVec
+	hostCalls           *prometheus.CounterVec
+	memoryGrowEvents    *prometheus.CounterVec
+	memoryPages         *prometheus.HistogramVec
+	hostWrittenBytes    *prometheus.HistogramVec
+	hostReadBytes       *prometheus.HistogramVec
+	poolIdle            *prometheus.GaugeVec
+	poolInUse           *prometheus.GaugeVec
+}
+
+var (
+	durationBuckets = prometheus.ExponentialBuckets(0.0001, 4, 10)
+	pagesBuckets    = prometheus.ExponentialBuckets(1, 4, 9)
+	bytesBuckets    = prometheus.ExponentialBuckets(64, 4, 10)
+)
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		compileDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wasm_compile_duration_seconds",
+			Help:    "Time taken to compile a module.",
+			Buckets: durationBuckets,
+		}, []string{"module"}),
+		instantiateDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wasm_instantiate_duration_seconds",
+			Help:    "Time taken to instantiate a module, compilation and initializer included.",
+			Buckets: durationBuckets,
+		}, []string{"module"}),
+		callDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wasm_call_duration_seconds",
+			Help:    "Time taken by calls into module exports, successful or not.",
+			Buckets: durationBuckets,
+		}, []string{"module", "function"}),
+		traps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wasm_traps_total",
+			Help: "Calls into module exports that trapped, by kind of trap.",
+		}, []string{"module", "function", "kind"}),
+		hostCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wasm_host_calls_total",
+			Help: "Calls made by modules to host functions.",
+		}, []string{"module", "host_function"}),
+		memoryGrowEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wasm_memory_grow_events_total",
+			Help: "Growths of the memory of module instances, see MemoryStats.GrowEvents.",
+		}, []string{"module"}),
+		memoryPages: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wasm_memory_pages",
+			Help:    "Size of the memory, in 64KiB pages, at the end of calls into module exports.",
+			Buckets: pagesBuckets,
+		}, []string{"module", "function"}),
+		hostWrittenBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wasm_host_written_bytes",
+			Help:    "Bytes written by the host in the memory of modules per call, see MemoryStats.HostWrittenBytes.",
+			Buckets: bytesBuckets,
+		}, []string{"module", "function"}),
+		hostReadBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wasm_host_read_bytes",
+			Help:    "Bytes read by the host from the memory of modules per call, see MemoryStats.HostReadBytes.",
+			Buckets: bytesBuckets,
+		}, []string{"module", "function"}),
+		poolIdle: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wasm_pool_idle_instances",
+			Help: "Instances kept idle by instance pools.",
+		}, []string{"module"}),
+		poolInUse: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wasm_pool_in_use_instances",
+			Help: "Instances taken from instance pools and not given back yet.",
+		}, []string{"module"}),
+	}
+}
+
+// WithMetrics records the metrics of the runtime in `metrics`, which can be shared by
+// several runtimes.
+func WithMetrics(metrics *Metrics) RuntimeOption {
+	return func(r *Runtime) {
+		r.metrics = metrics
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.compileDuration, m.instantiateDuration, m.callDuration, m.traps, m.hostCalls,
+		m.memoryGrowEvents, m.memoryPages, m.hostWrittenBytes, m.hostReadBytes, m.poolIdle, m.poolInUse,
+	}
+}
+
+func (m *Metrics) Describe(descs chan<- *prometheus.Desc) {
+	for _, collector := range m.collectors() {
+		collector.Describe(descs)
+	}
+}
+
+func (m *Metrics) Collect(metrics chan<- prometheus.Metric) {
+	for _, collector := range m.collectors() {
+		collector.Collect(metrics)
+	}
+}
+
+// The following methods are no-ops on a nil *Metrics, the runtime calls them regardless
+// of WithMetrics.
+
+func (m *Metrics) observeCompile(module string, duration time.Duration) {
+	if m != nil {
+		m.compileDuration.WithLabelValues(module).Observe(duration.Seconds())
+	}
+}
+
+func (m *Metrics) observeInstantiate(module string, duration time.Duration) {
+	if m != nil {
+		m.instantiateDuration.WithLabelValues(module).Observe(duration.Seconds())
+	}
+}
+
+func (m *Metrics) observeCall(call *invocation, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.callDuration.WithLabelValues(call.module, call.function).Observe(duration.Seconds())
+	if call.tracker.stats.GrowEvents > 0 {
+		m.memoryGrowEvents.WithLabelValues(call.module).Add(float64(call.tracker.stats.GrowEvents))
+	}
+
+	if kind := trapKind(call, err); kind != "" {
+		m.traps.WithLabelValues(call.module, call.function, kind).Inc()
+	}
+}
+
+// observeMemory records the memory statistics of a call, once the host is done reading its
+// results.
+func (m *Metrics) observeMemory(module, function string, stats MemoryStats) {
+	if m == nil {
+		return
+	}
+
+	m.memoryPages.WithLabelValues(module, function).Observe(float64(stats.FinalPages))
+	m.hostWrittenBytes.WithLabelValues(module, function).Observe(float64(stats.HostWrittenBytes))
+	m.hostReadBytes.WithLabelValues(module, function).Observe(float64(stats.HostReadBytes))
+}
+
+func (m *Metrics) observeHostCall(call *invocation, hostModule, hostFunction string) {
+	if m != nil {
+		m.hostCalls.WithLabelValues(call.module, hostModule+"."+hostFunction).Inc()
+	}
+}
+
+func (m *Metrics) poolTaken(module string, fromIdle bool) {
+	if m == nil {
+		return
+	}
+
+	if fromIdle {
+		m.poolIdle.WithLabelValues(module).Dec()
+	}
+	m.poolInUse.WithLabelValues(module).Inc()
+}
+
+func (m *Metrics) poolGivenBack(module string, kept bool) {
+	if m == nil {
+		return
+	}
+
+	m.poolInUse.WithLabelValues(module).Dec()
+	if kept {
+		m.poolIdle.WithLabelValues(module).Inc()
+	}
+}
+
+func (m *Metrics) poolClosed(module string, idle int) {
+	if m != nil {
+		m.poolIdle.WithLabelValues(module).Sub(float64(idle))
+	}
+}
+
+// trapKind classifies the error `call` failed with, returning an empty string when the call
+// did not trap: it failed before reaching the module or the module called `proc_exit`.
+func trapKind(call *invocation, err error) string {
+	if err == nil {
+		return ""
+	}
+
+	if call.err == nil {
+		var trapErr *wasmer.TrapError
+		if errors.As(err, &trapErr) {
+			return "trap"
+		}
+		return ""
+	}
+
+	var (
+		exitErr     *ProcExitError
+		panicErr    *GuestPanicError
+		abortErr    *abortError
+		criticalErr *CriticalLogError
+		accessErr   *MemoryAccessError
+	)
+
+	switch {
+	case errors.As(call.err, &exitErr):
+		return ""
+	case errors.As(call.err, &panicErr):
+		return "panic"
+	case errors.As(call.err, &abortErr):
+		return "abort"
+	case errors.As(call.err, &criticalErr):
+		return "critical_log"
+	case errors.As(call.err, &accessErr):
+		return "memory_access"
+	}
+	return "host_error"
+}
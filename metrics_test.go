@@ -0,0 +1,71 @@
+package wasm
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const metricsTestModule = `
+(module
+  (import "env" "println" (func $println (param i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "hello")
+
+  (func (export "handle") (param $mode i32)
+    (call $println (i32.const 1024) (i32.const 5))
+    (if (i32.eq (local.get $mode) (i32.const 1)) (then (unreachable)))
+    (if (i32.eq (local.get $mode) (i32.const 2)) (then (call $println (i32.const -1) (i32.const 5))))
+    (drop (memory.grow (i32.const 1)))))
+`
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(metrics))
+
+	wasmFile := writeTestModule(t, metricsTestModule)
+	runtime := NewRuntime(&RustEnvironment{}, WithMetrics(metrics), WithGuestOutput(io.Discard))
+
+	pool := runtime.NewInstancePool(wasmFile, 2)
+	_, err := pool.Execute(context.Background(), "handle", []interface{}{int32(0)})
+	require.NoError(t, err)
+	_, err = pool.Execute(context.Background(), "handle", []interface{}{int32(1)})
+	require.Error(t, err)
+	_, err = pool.Execute(context.Background(), "handle", []interface{}{int32(2)})
+	require.Error(t, err)
+
+	first, err := pool.Get()
+	require.NoError(t, err)
+	second, err := pool.Get()
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.poolInUse.WithLabelValues("module")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.poolIdle.WithLabelValues("module")))
+
+	pool.Put(first)
+	pool.Put(second)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.poolInUse.WithLabelValues("module")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.poolIdle.WithLabelValues("module")))
+	pool.Close()
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.poolIdle.WithLabelValues("module")))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.traps.WithLabelValues("module", "handle", "trap")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.traps.WithLabelValues("module", "handle", "memory_access")))
+	assert.Equal(t, float64(4), testutil.ToFloat64(metrics.hostCalls.WithLabelValues("module", "env.println")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.memoryGrowEvents.WithLabelValues("module")))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.memoryPages))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.hostWrittenBytes))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.hostReadBytes))
+
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.compileDuration))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.callDuration))
+
+	lint, err := testutil.CollectAndLint(metrics)
+	require.NoError(t, err)
+	assert.Empty(t, lint)
+}
@@ -0,0 +1,224 @@
+package wasm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// OutputDecoder describes an output slot, a segment of memory reserved by the host and
+// passed by pointer to the function which writes its output value in it.
+type OutputDecoder interface {
+	// SlotSize is the minimum number of bytes the slot must have for the decoder.
+	SlotSize() int32
+
+	// Decode turns the slot content into a Go value once the function returned, reading
+	// the memory it points to through `read` if required.
+	Decode(slot []byte, read MemoryReader) (interface{}, error)
+}
+
+// AscReturnValue is an output slot passed to the function as an extra pointer parameter,
+// its content is decoded after the call by its OutputDecoder and exposed through Value.
+type AscReturnValue struct {
+	name     string
+	ptr      int32
+	slotSize int32
+	decoder  OutputDecoder
+	value    interface{}
+
+	// err is the reason the slot is invalid, returned by the calls it is given to.
+	err error
+}
+
+// OutputError is returned by the calls given an output slot which cannot be used, see
+// NewScalarOutput, NewStructOutput and WithSlotSize.
+type OutputError struct {
+	Output string
+	Err    error
+}
+
+func (e *OutputError) Error() string {
+	return fmt.Sprintf("invalid output %q: %s", e.Output, e.Err)
+}
+
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+// NewAscReturnValue creates an output slot receiving a `(ptr, len)` pair of u32 pointing
+// to a segment of bytes, decoded as a `[]byte`.
+func NewAscReturnValue(name string) *AscReturnValue {
+	return NewOutput(name, bytesOutput{})
+}
+
+// NewStringOutput creates an output slot receiving a `(ptr, len)` pair of u32 pointing to
+// an UTF-8 string, decoded as a `string`.
+func NewStringOutput(name string) *AscReturnValue {
+	return NewOutput(name, bytesOutput{asString: true})
+}
+
+// NewNullableBytesOutput creates an output slot receiving an `Option<(ptr, len)>` laid out
+// as a u32 discriminant followed by the `(ptr, len)` pair, decoded as a `[]byte` which is
+// `nil` when the option is `None`.
+func NewNullableBytesOutput(name string) *AscReturnValue {
+	return NewOutput(name, nullableBytesOutput{})
+}
+
+// NewScalarOutput creates an output slot receiving a single value of the same type as
+// `prototype` (`uint64(0)` for an u64 counter, `int32(0)` for an error code, ...). When
+// `prototype` has no `repr(C)` layout, the calls given the slot fail with an OutputError.
+func NewScalarOutput(name string, prototype interface{}) *AscReturnValue {
+	return newReprCOutputValue(name, prototype)
+}
+
+// NewStructOutput creates an output slot receiving a `#[repr(C)]` struct laid out like
+// `prototype`, decoded as a value of the same type. When `prototype` has no `repr(C)`
+// layout, the calls given the slot fail with an OutputError.
+func NewStructOutput(name string, prototype interface{}) *AscReturnValue {
+	return newReprCOutputValue(name, prototype)
+}
+
+func newReprCOutputValue(name string, prototype interface{}) *AscReturnValue {
+	decoder, err := newReprCOutput(prototype)
+	out := NewOutput(name, decoder)
+	if err != nil {
+		out.err = &OutputError{name, err}
+	}
+	return out
+}
+
+// NewOutput creates an output slot decoded by `decoder`.
+func NewOutput(name string, decoder OutputDecoder) *AscReturnValue {
+	return &AscReturnValue{
+		name:     name,
+		slotSize: decoder.SlotSize(),
+		decoder:  decoder,
+	}
+}
+
+// WithSlotSize reserves `size` bytes for the slot instead of the decoder's default. It
+// cannot be smaller than the decoder's SlotSize, the calls given the slot fail with an
+// OutputError otherwise.
+func (v *AscReturnValue) WithSlotSize(size int32) *AscReturnValue {
+	if size < v.decoder.SlotSize() && v.err == nil {
+		v.err = &OutputError{v.name, fmt.Errorf("slot size %d is smaller than the %d bytes required by its decoder", size, v.decoder.SlotSize())}
+	}
+
+	v.slotSize = size
+	return v
+}
+
+func (v *AscReturnValue) Name() string {
+	return v.name
+}
+
+// Value returns the decoded content of the slot, available once the function returned.
+func (v *AscReturnValue) Value() interface{} {
+	return v.value
+}
+
+func (v *AscReturnValue) ToPtr(heap *AscHeap) (int32, int32, error) {
+	bs := make([]byte, v.slotSize)
+	ptr, err := heap.Write(bs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	v.ptr = ptr
+	return ptr, int32(len(bs)), nil
+}
+
+func (v *AscReturnValue) decode(heap *AscHeap) error {
+	slot, err := heap.read(v.ptr, v.slotSize)
+	if err != nil {
+		return fmt.Errorf("reading output %q slot: %w", v.name, err)
+	}
+
+	v.value, err = v.decoder.Decode(slot, heap.read)
+	if err != nil {
+		return fmt.Errorf("decoding output %q: %w", v.name, err)
+	}
+
+	return nil
+}
+
+// ReadData reads the `(ptr, len)` pair at the start of the slot and returns the segment it
+// points to.
+func (v *AscReturnValue) ReadData(memory MemoryAccess) ([]byte, error) {
+	ptr, err := memory.ReadI32(v.ptr)
+	if err != nil {
+		return nil, fmt.Errorf("getting [%s] return value pointer: %w", v.name, err)
+
+	}
+	length, err := memory.ReadI32(v.ptr + 4)
+	if err != nil {
+		return nil, fmt.Errorf("getting [%s] return value length: %w", v.name, err)
+	}
+
+	return memory.ReadBytes(ptr, length)
+}
+
+type bytesOutput struct {
+	asString bool
+}
+
+func (o bytesOutput) SlotSize() int32 {
+	return 8
+}
+
+func (o bytesOutput) Decode(slot []byte, read MemoryReader) (interface{}, error) {
+	data, err := read(int32(encoding.Uint32(slot)), int32(encoding.Uint32(slot[4:])))
+	if err != nil {
+		return nil, err
+	}
+
+	if o.asString {
+		return string(data), nil
+	}
+	return append([]byte(nil), data...), nil
+}
+
+type nullableBytesOutput struct{}
+
+func (o nullableBytesOutput) SlotSize() int32 {
+	return 12
+}
+
+func (o nullableBytesOutput) Decode(slot []byte, read MemoryReader) (interface{}, error) {
+	if encoding.Uint32(slot) == 0 {
+		return []byte(nil), nil
+	}
+
+	return bytesOutput{}.Decode(slot[4:], read)
+}
+
+type reprCOutput struct {
+	typ  reflect.Type
+	size int32
+}
+
+func newReprCOutput(prototype interface{}) (reprCOutput, error) {
+	typ := reflect.TypeOf(prototype)
+	if typ == nil {
+		return reprCOutput{}, fmt.Errorf("no prototype given")
+	}
+
+	size, _, err := reprCLayout(typ)
+	if err != nil {
+		return reprCOutput{}, fmt.Errorf("type %T: %w", prototype, err)
+	}
+
+	return reprCOutput{typ, size}, nil
+}
+
+func (o reprCOutput) SlotSize() int32 {
+	return o.size
+}
+
+func (o reprCOutput) Decode(slot []byte, read MemoryReader) (interface{}, error) {
+	rv := reflect.New(o.typ).Elem()
+	if err := decodeReprC(slot, rv, read); err != nil {
+		return nil, err
+	}
+
+	return rv.Interface(), nil
+}
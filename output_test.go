@@ -0,0 +1,82 @@
+package wasm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const outputTestModule = `
+(module
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "hello")
+
+  (func (export "outputs") (param $bytes i32) (param $counter i32) (param $none i32) (param $some i32) (param $struct i32) (param $wide i32)
+    (i32.store (local.get $bytes) (i32.const 1024))
+    (i32.store offset=4 (local.get $bytes) (i32.const 5))
+
+    (i64.store (local.get $counter) (i64.const 0x100000000))
+
+    (i32.store (local.get $some) (i32.const 1))
+    (i32.store offset=4 (local.get $some) (i32.const 1025))
+    (i32.store offset=8 (local.get $some) (i32.const 2))
+
+    (i32.store8 (local.get $struct) (i32.const 1))
+    (i32.store offset=4 (local.get $struct) (i32.const -3))
+
+    (i32.store (local.get $wide) (i32.const 1024))
+    (i32.store offset=4 (local.get $wide) (i32.const 4))
+    (i32.store offset=12 (local.get $wide) (i32.const 0xFFFF))))
+`
+
+type outputTestStruct struct {
+	Ok   bool
+	Code int32
+}
+
+func TestRuntime_Outputs(t *testing.T) {
+	wasmFile := writeTestModule(t, outputTestModule)
+
+	bytes := NewAscReturnValue("bytes")
+	counter := NewScalarOutput("counter", uint64(0))
+	none := NewNullableBytesOutput("none")
+	some := NewNullableBytesOutput("some")
+	structure := NewStructOutput("struct", outputTestStruct{})
+	wide := NewStringOutput("wide").WithSlotSize(16)
+
+	runtime := NewRuntime(&RustEnvironment{}, WithParameterPointSize())
+	_, err := runtime.Execute(wasmFile, "outputs", nil, bytes, counter, none, some, structure, wide)
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte("hello"), bytes.Value())
+	assert.Equal(t, uint64(0x100000000), counter.Value())
+	assert.Equal(t, []byte(nil), none.Value())
+	assert.Equal(t, []byte("el"), some.Value())
+	assert.Equal(t, outputTestStruct{Ok: true, Code: -3}, structure.Value())
+	assert.Equal(t, "hell", wide.Value())
+}
+
+func TestRuntime_InvalidOutputs(t *testing.T) {
+	wasmFile := writeTestModule(t, outputTestModule)
+	runtime := NewRuntime(&RustEnvironment{}, WithParameterPointSize())
+
+	tests := []struct {
+		output   *AscReturnValue
+		expected string
+	}{
+		{NewScalarOutput("scalar", map[string]int{}), `invalid output "scalar": type map[string]int: `},
+		{NewStructOutput("struct", nil), `invalid output "struct": no prototype given`},
+		{NewAscReturnValue("bytes").WithSlotSize(4), `invalid output "bytes": slot size 4 is smaller than the 8 bytes required by its decoder`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.output.Name(), func(t *testing.T) {
+			_, err := runtime.Execute(wasmFile, "outputs", nil, test.output)
+			var outputErr *OutputError
+			require.True(t, errors.As(err, &outputErr), "expected an OutputError, got %v", err)
+			assert.Contains(t, err.Error(), test.expected)
+		})
+	}
+}
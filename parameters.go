@@ -0,0 +1,306 @@
+package wasm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// ParameterLayout controls how a parameter is expanded into wasm values.
+type ParameterLayout int
+
+const (
+	// LayoutDefault passes strings and bytes as a pointer, followed by their length when the
+	// runtime was created WithParameterPointSize, and scalars by value.
+	LayoutDefault ParameterLayout = iota
+
+	// LayoutPointer passes strings and bytes as a pointer only.
+	LayoutPointer
+
+	// LayoutPointerWithLength passes strings and bytes as a pointer followed by their length.
+	LayoutPointerWithLength
+
+	// LayoutByValue passes scalars as is and flattens structs and arrays into one wasm
+	// value per (scalar) field or element.
+	LayoutByValue
+)
+
+func (l ParameterLayout) String() string {
+	switch l {
+	case LayoutDefault:
+		return "default"
+	case LayoutPointer:
+		return "pointer"
+	case LayoutPointerWithLength:
+		return "pointer+length"
+	case LayoutByValue:
+		return "by value"
+	}
+	return fmt.Sprintf("ParameterLayout(%d)", int(l))
+}
+
+// Parameter overrides the layout of a single parameter given to Execute.
+type Parameter struct {
+	Value  interface{}
+	Layout ParameterLayout
+}
+
+func PointerOnly(value interface{}) Parameter {
+	return Parameter{value, LayoutPointer}
+}
+
+func PointerWithLength(value interface{}) Parameter {
+	return Parameter{value, LayoutPointerWithLength}
+}
+
+func ByValue(value interface{}) Parameter {
+	return Parameter{value, LayoutByValue}
+}
+
+// ParameterError is returned when the parameters given to Execute do not match the
+// signature of the called function once converted to wasm values.
+type ParameterError struct {
+	Function string
+	Expected []wasmer.ValueKind
+	Provided []string
+}
+
+func (e *ParameterError) Error() string {
+	expected := make([]string, len(e.Expected))
+	for i, kind := range e.Expected {
+		expected[i] = kind.String()
+	}
+
+	return fmt.Sprintf("parameters mismatch for %s, expected (%s) but provided (%s)", e.Function, strings.Join(expected, ", "), strings.Join(e.Provided, ", "))
+}
+
+// validateParameters checks `values` against the parameters of `function`, converting
+// unsigned values to the signed equivalent wasmer accepts.
+func validateParameters(name string, function *wasmer.Function, values []interface{}) error {
+	expected := function.Type().Params()
+
+	mismatch := len(values) != len(expected)
+	provided := make([]string, len(values))
+	for i, value := range values {
+		kind, converted, ok := wasmKindOf(value)
+		if !ok {
+			provided[i] = fmt.Sprintf("%T", value)
+			mismatch = true
+			continue
+		}
+
+		values[i] = converted
+		provided[i] = kind.String()
+		if i < len(expected) && expected[i].Kind() != kind {
+			mismatch = true
+		}
+	}
+
+	if !mismatch {
+		return nil
+	}
+
+	kinds := make([]wasmer.ValueKind, len(expected))
+	for i, param := range expected {
+		kinds[i] = param.Kind()
+	}
+
+	return &ParameterError{
+		Function: namedFunctionDefinition{name, function}.String(),
+		Expected: kinds,
+		Provided: provided,
+	}
+}
+
+func wasmKindOf(value interface{}) (wasmer.ValueKind, interface{}, bool) {
+	switch v := value.(type) {
+	case int32:
+		return wasmer.I32, v, true
+	case uint32:
+		return wasmer.I32, int32(v), true
+	case int64:
+		return wasmer.I64, v, true
+	case uint64:
+		return wasmer.I64, int64(v), true
+	case float32:
+		return wasmer.F32, v, true
+	case float64:
+		return wasmer.F64, v, true
+	}
+
+	return 0, nil, false
+}
+
+func toWASMParameters(heap *AscHeap, parameters []interface{}, withSize bool, codec Codec) (out []interface{}, err error) {
+	for i, parameter := range parameters {
+		layout := LayoutDefault
+		if p, ok := parameter.(Parameter); ok {
+			parameter, layout = p.Value, p.Layout
+		}
+
+		values, err := toWASMParameter(heap, parameter, layout, withSize, codec)
+		if err != nil {
+			return nil, fmt.Errorf("convert parameter #%d: %w", i, err)
+		}
+
+		if ztracer.Enabled() {
+			heap.logger.Debug("converted parameter to wasm values", zap.Stringer("original", typedField{parameter}), zap.Stringer("layout", layout), zap.Int("count", len(values)))
+		}
+
+		out = append(out, values...)
+	}
+
+	return
+}
+
+func toWASMParameter(heap *AscHeap, parameter interface{}, layout ParameterLayout, withSize bool, codec Codec) ([]interface{}, error) {
+	if message, ok := parameter.(proto.Message); ok {
+		parameter = protoParameter{message}
+	} else if codec != nil && layout != LayoutByValue && needsCodec(parameter) {
+		parameter = Encode(codec, parameter)
+	}
+
+	if marshaler, ok := parameter.(Marshaler); ok {
+		if layout != LayoutDefault {
+			return nil, fmt.Errorf("layout %s cannot be applied to Marshaler %T", layout, parameter)
+		}
+
+		values, err := marshaler.MarshalWASM(heap)
+		if err != nil {
+			return nil, fmt.Errorf("marshal %T: %w", parameter, err)
+		}
+		return values, nil
+	}
+
+	if layout == LayoutByValue {
+		return flattenByValue(reflect.ValueOf(parameter))
+	}
+
+	wasmValue, err := toWASMValue(parameter)
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := wasmValue.(AscPtr)
+	if !ok {
+		if layout != LayoutDefault {
+			return nil, fmt.Errorf("layout %s cannot be applied to %T", layout, parameter)
+		}
+		return []interface{}{wasmValue}, nil
+	}
+
+	ptr, size, err := v.ToPtr(heap)
+	if err != nil {
+		return nil, fmt.Errorf("write %T: %w", parameter, err)
+	}
+	if layout == LayoutPointerWithLength || (layout == LayoutDefault && withSize) {
+		return []interface{}{ptr, size}, nil
+	}
+	return []interface{}{ptr}, nil
+}
+
+func flattenByValue(rv reflect.Value) (out []interface{}, err error) {
+	switch rv.Kind() {
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			if rv.Type().Field(i).PkgPath != "" {
+				continue
+			}
+
+			values, err := flattenByValue(rv.Field(i))
+			if err != nil {
+				return nil, fmt.Errorf("field %s.%s: %w", rv.Type(), rv.Type().Field(i).Name, err)
+			}
+			out = append(out, values...)
+		}
+		return out, nil
+
+	case reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			values, err := flattenByValue(rv.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			out = append(out, values...)
+		}
+		return out, nil
+
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface, reflect.Invalid:
+		return nil, fmt.Errorf("type %s cannot be passed by value", typeName(rv))
+	}
+
+	value, err := toWASMValue(rv.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{value}, nil
+}
+
+func typeName(rv reflect.Value) string {
+	if !rv.IsValid() {
+		return "nil"
+	}
+	return rv.Type().String()
+}
+
+func toWASMValue(in interface{}) (interface{}, error) {
+	switch v := in.(type) {
+	case bool:
+		if v == true {
+			return int32(1), nil
+		}
+		return int32(0), nil
+	case int8:
+		return int32(v), nil
+	case uint8:
+		return int32(v), nil
+	case int16:
+		return int32(v), nil
+	case uint16:
+		return int32(v), nil
+	case int32:
+		return int32(v), nil
+	case uint32:
+		return int32(v), nil
+	case int64:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case int:
+		// The WASM spec differentiates between int32 vs int64 depending on WASM32 or WASM64, but I assume we are always in the context of WASM32 here
+		return int32(v), nil
+	case uint:
+		// The WASM spec differentiates between int32 vs int64 depending on WASM32 or WASM64, but I assume we are always in the context of WASM32 here
+		return int32(v), nil
+	case float32, float64:
+		return v, nil
+
+	case []byte:
+		return AscBytes(v), nil
+	case string:
+		return AscString(v), nil
+	case AscPtr:
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("unhandled type %T to WASM, implement the Marshaler interface to pass it", in)
+}
+
+// needsCodec returns whether `in` has no native wasm representation and must be
+// serialized to be passed to the module.
+func needsCodec(in interface{}) bool {
+	switch in.(type) {
+	case Marshaler, AscPtr, []byte:
+		return false
+	}
+
+	switch reflect.ValueOf(in).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Ptr:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package wasm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+const parametersTestModule = `
+(module
+  (memory (export "memory") 1)
+
+  (func (export "sum") (param $ptr i32) (param $len i32) (param $a i32) (param $b i64) (param $c f32) (result i64)
+    (i64.add
+      (i64.add (i64.extend_i32_u (local.get $len)) (i64.extend_i32_u (local.get $a)))
+      (i64.add (local.get $b) (i64.trunc_f32_s (local.get $c)))))
+
+  (func (export "first") (param $ptr i32) (result i32)
+    (i32.load8_u (local.get $ptr))))
+`
+
+type parametersTestPoint struct {
+	X uint32
+	Y uint64
+	Z float32
+}
+
+func TestRuntime_ParameterLayout(t *testing.T) {
+	wasmFile := writeTestModule(t, parametersTestModule)
+	runtime := NewRuntime(&RustEnvironment{})
+
+	actual, err := runtime.Execute(wasmFile, "sum", []interface{}{PointerWithLength("abc"), uint32(1), uint64(10), float32(100)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(114), actual.Value)
+
+	actual, err = runtime.Execute(wasmFile, "sum", []interface{}{PointerWithLength([]byte{1}), ByValue(parametersTestPoint{2, 20, 200})})
+	require.NoError(t, err)
+	assert.Equal(t, int64(223), actual.Value)
+
+	actual, err = NewRuntime(&RustEnvironment{}, WithParameterPointSize()).Execute(wasmFile, "first", []interface{}{PointerOnly([]byte{7})})
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), actual.Value)
+}
+
+func TestRuntime_ParameterError(t *testing.T) {
+	wasmFile := writeTestModule(t, parametersTestModule)
+	runtime := NewRuntime(&RustEnvironment{})
+
+	_, err := runtime.Execute(wasmFile, "sum", []interface{}{"abc", int64(1)})
+	require.Error(t, err)
+
+	var parameterErr *ParameterError
+	require.True(t, errors.As(err, &parameterErr))
+	assert.Equal(t, []wasmer.ValueKind{wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I64, wasmer.F32}, parameterErr.Expected)
+	assert.Equal(t, []string{"i32", "i64"}, parameterErr.Provided)
+	assert.Equal(t, "parameters mismatch for sum(i32, i32, i32, i64, f32) (i64), expected (i32, i32, i32, i64, f32) but provided (i32, i64)", parameterErr.Error())
+
+	_, err = runtime.Execute(wasmFile, "first", []interface{}{ByValue("abc")})
+	assert.EqualError(t, err, `unable to execute wasm module function "first" from "`+wasmFile+`": convert parameter #0: type string cannot be passed by value`)
+}
+
+func TestRuntime_ParameterAllocationError(t *testing.T) {
+	wasmFile := writeTestModule(t, `
+(module
+  (memory (export "memory") 1 1)
+  (func (export "first") (param $ptr i32) (result i32)
+    (i32.load8_u (local.get $ptr))))
+`)
+
+	_, err := NewRuntime(&RustEnvironment{}).Execute(wasmFile, "first", []interface{}{PointerOnly(make([]byte, 2*wasmer.WasmPageSize))})
+	assert.EqualError(t, err, `unable to execute wasm module function "first" from "`+wasmFile+`": convert parameter #0: write []uint8: couldn't grow memory`)
+
+	_, err = NewRuntime(&RustEnvironment{}).Execute(wasmFile, "first", nil, NewAscReturnValue("out").WithSlotSize(2*65536))
+	assert.EqualError(t, err, `unable to execute wasm module function "first" from "`+wasmFile+`": allocate output "out": couldn't grow memory`)
+}
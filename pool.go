@@ -0,0 +1,116 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// InstancePool keeps instances of a module ready to be called, each starting from the state
+// the module had once initialized: instances are snapshotted when created and reset when
+// given back to the pool.
+type InstancePool struct {
+	runtime  *Runtime
+	wasmFile string
+	name     string
+	maxIdle  int
+
+	lock   sync.Mutex
+	idle   []*Instance
+	closed bool
+}
+
+// NewInstancePool creates a pool of instances of the module found in `wasmFile`, keeping at
+// most `maxIdle` of them when they are not in use.
+func (r *Runtime) NewInstancePool(wasmFile string, maxIdle int) *InstancePool {
+	return &InstancePool{
+		runtime:  r,
+		wasmFile: wasmFile,
+		name:     moduleName(wasmFile),
+		maxIdle:  maxIdle,
+	}
+}
+
+// Get returns an idle instance of the pool, instantiating a new one if there is none. The
+// instance must be given back through Put once done with it.
+func (p *InstancePool) Get() (*Instance, error) {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil, errors.New("instance pool is closed")
+	}
+
+	if count := len(p.idle); count > 0 {
+		instance := p.idle[count-1]
+		p.idle = p.idle[:count-1]
+		p.lock.Unlock()
+
+		p.runtime.metrics.poolTaken(p.name, true)
+		return instance, nil
+	}
+	p.lock.Unlock()
+
+	instance, err := p.runtime.Instantiate(p.wasmFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := instance.Snapshot(); err != nil {
+		instance.Close()
+		return nil, fmt.Errorf("unable to snapshot instance of %q: %w", p.wasmFile, err)
+	}
+
+	p.runtime.metrics.poolTaken(p.name, false)
+	return instance, nil
+}
+
+// Put resets `instance` and gives it back to the pool, closing it if it cannot be reset or if
+// the pool has enough idle instances.
+func (p *InstancePool) Put(instance *Instance) {
+	if err := instance.Reset(); err != nil {
+		p.runtime.logger.Warn("unable to reset instance, discarding it", zap.String("wasm_file", p.wasmFile), zap.Error(err))
+		instance.Close()
+		p.runtime.metrics.poolGivenBack(p.name, false)
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed || len(p.idle) >= p.maxIdle {
+		instance.Close()
+		p.runtime.metrics.poolGivenBack(p.name, false)
+		return
+	}
+
+	p.idle = append(p.idle, instance)
+	p.runtime.metrics.poolGivenBack(p.name, true)
+}
+
+// Execute calls `functionName` on an instance of the pool, see Instance.ExecuteContext.
+func (p *InstancePool) Execute(ctx context.Context, functionName string, parameters []interface{}, returns ...*AscReturnValue) (*ExecutionResult, error) {
+	instance, err := p.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer p.Put(instance)
+
+	return instance.ExecuteContext(ctx, functionName, parameters, returns...)
+}
+
+// Close closes the idle instances of the pool, the ones in use are closed when given back.
+func (p *InstancePool) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, instance := range p.idle {
+		instance.Close()
+	}
+	p.runtime.metrics.poolClosed(p.name, len(p.idle))
+
+	p.idle = nil
+	p.closed = true
+}
@@ -0,0 +1,226 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// preinitSegmentGap is the length of the shortest run of zero bytes splitting the memory in
+// two data segments, shorter runs are kept within the segment as encoding a segment costs
+// about as much.
+const preinitSegmentGap = 16
+
+// globalExport exports the mutable globals defined by the modules, see instrumentGlobals.
+const (
+	globalExportPrefix = "__wasm_runtime_global_"
+	globalExport       = globalExportPrefix + "%d"
+)
+
+// PreInitialize instantiates the module found in `wasmFile`, runs its `initFunction` export
+// and returns a new module binary whose data segments and globals hold the state the module
+// was left in, so that its instances start initialized. The start function, the reactor
+// initializer and `initFunction` already ran: the start section is dropped and both exports
+// are removed from the new module.
+//
+// The module must define a single memory. Only the memory and the globals defined by the
+// module are captured: initialization functions must not rely on tables modifications or on
+// state kept by the host.
+func (r *Runtime) PreInitialize(wasmFile string, initFunction string) ([]byte, error) {
+	wasmBytes, err := ioutil.ReadFile(wasmFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load wasm file %q: %w", wasmFile, err)
+	}
+
+	module, err := parseWASMModule(wasmBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse wasm file %q: %w", wasmFile, err)
+	}
+
+	out, err := r.preInitialize(wasmFile, module, initFunction)
+	if err != nil {
+		return nil, fmt.Errorf("unable to pre-initialize wasm file %q: %w", wasmFile, err)
+	}
+
+	return out, nil
+}
+
+func (r *Runtime) preInitialize(wasmFile string, module *wasmModule, initFunction string) ([]byte, error) {
+	imports, err := module.imports()
+	if err != nil {
+		return nil, fmt.Errorf("import section: %w", err)
+	}
+
+	importedGlobals := uint32(0)
+	for _, imported := range imports {
+		switch imported.kind {
+		case externMemory:
+			return nil, fmt.Errorf("imported memory %s.%s is not supported", imported.module, imported.name)
+		case externGlobal:
+			importedGlobals++
+		}
+	}
+
+	memories, err := module.memories()
+	if err != nil {
+		return nil, fmt.Errorf("memory section: %w", err)
+	}
+	if len(memories) != 1 {
+		return nil, fmt.Errorf("module must define a single memory, it defines %d", len(memories))
+	}
+
+	globals, err := module.globals()
+	if err != nil {
+		return nil, fmt.Errorf("global section: %w", err)
+	}
+
+	exports, err := module.exports()
+	if err != nil {
+		return nil, fmt.Errorf("export section: %w", err)
+	}
+
+	segments, err := module.dataSegments()
+	if err != nil {
+		return nil, fmt.Errorf("data section: %w", err)
+	}
+
+	instance, err := r.instantiate(context.Background(), wasmFile, module.encode())
+	if err != nil {
+		return nil, err
+	}
+	defer instance.Close()
+
+	if initFunction != "" && initFunction != reactorInitializer {
+		if _, err := instance.Execute(initFunction, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, global := range globals {
+		if !global.mutable {
+			continue
+		}
+
+		value, err := readGlobal(instance, fmt.Sprintf(globalExport, i))
+		if err != nil {
+			return nil, fmt.Errorf("global %d: %w", importedGlobals+uint32(i), err)
+		}
+
+		if globals[i].init, err = constExprOf(global.valueType, value); err != nil {
+			return nil, fmt.Errorf("global %d: %w", importedGlobals+uint32(i), err)
+		}
+	}
+
+	memory := instance.memory.Data()
+	pages := instance.memory.Size()
+	memories[0].min = pages.ToUint32()
+
+	kept := exports[:0]
+	for _, export := range exports {
+		if export.kind == externFunction && (export.name == initFunction || export.name == reactorInitializer) {
+			continue
+		}
+		kept = append(kept, export)
+	}
+
+	segments = append(clearActiveSegments(segments), dataSegmentsOf(memory)...)
+
+	module.setSection(sectionMemory, encodeMemories(memories))
+	if len(globals) > 0 {
+		module.setSection(sectionGlobal, encodeGlobals(globals))
+	}
+	module.setSection(sectionExport, encodeExports(kept))
+	module.removeSection(sectionStart)
+	if module.section(sectionDataCount) != nil {
+		module.setSection(sectionDataCount, appendU32(nil, uint32(len(segments))))
+	}
+	module.setSection(sectionData, encodeDataSegments(segments))
+
+	return module.encode(), nil
+}
+
+// instrumentGlobals returns `exports` along with an export of each mutable global defined
+// by the module, wasmer giving access to exported globals only. Every module is instrumented
+// at instantiation so that pre-initialization and snapshots reach all of its globals.
+func instrumentGlobals(exports []wasmExport, globals []wasmGlobal, importedGlobals uint32) []wasmExport {
+	instrumented := append([]wasmExport(nil), exports...)
+	for i, global := range globals {
+		if global.mutable {
+			instrumented = append(instrumented, wasmExport{fmt.Sprintf(globalExport, i), externGlobal, importedGlobals + uint32(i)})
+		}
+	}
+
+	return instrumented
+}
+
+func readGlobal(instance *Instance, name string) (interface{}, error) {
+	global, err := instance.instance.Exports.GetGlobal(name)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := global.Get()
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, errors.New("reference globals are not supported")
+	}
+
+	return value, nil
+}
+
+// clearActiveSegments empties the active segments of `segments`, the memory they initialize
+// being captured as a whole. They are removed unless passive segments are present, in which
+// case they are kept so that the passive segments indexes do not change.
+func clearActiveSegments(segments []wasmDataSegment) []wasmDataSegment {
+	hasPassive := false
+	for _, segment := range segments {
+		hasPassive = hasPassive || segment.passive
+	}
+
+	if !hasPassive {
+		return nil
+	}
+
+	cleared := make([]wasmDataSegment, len(segments))
+	for i, segment := range segments {
+		if !segment.passive {
+			segment = wasmDataSegment{memory: segment.memory, offset: []byte{opI32Const, 0x00, opEnd}}
+		}
+		cleared[i] = segment
+	}
+	return cleared
+}
+
+// dataSegmentsOf returns the active segments initializing the non-zero bytes of `memory`.
+func dataSegmentsOf(memory []byte) []wasmDataSegment {
+	var segments []wasmDataSegment
+
+	for start := 0; start < len(memory); {
+		if memory[start] == 0 {
+			start++
+			continue
+		}
+
+		end, zeros := start+1, 0
+		for next := end; next < len(memory) && zeros < preinitSegmentGap; next++ {
+			if memory[next] == 0 {
+				zeros++
+				continue
+			}
+
+			end, zeros = next+1, 0
+		}
+
+		offset := appendS64([]byte{opI32Const}, int64(int32(uint32(start))))
+		segments = append(segments, wasmDataSegment{
+			offset: append(offset, opEnd),
+			data:   memory[start:end],
+		})
+		start = end
+	}
+
+	return segments
+}
@@ -0,0 +1,138 @@
+package wasm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+const preinitTestModule = `
+(module
+  (memory (export "memory") 1)
+  (global $ready (mut i32) (i32.const 0))
+  (global $seed (export "seed") (mut i64) (i64.const 1))
+  (global $scale f32 (f32.const 1.5))
+  (data (i32.const 16) "hello")
+  (data $passive "world")
+
+  (func $start
+    (i32.store (i32.const 3000) (i32.add (i32.load (i32.const 3000)) (i32.const 1))))
+  (start $start)
+
+  (func (export "init")
+    (local $i i32)
+    (loop $fill
+      (i32.store (i32.add (i32.const 1024) (i32.shl (local.get $i) (i32.const 2))) (i32.mul (local.get $i) (local.get $i)))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br_if $fill (i32.lt_u (local.get $i) (i32.const 256))))
+    (drop (memory.grow (i32.const 1)))
+    (i32.store8 (i32.const 70000) (i32.const 9))
+    (global.set $ready (i32.const 1))
+    (global.set $seed (i64.const -42)))
+
+  (func (export "lookup") (param $i i32) (result i32)
+    (if (i32.eqz (global.get $ready)) (then (return (i32.const -1))))
+    (i32.load (i32.add (i32.const 1024) (i32.shl (local.get $i) (i32.const 2)))))
+
+  (func (export "peek") (param $ptr i32) (result i32)
+    (i32.load8_u (local.get $ptr)))
+
+  (func (export "started") (result i32)
+    (i32.load (i32.const 3000)))
+
+  (func (export "copy_passive") (result i32)
+    (memory.init $passive (i32.const 2048) (i32.const 0) (i32.const 5))
+    (i32.load8_u (i32.const 2048))))
+`
+
+func TestRuntime_PreInitialize(t *testing.T) {
+	runtime := NewRuntime(&RustEnvironment{})
+
+	out, err := runtime.PreInitialize(writeTestModule(t, preinitTestModule), "init")
+	require.NoError(t, err)
+
+	wasmFile := filepath.Join(t.TempDir(), "module.wasm")
+	require.NoError(t, os.WriteFile(wasmFile, out, 0644))
+
+	instance, err := runtime.Instantiate(wasmFile)
+	require.NoError(t, err)
+	defer instance.Close()
+
+	execute := func(function string, parameters ...interface{}) interface{} {
+		t.Helper()
+
+		actual, err := instance.Execute(function, parameters)
+		require.NoError(t, err)
+		return actual.Value
+	}
+
+	assert.Equal(t, int32(225), execute("lookup", int32(15)))
+	assert.Equal(t, int32(255*255), execute("lookup", int32(255)))
+	assert.Equal(t, int32('h'), execute("peek", int32(16)))
+	assert.Equal(t, int32(9), execute("peek", int32(70000)))
+	assert.Equal(t, int32(1), execute("started"))
+	assert.Equal(t, int32('w'), execute("copy_passive"))
+	assert.Len(t, instance.memory.Data(), 2*65536)
+
+	seed, err := instance.instance.Exports.GetGlobal("seed")
+	require.NoError(t, err)
+	value, err := seed.Get()
+	require.NoError(t, err)
+	assert.Equal(t, int64(-42), value)
+
+	_, err = instance.Execute("init", nil)
+	assert.Error(t, err)
+
+	for _, export := range instance.module.Exports() {
+		assert.NotContains(t, export.Name(), "__wasm_runtime_preinit")
+	}
+}
+
+func TestDataSegmentsOf(t *testing.T) {
+	memory := make([]byte, 256)
+	memory[1] = 1
+	memory[3] = 3
+	memory[100] = 100
+	memory[255] = 255
+
+	segments := dataSegmentsOf(memory)
+	require.Len(t, segments, 3)
+	assert.Equal(t, []byte{1, 0, 3}, segments[0].data)
+	assert.Equal(t, []byte{opI32Const, 1, opEnd}, segments[0].offset)
+	assert.Equal(t, []byte{100}, segments[1].data)
+	assert.Equal(t, []byte{opI32Const, 0xe4, 0x00, opEnd}, segments[1].offset)
+	assert.Equal(t, []byte{255}, segments[2].data)
+}
+
+func TestWASMModule_RoundTrip(t *testing.T) {
+	wasmBytes, err := wasmer.Wat2Wasm(preinitTestModule)
+	require.NoError(t, err)
+
+	module, err := parseWASMModule(wasmBytes)
+	require.NoError(t, err)
+
+	globals, err := module.globals()
+	require.NoError(t, err)
+	require.Len(t, globals, 3)
+	assert.Equal(t, wasmGlobal{valueF32, false, []byte{opF32Const, 0x00, 0x00, 0xc0, 0x3f, opEnd}}, globals[2])
+
+	segments, err := module.dataSegments()
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	assert.Equal(t, []byte("hello"), segments[0].data)
+	assert.True(t, segments[1].passive)
+
+	module.setSection(sectionGlobal, encodeGlobals(globals))
+	module.setSection(sectionData, encodeDataSegments(segments))
+	assert.Equal(t, wasmBytes, module.encode())
+
+	for _, value := range []int64{0, 1, -1, 63, 64, -64, -65, 1 << 40, -1 << 63} {
+		decoded, err := newWASMReader(appendS64(nil, value)).sleb()
+		require.NoError(t, err)
+		assert.Equal(t, value, decoded)
+	}
+}
@@ -0,0 +1,261 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// hostFrameFile is the file name of the frames of host functions in the profiles.
+const hostFrameFile = "<host>"
+
+// Profiler attributes the time spent in the calls made by the runtimes it is given to (see
+// WithProfiler) to the guest functions, named from the `name` section of the modules, and to
+// the host functions they call, then writes it as a pprof profile that `go tool pprof` reads.
+//
+// Profiling does not sample: the modules are instrumented when instantiated to notify the
+// profiler on entry and exit of each of their functions, recording every call. Each of these
+// notifications crosses the boundary between the guest and the host, which slows down calls
+// into small functions much more than bigger ones: the profiles are best read relatively to
+// each other rather than for their absolute durations.
+type Profiler struct {
+	lock      sync.Mutex
+	functions []profiledFunction
+	ids       map[profiledFunction]int
+	samples   map[string]*profileSample
+	started   time.Time
+}
+
+type profiledFunction struct {
+	file string
+	name string
+}
+
+type profileSample struct {
+	// stack is the list of function ids of the sample, outermost first.
+	stack    []int
+	calls    int64
+	duration time.Duration
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		ids:     map[profiledFunction]int{},
+		samples: map[string]*profileSample{},
+		started: time.Now(),
+	}
+}
+
+// WithProfiler profiles the calls made by the runtime in `profiler`, which can be shared by
+// several runtimes. Only the modules instantiated once the option is set are profiled.
+func WithProfiler(profiler *Profiler) RuntimeOption {
+	return func(r *Runtime) {
+		r.profiler = profiler
+	}
+}
+
+// Profile returns the profile of the calls recorded since the profiler was created or last
+// reset, with a sample per call stack holding the number of calls to its innermost frame
+// and the time spent in the frame itself, its callees excluded.
+func (p *Profiler) Profile() *profile.Profile {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	out := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "calls", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		DefaultSampleType: "cpu",
+		PeriodType:        &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:            1,
+		TimeNanos:         p.started.UnixNano(),
+		DurationNanos:     int64(time.Since(p.started)),
+	}
+
+	locations := make([]*profile.Location, len(p.functions))
+	for id, function := range p.functions {
+		outFunction := &profile.Function{
+			ID:         uint64(id + 1),
+			Name:       function.name,
+			SystemName: function.name,
+			Filename:   function.file,
+		}
+		locations[id] = &profile.Location{ID: uint64(id + 1), Line: []profile.Line{{Function: outFunction}}}
+
+		out.Function = append(out.Function, outFunction)
+		out.Location = append(out.Location, locations[id])
+	}
+
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		sample := p.samples[key]
+
+		outSample := &profile.Sample{Value: []int64{sample.calls, int64(sample.duration)}}
+		for i := len(sample.stack) - 1; i >= 0; i-- {
+			outSample.Location = append(outSample.Location, locations[sample.stack[i]])
+		}
+		out.Sample = append(out.Sample, outSample)
+	}
+
+	return out
+}
+
+// Write writes the gzipped protobuf encoding of Profile to `w`.
+func (p *Profiler) Write(w io.Writer) error {
+	return p.Profile().Write(w)
+}
+
+// Reset drops the calls recorded so far.
+func (p *Profiler) Reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.samples = map[string]*profileSample{}
+	p.started = time.Now()
+}
+
+// functionIDs returns the ids of the functions `names` of the module `module`.
+func (p *Profiler) functionIDs(module string, names []string) []int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	ids := make([]int, len(names))
+	for i, name := range names {
+		ids[i] = p.functionID(profiledFunction{module, name})
+	}
+	return ids
+}
+
+func (p *Profiler) hostFunctionID(name string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.functionID(profiledFunction{hostFrameFile, name})
+}
+
+func (p *Profiler) functionID(function profiledFunction) int {
+	id, found := p.ids[function]
+	if !found {
+		id = len(p.functions)
+		p.functions = append(p.functions, function)
+		p.ids[function] = id
+	}
+	return id
+}
+
+func (p *Profiler) add(samples map[string]*profileSample) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for key, sample := range samples {
+		existing, found := p.samples[key]
+		if !found {
+			p.samples[key] = sample
+			continue
+		}
+
+		existing.calls += sample.calls
+		existing.duration += sample.duration
+	}
+}
+
+// registerImports registers the hooks called by the modules instrumented for profiling, the
+// module functions being identified by `ids`.
+func (p *Profiler) registerImports(importObject *wasmer.ImportObject, ids []int, host *hostContext, store *wasmer.Store) {
+	hookType := wasmer.NewFunctionType(params(wasmer.I32), returns())
+	hook := func(exit bool) *wasmer.Function {
+		return wasmer.NewFunctionWithEnvironment(store, hookType, host, func(env interface{}, args []wasmer.Value) ([]wasmer.Value, error) {
+			call := env.(*hostContext).invocation
+			if call == nil {
+				// The start function runs while instantiating, out of any invocation
+				return nil, nil
+			}
+
+			if exit {
+				call.profile.exit()
+			} else {
+				call.profile.enter(ids[args[0].I32()])
+			}
+			return nil, nil
+		})
+	}
+
+	importObject.Register(profilerModule, map[string]wasmer.IntoExtern{
+		profilerEnter: hook(false),
+		profilerExit:  hook(true),
+	})
+}
+
+// callProfile records the call stacks of an invocation, the time elapsed since `mark` being
+// spent in the innermost frame of `stack`.
+type callProfile struct {
+	profiler *Profiler
+	stack    []int
+	mark     time.Time
+	samples  map[string]*profileSample
+	key      []byte
+}
+
+func newCallProfile(profiler *Profiler) *callProfile {
+	return &callProfile{profiler: profiler, samples: map[string]*profileSample{}}
+}
+
+func (p *callProfile) enter(function int) {
+	p.charge()
+	p.stack = append(p.stack, function)
+	p.sample().calls++
+	p.mark = time.Now()
+}
+
+func (p *callProfile) enterHost(name string) {
+	p.enter(p.profiler.hostFunctionID(name))
+}
+
+func (p *callProfile) exit() {
+	p.charge()
+	if len(p.stack) > 0 {
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+	p.mark = time.Now()
+}
+
+// finish closes the frames left open by a trap and hands the samples to the profiler.
+func (p *callProfile) finish() {
+	p.charge()
+	p.stack = nil
+	p.profiler.add(p.samples)
+}
+
+func (p *callProfile) charge() {
+	if len(p.stack) > 0 {
+		p.sample().duration += time.Since(p.mark)
+	}
+}
+
+func (p *callProfile) sample() *profileSample {
+	var buffer [binary.MaxVarintLen64]byte
+
+	p.key = p.key[:0]
+	for _, id := range p.stack {
+		length := binary.PutUvarint(buffer[:], uint64(id))
+		p.key = append(p.key, buffer[:length]...)
+	}
+
+	sample, found := p.samples[string(p.key)]
+	if !found {
+		sample = &profileSample{stack: append([]int(nil), p.stack...)}
+		p.samples[string(p.key)] = sample
+	}
+	return sample
+}
@@ -0,0 +1,524 @@
+package wasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The profiled modules import the `enter` and `exit` functions of profilerModule, called with
+// the index the function has in the original module on entry and exit of each function.
+const (
+	profilerModule = "wasm_runtime_profiler"
+	profilerEnter  = "enter"
+	profilerExit   = "exit"
+)
+
+// Subsections of the `name` custom section indexed by function.
+const (
+	nameSubsectionFunctions byte = 1
+	nameSubsectionLocals    byte = 2
+	nameSubsectionLabels    byte = 3
+)
+
+func instrumentForProfiling(wasmBytes []byte) ([]byte, []string, error) {
+	module, err := parseWASMModule(wasmBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return instrumentProfiling(module)
+}
+
+// instrumentProfiling returns the binary of `module` calling the profiler hooks on entry and
+// exit of its functions, along with the names of the functions of the original module by
+// index (taken from the `name` section, the exports or made up).
+//
+// Each function body is wrapped in a block, so that leaving the function through a `return`
+// (turned into a branch to the block) or a branch to the function label runs the `exit` hook
+// placed after the block. Adding the hooks imports shifts the index of the functions defined
+// by the module, every reference to them is updated.
+func instrumentProfiling(module *wasmModule) ([]byte, []string, error) {
+	imports, err := module.imports()
+	if err != nil {
+		return nil, nil, fmt.Errorf("import section: %w", err)
+	}
+
+	importedFunctions := uint32(0)
+	for _, imported := range imports {
+		if imported.module == profilerModule {
+			return nil, nil, fmt.Errorf("module already imports %s.%s", imported.module, imported.name)
+		}
+		if imported.kind == externFunction {
+			importedFunctions++
+		}
+	}
+
+	types, err := module.types()
+	if err != nil {
+		return nil, nil, fmt.Errorf("type section: %w", err)
+	}
+
+	functions, err := module.functions()
+	if err != nil {
+		return nil, nil, fmt.Errorf("function section: %w", err)
+	}
+
+	bodies, err := module.functionBodies()
+	if err != nil {
+		return nil, nil, fmt.Errorf("code section: %w", err)
+	}
+	if len(bodies) != len(functions) {
+		return nil, nil, fmt.Errorf("module defines %d functions but %d function bodies", len(functions), len(bodies))
+	}
+
+	exports, err := module.exports()
+	if err != nil {
+		return nil, nil, fmt.Errorf("export section: %w", err)
+	}
+
+	globals, err := module.globals()
+	if err != nil {
+		return nil, nil, fmt.Errorf("global section: %w", err)
+	}
+
+	names, err := functionNames(module, imports, exports, importedFunctions+uint32(len(functions)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("name section: %w", err)
+	}
+
+	enter, exit := importedFunctions, importedFunctions+1
+	shift := func(index uint32) uint32 {
+		if index >= importedFunctions {
+			return index + 2
+		}
+		return index
+	}
+
+	hookType, types := typeIndex(types, wasmFunctionType{params: []byte{valueI32}})
+	for i, signature := range functions {
+		if signature >= uint32(len(types)) {
+			return nil, nil, fmt.Errorf("function %d has unknown type %d", i, signature)
+		}
+
+		var blockType []byte
+		switch results := types[signature].results; len(results) {
+		case 0:
+			blockType = []byte{blockTypeEmpty}
+		case 1:
+			blockType = results
+		default:
+			var index uint32
+			index, types = typeIndex(types, wasmFunctionType{results: results})
+			blockType = appendS64(nil, int64(index))
+		}
+
+		function := importedFunctions + uint32(i)
+		if bodies[i].code, err = instrumentFunction(bodies[i].code, function, blockType, enter, exit, shift); err != nil {
+			return nil, nil, fmt.Errorf("function %d (%s): %w", function, names[function], err)
+		}
+	}
+
+	for i, export := range exports {
+		if export.kind == externFunction {
+			exports[i].index = shift(export.index)
+		}
+	}
+
+	for i, global := range globals {
+		if globals[i].init, err = shiftConstExpr(global.init, shift); err != nil {
+			return nil, nil, fmt.Errorf("global %d: %w", i, err)
+		}
+	}
+
+	var start []byte
+	if payload := module.section(sectionStart); payload != nil {
+		index, err := newWASMReader(payload).u32()
+		if err != nil {
+			return nil, nil, fmt.Errorf("start section: %w", err)
+		}
+		start = appendU32(nil, shift(index))
+	}
+
+	var elements []byte
+	if payload := module.section(sectionElement); payload != nil {
+		if elements, err = shiftElements(payload, shift); err != nil {
+			return nil, nil, fmt.Errorf("element section: %w", err)
+		}
+	}
+
+	var nameSection []byte
+	if payload := module.customSection("name"); payload != nil {
+		if nameSection, err = shiftNames(payload, shift); err != nil {
+			return nil, nil, fmt.Errorf("name section: %w", err)
+		}
+	}
+
+	importSection, err := appendHookImports(module.section(sectionImport), hookType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("import section: %w", err)
+	}
+
+	instrumented := &wasmModule{}
+	for _, section := range module.sections {
+		if section.id == sectionCustom && strings.HasPrefix(customSectionName(section.payload), ".debug_") {
+			// The code offsets of the debugging information are not valid anymore
+			continue
+		}
+		instrumented.sections = append(instrumented.sections, section)
+	}
+
+	instrumented.setSection(sectionType, encodeTypes(types))
+	instrumented.setSection(sectionImport, importSection)
+	instrumented.setSection(sectionCode, encodeFunctionBodies(bodies))
+	if len(exports) > 0 {
+		instrumented.setSection(sectionExport, encodeExports(exports))
+	}
+	if len(globals) > 0 {
+		instrumented.setSection(sectionGlobal, encodeGlobals(globals))
+	}
+	if start != nil {
+		instrumented.setSection(sectionStart, start)
+	}
+	if elements != nil {
+		instrumented.setSection(sectionElement, elements)
+	}
+	if nameSection != nil {
+		instrumented.setCustomSection("name", nameSection)
+	}
+
+	return instrumented.encode(), names, nil
+}
+
+// instrumentFunction returns `code`, the expression of the function `function`, wrapped in a
+// block of type `blockType` between the calls to the `enter` and `exit` hooks.
+func instrumentFunction(code []byte, function uint32, blockType []byte, enter, exit uint32, shift func(uint32) uint32) ([]byte, error) {
+	out := make([]byte, 0, len(code)+32)
+	out = appendHookCall(out, function, enter)
+	out = append(out, opBlock)
+	out = append(out, blockType...)
+
+	reader := newWASMReader(code)
+	depth := uint32(0)
+	for !reader.done() {
+		start := reader.offset
+		opcode, err := reader.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opEnd:
+			if depth == 0 {
+				if !reader.done() {
+					return nil, fmt.Errorf("unexpected instructions after the end of the function at offset %d", reader.offset)
+				}
+
+				out = append(out, opEnd)
+				out = appendHookCall(out, function, exit)
+				return append(out, opEnd), nil
+			}
+			depth--
+		case opBlock, opLoop, opIf, opTry, opTryTable:
+			depth++
+		case opDelegate:
+			depth--
+		case opThrow, opRethrow, opThrowRef:
+			return nil, fmt.Errorf("instruction %#x at offset %d: exceptions thrown out of the function would skip its exit hook, they are not supported by the profiler", opcode, start)
+		case opReturn:
+			out = appendU32(append(out, opBr), depth)
+			continue
+		case opCall, opRefFunc, opReturnCall:
+			index, err := reader.u32()
+			if err != nil {
+				return nil, fmt.Errorf("instruction %#x at offset %d: %w", opcode, start, err)
+			}
+
+			if opcode == opReturnCall {
+				out = appendHookCall(out, function, exit)
+			}
+			out = appendU32(append(out, opcode), shift(index))
+			continue
+		case opReturnCallIndirect:
+			out = appendHookCall(out, function, exit)
+		}
+
+		if err := reader.immediates(opcode); err != nil {
+			return nil, fmt.Errorf("instruction %#x at offset %d: %w", opcode, start, err)
+		}
+		out = append(out, code[start:reader.offset]...)
+	}
+
+	return nil, errUnexpectedEnd
+}
+
+func appendHookCall(out []byte, function uint32, hook uint32) []byte {
+	out = appendS64(append(out, opI32Const), int64(int32(function)))
+	return appendU32(append(out, opCall), hook)
+}
+
+// appendHookImports returns the import section `payload` importing the profiler hooks, of
+// type `hookType`, after the imports of the module.
+func appendHookImports(payload []byte, hookType uint32) ([]byte, error) {
+	reader := newWASMReader(payload)
+
+	count := uint32(0)
+	if !reader.done() {
+		var err error
+		if count, err = reader.u32(); err != nil {
+			return nil, err
+		}
+	}
+
+	out := appendU32(nil, count+2)
+	out = append(out, payload[reader.offset:]...)
+	for _, name := range []string{profilerEnter, profilerExit} {
+		out = appendVector(out, []byte(profilerModule))
+		out = appendVector(out, []byte(name))
+		out = appendU32(append(out, externFunction), hookType)
+	}
+	return out, nil
+}
+
+// typeIndex returns the index of `functionType` in `types`, appending it if missing.
+func typeIndex(types []wasmFunctionType, functionType wasmFunctionType) (uint32, []wasmFunctionType) {
+	for i, candidate := range types {
+		if candidate.equal(functionType) {
+			return uint32(i), types
+		}
+	}
+	return uint32(len(types)), append(types, functionType)
+}
+
+func shiftConstExpr(expr []byte, shift func(uint32) uint32) ([]byte, error) {
+	var out []byte
+
+	reader := newWASMReader(expr)
+	for !reader.done() {
+		start := reader.offset
+		opcode, err := reader.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		if opcode == opRefFunc {
+			index, err := reader.u32()
+			if err != nil {
+				return nil, err
+			}
+			out = appendU32(append(out, opRefFunc), shift(index))
+			continue
+		}
+
+		if err := reader.immediates(opcode); err != nil {
+			return nil, err
+		}
+		out = append(out, expr[start:reader.offset]...)
+	}
+
+	return out, nil
+}
+
+// shiftElements returns the element section `payload` with its function indexes shifted.
+func shiftElements(payload []byte, shift func(uint32) uint32) ([]byte, error) {
+	reader := newWASMReader(payload)
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	out := appendU32(nil, count)
+	for i := uint32(0); i < count; i++ {
+		start := reader.offset
+		flags, err := reader.u32()
+		if err != nil {
+			return nil, fmt.Errorf("element segment %d: %w", i, err)
+		}
+		if flags > 7 {
+			return nil, fmt.Errorf("element segment %d: unknown flags %d", i, flags)
+		}
+
+		if flags&0x02 != 0 && flags&0x01 == 0 {
+			// Explicit table index
+			_, err = reader.u32()
+		}
+		if err == nil && flags&0x01 == 0 {
+			_, err = reader.constExpr()
+		}
+		if err == nil && flags&0x03 != 0 {
+			// Element kind or reference type
+			_, err = reader.byte()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("element segment %d: %w", i, err)
+		}
+		out = append(out, payload[start:reader.offset]...)
+
+		items, err := reader.u32()
+		if err != nil {
+			return nil, fmt.Errorf("element segment %d: %w", i, err)
+		}
+
+		out = appendU32(out, items)
+		for j := uint32(0); j < items; j++ {
+			if flags&0x04 == 0 {
+				var index uint32
+				if index, err = reader.u32(); err == nil {
+					out = appendU32(out, shift(index))
+				}
+			} else {
+				var expr []byte
+				if expr, err = reader.constExpr(); err == nil {
+					expr, err = shiftConstExpr(expr, shift)
+					out = append(out, expr...)
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("element segment %d: %w", i, err)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// shiftNames returns the `name` section `payload` with its function indexes shifted.
+func shiftNames(payload []byte, shift func(uint32) uint32) ([]byte, error) {
+	var out []byte
+
+	reader := newWASMReader(payload)
+	for !reader.done() {
+		id, err := reader.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := reader.vector()
+		if err != nil {
+			return nil, fmt.Errorf("subsection %d: %w", id, err)
+		}
+
+		switch id {
+		case nameSubsectionFunctions, nameSubsectionLocals, nameSubsectionLabels:
+			if content, err = shiftNameMap(content, id != nameSubsectionFunctions, shift); err != nil {
+				return nil, fmt.Errorf("subsection %d: %w", id, err)
+			}
+		}
+
+		out = appendVector(append(out, id), content)
+	}
+
+	return out, nil
+}
+
+// shiftNameMap returns the name map `content` indexed by function with its function indexes
+// shifted, an indirect name map (a name map per function) if `indirect`.
+func shiftNameMap(content []byte, indirect bool, shift func(uint32) uint32) ([]byte, error) {
+	reader := newWASMReader(content)
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	out := appendU32(nil, count)
+	for i := uint32(0); i < count; i++ {
+		index, err := reader.u32()
+		if err != nil {
+			return nil, err
+		}
+		out = appendU32(out, shift(index))
+
+		start := reader.offset
+		if indirect {
+			_, err = readNameMap(reader)
+		} else {
+			_, err = reader.name()
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, content[start:reader.offset]...)
+	}
+
+	return out, nil
+}
+
+func readNameMap(reader *wasmReader) (map[uint32]string, error) {
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[uint32]string, count)
+	for i := uint32(0); i < count; i++ {
+		index, err := reader.u32()
+		if err != nil {
+			return nil, err
+		}
+		if names[index], err = reader.name(); err != nil {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+// functionNames returns the names of the `count` functions of `module` by index.
+func functionNames(module *wasmModule, imports []wasmImport, exports []wasmExport, count uint32) ([]string, error) {
+	names := make([]string, count)
+
+	if payload := module.customSection("name"); payload != nil {
+		reader := newWASMReader(payload)
+		for !reader.done() {
+			id, err := reader.byte()
+			if err != nil {
+				return nil, err
+			}
+
+			content, err := reader.vector()
+			if err != nil {
+				return nil, err
+			}
+			if id != nameSubsectionFunctions {
+				continue
+			}
+
+			byIndex, err := readNameMap(newWASMReader(content))
+			if err != nil {
+				return nil, err
+			}
+			for index, name := range byIndex {
+				if index < count {
+					names[index] = name
+				}
+			}
+		}
+	}
+
+	function := uint32(0)
+	for _, imported := range imports {
+		if imported.kind == externFunction {
+			if names[function] == "" {
+				names[function] = imported.module + "." + imported.name
+			}
+			function++
+		}
+	}
+
+	for _, export := range exports {
+		if export.kind == externFunction && export.index < count && names[export.index] == "" {
+			names[export.index] = export.name
+		}
+	}
+
+	for index, name := range names {
+		if name == "" {
+			names[index] = fmt.Sprintf("wasm-function[%d]", index)
+		}
+	}
+
+	return names, nil
+}
+
+func customSectionName(payload []byte) string {
+	name, _ := newWASMReader(payload).name()
+	return name
+}
@@ -0,0 +1,113 @@
+package wasm
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const profilerTestModule = `
+(module
+  (type $unary (func (param i32) (result i32)))
+  (import "env" "println" (func $println (param i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "hello")
+  (table 1 funcref)
+  (elem (i32.const 0) $square)
+
+  (func $square (type $unary)
+    (i32.mul (local.get 0) (local.get 0)))
+
+  (func $sum (param $n i32) (result i32) (local $total i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $n)))
+        (local.set $total (i32.add (local.get $total) (call_indirect (type $unary) (local.get $n) (i32.const 0))))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br $next)))
+    (local.get $total))
+
+  (func $report (param $value i32) (result i32)
+    (if (i32.eqz (local.get $value)) (then (return (i32.const -1))))
+    (call $println (i32.const 1024) (i32.const 5))
+    (if (i32.gt_s (local.get $value) (i32.const 100)) (then (br 1 (i32.const 100))))
+    (local.get $value))
+
+  (func $pair (result i32 i32)
+    (i32.const 1) (i32.const 2))
+
+  (func (export "handle") (param $n i32) (result i32)
+    (if (i32.lt_s (local.get $n) (i32.const 0)) (then (unreachable)))
+    (i32.add (call $pair))
+    (call $report (call $sum (local.get $n)))
+    (i32.add))
+)
+`
+
+func TestProfiler(t *testing.T) {
+	wasmFile := writeTestModule(t, profilerTestModule)
+
+	profiler := NewProfiler()
+	profiled, err := NewRuntime(&RustEnvironment{}, WithProfiler(profiler), WithGuestOutput(io.Discard)).Instantiate(wasmFile)
+	require.NoError(t, err)
+	defer profiled.Close()
+
+	plain, err := NewRuntime(&RustEnvironment{}, WithGuestOutput(io.Discard)).Instantiate(wasmFile)
+	require.NoError(t, err)
+	defer plain.Close()
+
+	for _, n := range []int32{3, 0, 10} {
+		expected, err := plain.Execute("handle", []interface{}{n})
+		require.NoError(t, err)
+
+		actual, err := profiled.Execute("handle", []interface{}{n})
+		require.NoError(t, err)
+		assert.Equal(t, expected.Value, actual.Value, "handle(%d)", n)
+	}
+
+	_, err = profiled.Execute("handle", []interface{}{int32(-1)})
+	require.Error(t, err)
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, profiler.Write(buffer))
+
+	parsed, err := profile.Parse(buffer)
+	require.NoError(t, err)
+	require.NoError(t, parsed.CheckValid())
+
+	calls := map[string]int64{}
+	for _, sample := range parsed.Sample {
+		var stack []string
+		for i := len(sample.Location) - 1; i >= 0; i-- {
+			stack = append(stack, sample.Location[i].Line[0].Function.Name)
+		}
+		calls[strings.Join(stack, ";")] += sample.Value[0]
+
+		assert.True(t, sample.Value[1] >= 0)
+	}
+
+	assert.Equal(t, map[string]int64{
+		"handle":                    4,
+		"handle;pair":               3,
+		"handle;sum":                3,
+		"handle;sum;square":         13,
+		"handle;report":             3,
+		"handle;report;env.println": 2,
+	}, calls)
+
+	for _, function := range parsed.Function {
+		if function.Name == "env.println" {
+			assert.Equal(t, hostFrameFile, function.Filename)
+		} else {
+			assert.Equal(t, "module", function.Filename)
+		}
+	}
+
+	profiler.Reset()
+	assert.Empty(t, profiler.Profile().Sample)
+}
@@ -0,0 +1,60 @@
+package wasm
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// protoParameter lowers a protobuf message into its wire format written in the module
+// memory and passed as a `(ptr, len)` pair.
+type protoParameter struct {
+	message proto.Message
+}
+
+func (p protoParameter) MarshalWASM(heap *AscHeap) ([]interface{}, error) {
+	bytes, err := proto.Marshal(p.message)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s: %w", p.message.ProtoReflect().Descriptor().FullName(), err)
+	}
+
+	ptr, err := heap.reserve(len(bytes))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := heap.write(ptr, bytes); err != nil {
+		return nil, err
+	}
+
+	return []interface{}{ptr, int32(len(bytes))}, nil
+}
+
+// NewProtoOutput creates an output slot receiving a `(ptr, len)` pair pointing to a
+// protobuf message in wire format, decoded as a new message of the same type as
+// `prototype`.
+func NewProtoOutput(name string, prototype proto.Message) *AscReturnValue {
+	return NewOutput(name, protoOutput{prototype})
+}
+
+type protoOutput struct {
+	prototype proto.Message
+}
+
+func (o protoOutput) SlotSize() int32 {
+	return 8
+}
+
+func (o protoOutput) Decode(slot []byte, read MemoryReader) (interface{}, error) {
+	data, err := bytesOutput{}.Decode(slot, read)
+	if err != nil {
+		return nil, err
+	}
+
+	message := o.prototype.ProtoReflect().New().Interface()
+	if err := proto.Unmarshal(data.([]byte), message); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", message.ProtoReflect().Descriptor().FullName(), err)
+	}
+
+	return message, nil
+}
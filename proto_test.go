@@ -0,0 +1,37 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const protoTestModule = `
+(module
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "\0a\02")
+
+  (func (export "echo") (param $ptr i32) (param $len i32) (param $echo i32) (param $invalid i32)
+    (i32.store (local.get $echo) (local.get $ptr))
+    (i32.store offset=4 (local.get $echo) (local.get $len))
+    (i32.store (local.get $invalid) (i32.const 1024))
+    (i32.store offset=4 (local.get $invalid) (i32.const 2))))
+`
+
+func TestRuntime_Proto(t *testing.T) {
+	wasmFile := writeTestModule(t, protoTestModule)
+
+	echo := NewProtoOutput("echo", &wrapperspb.StringValue{})
+	runtime := NewRuntime(&RustEnvironment{})
+
+	_, err := runtime.Execute(wasmFile, "echo", []interface{}{wrapperspb.String("hello")}, echo, NewAscReturnValue("invalid"))
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(wrapperspb.String("hello"), echo.Value().(proto.Message)))
+
+	_, err = runtime.Execute(wasmFile, "echo", []interface{}{wrapperspb.String("hello")}, NewAscReturnValue("echo"), NewProtoOutput("invalid", &wrapperspb.StringValue{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `decoding output "invalid": unmarshal google.protobuf.StringValue:`)
+}
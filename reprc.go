@@ -0,0 +1,222 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// MemoryReader reads `length` bytes of the module memory starting at `ptr`.
+type MemoryReader func(ptr int32, length int32) ([]byte, error)
+
+// reprCLayout returns the size and alignment of `typ` when laid out like a Rust
+// `#[repr(C)]` value on wasm32. Strings and byte slices are represented as a
+// `(ptr, len)` pair of u32 pointing to their content in the module memory.
+func reprCLayout(typ reflect.Type) (size int32, align int32, err error) {
+	switch typ.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		return 1, 1, nil
+	case reflect.Int16, reflect.Uint16:
+		return 2, 2, nil
+	case reflect.Int32, reflect.Uint32, reflect.Int, reflect.Uint, reflect.Float32:
+		return 4, 4, nil
+	case reflect.Int64, reflect.Uint64, reflect.Float64:
+		return 8, 8, nil
+	case reflect.String:
+		return 8, 4, nil
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return 8, 4, nil
+		}
+	case reflect.Array:
+		elementSize, elementAlign, err := reprCLayout(typ.Elem())
+		if err != nil {
+			return 0, 0, err
+		}
+		return elementSize * int32(typ.Len()), elementAlign, nil
+	case reflect.Struct:
+		align = 1
+		for i := 0; i < typ.NumField(); i++ {
+			fieldSize, fieldAlign, err := reprCLayout(typ.Field(i).Type)
+			if err != nil {
+				return 0, 0, fmt.Errorf("field %s.%s: %w", typ, typ.Field(i).Name, err)
+			}
+
+			size = alignTo(size, fieldAlign) + fieldSize
+			if fieldAlign > align {
+				align = fieldAlign
+			}
+		}
+		return alignTo(size, align), align, nil
+	}
+
+	return 0, 0, fmt.Errorf("unhandled type %s", typ)
+}
+
+// decodeReprC decodes `in`, laid out as described by reprCLayout, into `rv`. Strings and
+// byte slices content is read from the module memory through `read`.
+func decodeReprC(in []byte, rv reflect.Value, read MemoryReader) error {
+	size, _, err := reprCLayout(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	if int32(len(in)) < size {
+		return fmt.Errorf("%s needs %d bytes, got %d", rv.Type(), size, len(in))
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		rv.SetBool(in[0] != 0)
+	case reflect.Int8:
+		rv.SetInt(int64(int8(in[0])))
+	case reflect.Uint8:
+		rv.SetUint(uint64(in[0]))
+	case reflect.Int16:
+		rv.SetInt(int64(int16(encoding.Uint16(in))))
+	case reflect.Uint16:
+		rv.SetUint(uint64(encoding.Uint16(in)))
+	case reflect.Int32, reflect.Int:
+		rv.SetInt(int64(int32(encoding.Uint32(in))))
+	case reflect.Uint32, reflect.Uint:
+		rv.SetUint(uint64(encoding.Uint32(in)))
+	case reflect.Int64:
+		rv.SetInt(int64(encoding.Uint64(in)))
+	case reflect.Uint64:
+		rv.SetUint(encoding.Uint64(in))
+	case reflect.Float32:
+		rv.SetFloat(float64(math.Float32frombits(encoding.Uint32(in))))
+	case reflect.Float64:
+		rv.SetFloat(math.Float64frombits(encoding.Uint64(in)))
+
+	case reflect.String, reflect.Slice:
+		content, err := read(int32(encoding.Uint32(in)), int32(encoding.Uint32(in[4:])))
+		if err != nil {
+			return err
+		}
+
+		if rv.Kind() == reflect.String {
+			rv.SetString(string(content))
+		} else {
+			rv.SetBytes(append([]byte(nil), content...))
+		}
+
+	case reflect.Array:
+		elementSize, _, _ := reprCLayout(rv.Type().Elem())
+		for i := 0; i < rv.Len(); i++ {
+			if err := decodeReprC(in[int32(i)*elementSize:], rv.Index(i), read); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+
+	case reflect.Struct:
+		offset := int32(0)
+		for i := 0; i < rv.NumField(); i++ {
+			fieldSize, fieldAlign, _ := reprCLayout(rv.Type().Field(i).Type)
+			offset = alignTo(offset, fieldAlign)
+
+			field := rv.Field(i)
+			if !field.CanSet() {
+				offset += fieldSize
+				continue
+			}
+
+			if err := decodeReprC(in[offset:], field, read); err != nil {
+				return fmt.Errorf("field %s.%s: %w", rv.Type(), rv.Type().Field(i).Name, err)
+			}
+			offset += fieldSize
+		}
+	}
+
+	return nil
+}
+
+// MemoryAllocator allocates `data` in the module memory and returns the pointer to its
+// copy.
+type MemoryAllocator func(data []byte) (int32, error)
+
+// encodeReprC encodes `rv` into `out`, laid out as described by reprCLayout. Strings and
+// byte slices content is allocated in the module memory through `allocate`.
+func encodeReprC(out []byte, rv reflect.Value, allocate MemoryAllocator) error {
+	size, _, err := reprCLayout(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	if int32(len(out)) < size {
+		return fmt.Errorf("%s needs %d bytes, got %d", rv.Type(), size, len(out))
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		out[0] = 0
+		if rv.Bool() {
+			out[0] = 1
+		}
+	case reflect.Int8, reflect.Uint8:
+		out[0] = byte(reflectBits(rv))
+	case reflect.Int16, reflect.Uint16:
+		encoding.PutUint16(out, uint16(reflectBits(rv)))
+	case reflect.Int32, reflect.Int, reflect.Uint32, reflect.Uint:
+		encoding.PutUint32(out, uint32(reflectBits(rv)))
+	case reflect.Int64, reflect.Uint64:
+		encoding.PutUint64(out, reflectBits(rv))
+	case reflect.Float32:
+		encoding.PutUint32(out, math.Float32bits(float32(rv.Float())))
+	case reflect.Float64:
+		encoding.PutUint64(out, math.Float64bits(rv.Float()))
+
+	case reflect.String, reflect.Slice:
+		var content []byte
+		if rv.Kind() == reflect.String {
+			content = []byte(rv.String())
+		} else {
+			content = rv.Bytes()
+		}
+
+		ptr, err := allocate(content)
+		if err != nil {
+			return err
+		}
+
+		encoding.PutUint32(out, uint32(ptr))
+		encoding.PutUint32(out[4:], uint32(len(content)))
+
+	case reflect.Array:
+		elementSize, _, _ := reprCLayout(rv.Type().Elem())
+		for i := 0; i < rv.Len(); i++ {
+			if err := encodeReprC(out[int32(i)*elementSize:], rv.Index(i), allocate); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+
+	case reflect.Struct:
+		for i := range out[:size] {
+			out[i] = 0
+		}
+
+		offset := int32(0)
+		for i := 0; i < rv.NumField(); i++ {
+			fieldSize, fieldAlign, _ := reprCLayout(rv.Type().Field(i).Type)
+			offset = alignTo(offset, fieldAlign)
+
+			if rv.Type().Field(i).PkgPath == "" {
+				if err := encodeReprC(out[offset:], rv.Field(i), allocate); err != nil {
+					return fmt.Errorf("field %s.%s: %w", rv.Type(), rv.Type().Field(i).Name, err)
+				}
+			}
+			offset += fieldSize
+		}
+	}
+
+	return nil
+}
+
+// reflectBits returns the two's complement bits of the integer held by `rv`.
+func reflectBits(rv reflect.Value) uint64 {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(rv.Int())
+	}
+	return rv.Uint()
+}
@@ -0,0 +1,336 @@
+package wasm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Tuple holds the lifted values of a function returning multiple values. Used as the
+// prototype given to WithResultType, each element is itself the prototype of the value
+// at that position.
+type Tuple []interface{}
+
+// WithResultType declares that `functionName` returns a value of the same type as
+// `prototype`, the raw wasm values returned by the function are then lifted to that type
+// before being returned by Execute.
+//
+// Scalars are converted from their wasm representation. Strings, byte slices, structs and
+// slices are read from the module memory: AssemblyScript modules return a pointer to the
+// managed object (structs being decoded as classes, see AscClass) while modules using the
+// Rust ABI (see WithParameterPointSize) return either a `(ptr, len)` pair of i32 or a
+// single i64 packed as `ptr << 32 | len`, structs being a pointer to a `#[repr(C)]`
+// value. A `Tuple` prototype lifts each returned value in turn and returns a Tuple.
+func WithResultType(functionName string, prototype interface{}) RuntimeOption {
+	return func(r *Runtime) {
+		if r.resultTypes == nil {
+			r.resultTypes = map[string]interface{}{}
+		}
+		r.resultTypes[functionName] = prototype
+	}
+}
+
+type resultLifter struct {
+	heap    *AscHeap
+	rustABI bool
+	values  []interface{}
+}
+
+func (r *Runtime) liftResult(heap *AscHeap, raw interface{}, prototype interface{}) (out interface{}, err error) {
+	lifter := &resultLifter{heap: heap, rustABI: r.pointerWithSize}
+	switch v := raw.(type) {
+	case nil:
+	case []interface{}:
+		lifter.values = v
+	default:
+		lifter.values = []interface{}{v}
+	}
+
+	if prototypes, ok := prototype.(Tuple); ok {
+		tuple := make(Tuple, len(prototypes))
+		for i, prototype := range prototypes {
+			if tuple[i], err = lifter.liftValue(prototype); err != nil {
+				return nil, fmt.Errorf("tuple element %d: %w", i, err)
+			}
+		}
+		out = tuple
+	} else if out, err = lifter.liftValue(prototype); err != nil {
+		return nil, err
+	}
+
+	if len(lifter.values) > 0 {
+		return nil, fmt.Errorf("%d returned value(s) left unused after lifting result to %T", len(lifter.values), prototype)
+	}
+
+	return out, nil
+}
+
+func (l *resultLifter) liftValue(prototype interface{}) (interface{}, error) {
+	rv := reflect.New(reflect.TypeOf(prototype)).Elem()
+	if err := l.lift(rv); err != nil {
+		return nil, err
+	}
+	return rv.Interface(), nil
+}
+
+func (l *resultLifter) next() (interface{}, error) {
+	if len(l.values) == 0 {
+		return nil, fmt.Errorf("not enough returned values")
+	}
+
+	value := l.values[0]
+	l.values = l.values[1:]
+	return value, nil
+}
+
+func (l *resultLifter) nextI32() (int32, error) {
+	value, err := l.next()
+	if err != nil {
+		return 0, err
+	}
+
+	i32, ok := value.(int32)
+	if !ok {
+		return 0, fmt.Errorf("expected an i32 returned value, got %T", value)
+	}
+	return i32, nil
+}
+
+// nextSegment consumes the `(ptr, len)` pair, or the packed i64 equivalent, describing a
+// segment of memory under the Rust ABI.
+func (l *resultLifter) nextSegment() (ptr int32, length int32, err error) {
+	if len(l.values) > 0 {
+		if packed, ok := l.values[0].(int64); ok {
+			l.values = l.values[1:]
+			return int32(uint64(packed) >> 32), int32(uint32(packed)), nil
+		}
+	}
+
+	if ptr, err = l.nextI32(); err != nil {
+		return
+	}
+	length, err = l.nextI32()
+	return
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+func (l *resultLifter) lift(rv reflect.Value) error {
+	if rv.Kind() == reflect.Ptr && rv.Type().Implements(unmarshalerType) {
+		element := reflect.New(rv.Type().Elem())
+		if err := l.unmarshal(element.Interface().(Unmarshaler)); err != nil {
+			return err
+		}
+		rv.Set(element)
+		return nil
+	}
+
+	if reflect.PtrTo(rv.Type()).Implements(unmarshalerType) {
+		return l.unmarshal(rv.Addr().Interface().(Unmarshaler))
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Uint8, reflect.Int16, reflect.Uint16, reflect.Int32, reflect.Uint32,
+		reflect.Int, reflect.Uint, reflect.Int64, reflect.Uint64, reflect.Float32, reflect.Float64:
+		value, err := l.next()
+		if err != nil {
+			return err
+		}
+		return setScalar(rv, value)
+
+	case reflect.String, reflect.Slice, reflect.Struct, reflect.Ptr:
+		if l.rustABI {
+			return l.liftRust(rv)
+		}
+
+		ptr, err := l.nextI32()
+		if err != nil {
+			return err
+		}
+		return l.liftAsc(ptr, rv)
+
+	case reflect.Interface:
+		value, err := l.next()
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	return fmt.Errorf("unhandled result type %s", rv.Type())
+}
+
+func (l *resultLifter) unmarshal(unmarshaler Unmarshaler) error {
+	consumed, err := unmarshaler.UnmarshalWASM(l.heap, l.values)
+	if err != nil {
+		return fmt.Errorf("unmarshal %T: %w", unmarshaler, err)
+	}
+
+	if consumed < 0 || consumed > len(l.values) {
+		return fmt.Errorf("unmarshal %T: consumed %d value(s) out of %d", unmarshaler, consumed, len(l.values))
+	}
+
+	l.values = l.values[consumed:]
+	return nil
+}
+
+func (l *resultLifter) liftAsc(ptr int32, rv reflect.Value) error {
+	if ptr == 0 {
+		return nil
+	}
+
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+		classID, err := l.heap.ObjectClassID(ptr)
+		if err != nil {
+			return err
+		}
+
+		var bytes []byte
+		if classID == AscArrayBufferID {
+			bytes, err = l.heap.ReadArrayBuffer(ptr)
+		} else {
+			bytes, err = l.heap.ReadArray(ptr, 1)
+		}
+		if err != nil {
+			return err
+		}
+
+		rv.SetBytes(bytes)
+		return nil
+	}
+
+	return l.heap.readAscReference(ptr, rv, ascFieldOptions{})
+}
+
+func (l *resultLifter) liftRust(rv reflect.Value) error {
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice:
+		ptr, length, err := l.nextSegment()
+		if err != nil {
+			return err
+		}
+
+		if rv.Kind() == reflect.String || rv.Type().Elem().Kind() == reflect.Uint8 {
+			content, err := l.heap.read(ptr, length)
+			if err != nil {
+				return err
+			}
+
+			if rv.Kind() == reflect.String {
+				rv.SetString(string(content))
+			} else {
+				rv.SetBytes(append([]byte(nil), content...))
+			}
+			return nil
+		}
+
+		elementSize, _, err := reprCLayout(rv.Type().Elem())
+		if err != nil {
+			return err
+		}
+
+		// The length comes from the guest, the size is checked against the memory before
+		// allocating the slice
+		size := int64(length) * int64(elementSize)
+		if length < 0 || size > int64(len(l.heap.memory.Data())) {
+			return &MemoryAccessError{Ptr: uint32(ptr), Length: size, MemorySize: uint64(len(l.heap.memory.Data()))}
+		}
+
+		content, err := l.heap.read(ptr, int32(size))
+		if err != nil {
+			return err
+		}
+
+		slice := reflect.MakeSlice(rv.Type(), int(length), int(length))
+		for i := 0; i < int(length); i++ {
+			if err := decodeReprC(content[int32(i)*elementSize:], slice.Index(i), l.heap.read); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		rv.Set(slice)
+		return nil
+
+	case reflect.Ptr:
+		ptr, err := l.nextI32()
+		if err != nil || ptr == 0 {
+			return err
+		}
+
+		element := reflect.New(rv.Type().Elem())
+		if err := l.decodeRustStruct(ptr, element.Elem()); err != nil {
+			return err
+		}
+		rv.Set(element)
+		return nil
+
+	case reflect.Struct:
+		ptr, err := l.nextI32()
+		if err != nil {
+			return err
+		}
+		return l.decodeRustStruct(ptr, rv)
+	}
+
+	return fmt.Errorf("unhandled result type %s", rv.Type())
+}
+
+func (l *resultLifter) decodeRustStruct(ptr int32, rv reflect.Value) error {
+	size, _, err := reprCLayout(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	content, err := l.heap.read(ptr, size)
+	if err != nil {
+		return err
+	}
+
+	return decodeReprC(content, rv, l.heap.read)
+}
+
+// setScalar stores the wasm value `value` in `rv`. Integers are sign-extended into signed
+// kinds and zero-extended into unsigned ones (an i32 holds an `u32` then), a value not fitting
+// in the kind of `rv` is an error rather than being truncated.
+func setScalar(rv reflect.Value, value interface{}) error {
+	var signed int64
+	var unsigned uint64
+	switch v := value.(type) {
+	case int32:
+		signed, unsigned = int64(v), uint64(uint32(v))
+	case int64:
+		signed, unsigned = v, uint64(v)
+	case float32:
+		if rv.Kind() != reflect.Float32 && rv.Kind() != reflect.Float64 {
+			return fmt.Errorf("cannot lift f32 to %s", rv.Type())
+		}
+		rv.SetFloat(float64(v))
+		return nil
+	case float64:
+		if rv.Kind() != reflect.Float32 && rv.Kind() != reflect.Float64 {
+			return fmt.Errorf("cannot lift f64 to %s", rv.Type())
+		}
+		rv.SetFloat(v)
+		return nil
+	default:
+		return fmt.Errorf("cannot lift %T to %s", value, rv.Type())
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		rv.SetBool(unsigned != 0)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int, reflect.Int64:
+		if rv.OverflowInt(signed) {
+			return fmt.Errorf("value %d overflows %s", signed, rv.Type())
+		}
+		rv.SetInt(signed)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint, reflect.Uint64:
+		if rv.OverflowUint(unsigned) {
+			return fmt.Errorf("value %d overflows %s", unsigned, rv.Type())
+		}
+		rv.SetUint(unsigned)
+	default:
+		return fmt.Errorf("cannot lift %T to %s", value, rv.Type())
+	}
+
+	return nil
+}
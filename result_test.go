@@ -0,0 +1,114 @@
+package wasm
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+const resultTestModule = `
+(module
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "hello")
+  (data (i32.const 1032) "\2a\00\00\00\00\00\00\00\07\00\00\00\00\00\00\00\00\04\00\00\05\00\00\00")
+
+  (func (export "pair") (result i32 i32)
+    i32.const 1024
+    i32.const 5)
+
+  (func (export "packed") (result i64)
+    i64.const 0x0000040000000005)
+
+  (func (export "record") (result i32)
+    i32.const 1032)
+
+  (func (export "huge") (result i32 i32)
+    i32.const 1024
+    i32.const 0x7FFFFFFF)
+
+  (func (export "tuple") (result i32 i32 i32 f64)
+    i32.const -1
+    i32.const 1024
+    i32.const 5
+    f64.const 1.5))
+`
+
+type resultTestRecord struct {
+	ID    uint32
+	Count int64
+	Name  string
+}
+
+func TestRuntime_ResultType(t *testing.T) {
+	wasmFile := writeTestModule(t, resultTestModule)
+
+	tests := []struct {
+		functionName string
+		prototype    interface{}
+		expected     interface{}
+	}{
+		{"pair", "", "hello"},
+		{"pair", []byte(nil), []byte("hello")},
+		{"packed", "", "hello"},
+		{"record", resultTestRecord{}, resultTestRecord{ID: 42, Count: 7, Name: "hello"}},
+		{"record", &resultTestRecord{}, &resultTestRecord{ID: 42, Count: 7, Name: "hello"}},
+		{"tuple", Tuple{uint32(0), "", float64(0)}, Tuple{uint32(0xFFFFFFFF), "hello", 1.5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.functionName, func(t *testing.T) {
+			runtime := NewRuntime(&RustEnvironment{}, WithParameterPointSize(), WithResultType(test.functionName, test.prototype))
+
+			actual, err := runtime.Execute(wasmFile, test.functionName, nil)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, actual.Value)
+		})
+	}
+}
+
+func TestRuntime_ResultType_LengthOutOfMemory(t *testing.T) {
+	runtime := NewRuntime(&RustEnvironment{}, WithParameterPointSize(), WithResultType("huge", []resultTestRecord(nil)))
+
+	_, err := runtime.Execute(writeTestModule(t, resultTestModule), "huge", nil)
+	var accessErr *MemoryAccessError
+	require.ErrorAs(t, err, &accessErr)
+	assert.Equal(t, uint32(1024), accessErr.Ptr)
+}
+
+func writeTestModule(t testing.TB, wat string) string {
+	t.Helper()
+
+	wasmBytes, err := wasmer.Wat2Wasm(wat)
+	require.NoError(t, err)
+
+	wasmFile := filepath.Join(t.TempDir(), "module.wasm")
+	require.NoError(t, os.WriteFile(wasmFile, wasmBytes, 0644))
+
+	return wasmFile
+}
+
+func TestSetScalar(t *testing.T) {
+	var i8 int8
+	require.NoError(t, setScalar(reflect.ValueOf(&i8).Elem(), int32(-1)))
+	assert.Equal(t, int8(-1), i8)
+
+	var i64 int64
+	require.NoError(t, setScalar(reflect.ValueOf(&i64).Elem(), int32(-1)))
+	assert.Equal(t, int64(-1), i64)
+
+	var u32 uint32
+	require.NoError(t, setScalar(reflect.ValueOf(&u32).Elem(), int32(-1)))
+	assert.Equal(t, uint32(0xFFFFFFFF), u32)
+
+	var u8 uint8
+	assert.EqualError(t, setScalar(reflect.ValueOf(&u8).Elem(), int32(256)), "value 256 overflows uint8")
+	assert.EqualError(t, setScalar(reflect.ValueOf(&i8).Elem(), int32(128)), "value 128 overflows int8")
+
+	var i16 int16
+	assert.EqualError(t, setScalar(reflect.ValueOf(&i16).Elem(), int64(-40000)), "value -40000 overflows int16")
+}
@@ -1,14 +1,18 @@
 package wasm
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
-	"math"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/wasmerio/wasmer-go/wasmer"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 type abortError struct {
@@ -19,7 +23,20 @@ type abortError struct {
 }
 
 func (e *abortError) Error() string {
-	return fmt.Sprintf("wasm execution aborted at %s:%d env:%d env: %s", e.filename, e.lineNumber, e.columnNumber, e.message)
+	return fmt.Sprintf("wasm execution aborted at %s:%d:%d: %s", e.filename, e.lineNumber, e.columnNumber, e.message)
+}
+
+// GuestPanicError is returned when a Rust module panicked, as reported by its panic hook
+// through `env.register_panic`.
+type GuestPanicError struct {
+	Message  string
+	Filename string
+	Line     int
+	Column   int
+}
+
+func (e *GuestPanicError) Error() string {
+	return fmt.Sprintf("wasm execution panicked at %s:%d:%d: %s", e.Filename, e.Line, e.Column, e.Message)
 }
 
 type MemoryAllocationFactory func(instance *wasmer.Instance) wasmer.NativeFunction
@@ -37,15 +54,45 @@ func WithParameterPointSize() RuntimeOption {
 	}
 }
 
+// WithRTTIDecoding decodes the i32 returned by functions of AssemblyScript modules as a
+// pointer to a managed object, using the runtime type information exported by the module
+// through `__rtti_base` to turn it into a Go value (see AscHeap.Decode).
+func WithRTTIDecoding() RuntimeOption {
+	return func(r *Runtime) {
+		r.rttiDecoding = true
+	}
+}
+
 type Runtime struct {
 	env                Environment
 	memoryAllocFactory MemoryAllocationFactory
 	pointerWithSize    bool
+	rttiDecoding       bool
+	resultTypes        map[string]interface{}
+	codec              Codec
+	wasi               *WASIConfig
+	logger             *zap.Logger
+	guestOutput        GuestOutputFunc
+	guestOutputLimiter *rate.Limiter
+	guestLogMaxEntries int
+	guestLogMaxBytes   int
+	hostFunctions      []impl
+	memoryStats        MemoryStatsFunc
+	metrics            *Metrics
+	tracer             trace.Tracer
+	profiler           *Profiler
+
+	// instrumented caches the rewritten binaries of the modules by hash, see instrument.
+	instrumented sync.Map
 }
 
 func NewRuntime(env Environment, options ...RuntimeOption) *Runtime {
 	runtime := &Runtime{
-		env: env,
+		env:                env,
+		logger:             zlog,
+		tracer:             noopTracer(),
+		guestLogMaxEntries: DefaultGuestLogMaxEntries,
+		guestLogMaxBytes:   DefaultGuestLogMaxBytes,
 	}
 
 	for _, option := range options {
@@ -54,276 +101,188 @@ func NewRuntime(env Environment, options ...RuntimeOption) *Runtime {
 	return runtime
 }
 
-func (r *Runtime) Execute(wasmFile string, functionName string, parameters []interface{}, returns ...*AscReturnValue) (interface{}, error) {
+// Execute instantiates the module found in `wasmFile`, calls `functionName` with
+// `parameters` and tears the instance down. Use Instantiate to perform multiple calls
+// against the same instance.
+func (r *Runtime) Execute(wasmFile string, functionName string, parameters []interface{}, returns ...*AscReturnValue) (*ExecutionResult, error) {
+	return r.ExecuteContext(context.Background(), wasmFile, functionName, parameters, returns...)
+}
+
+// ExecuteContext is Execute handing `ctx` to the host functions, see Instance.ExecuteContext.
+func (r *Runtime) ExecuteContext(ctx context.Context, wasmFile string, functionName string, parameters []interface{}, returns ...*AscReturnValue) (result *ExecutionResult, err error) {
+	ctx, span := r.tracer.Start(ctx, spanExecute, trace.WithAttributes(
+		attributeModule.String(moduleName(wasmFile)),
+		attributeFunction.String(functionName),
+	))
+	defer func() { endSpan(span, err) }()
+
 	wasmBytes, err := ioutil.ReadFile(wasmFile)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load wasm file %q: %w", wasmFile, err)
 	}
 
-	engine := wasmer.NewEngine()
-	store := wasmer.NewStore(engine)
-
-	module, err := wasmer.NewModule(store, wasmBytes)
+	instance, err := r.instantiate(ctx, wasmFile, wasmBytes)
 	if err != nil {
-		return nil, fmt.Errorf("unable to compile wasm file %q: %w", wasmFile, err)
+		return nil, err
 	}
+	defer instance.Close()
 
-	importObject := newImports(r.env, store)
-	instance, err := wasmer.NewInstance(module, importObject)
-	if err != nil {
-		return nil, fmt.Errorf("unable to get wasm module instance from %q: %w", wasmFile, err)
-	}
+	return instance.execute(ctx, functionName, parameters, returns)
+}
 
-	memory, err := instance.Exports.GetMemory("memory")
+// Run instantiates the WASI command module found in `wasmFile` and runs it through its
+// `_start` export, see Instance.Run.
+func (r *Runtime) Run(wasmFile string) (exitCode int32, err error) {
+	instance, err := r.Instantiate(wasmFile)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get the wasm module memory: %w", err)
+		return 0, err
 	}
+	defer instance.Close()
 
-	r.env.SetMemory(memory)
+	return instance.Run()
+}
 
-	if ztracer.Enabled() {
-		pages := memory.Size()
+type AscHeap struct {
+	memory          *wasmer.Memory
+	logger          *zap.Logger
+	allocator       wasmer.NativeFunction
+	ascNew          wasmer.NativeFunction
+	ascPin          wasmer.NativeFunction
+	ascUnpin        wasmer.NativeFunction
+	pinned          []int32
+	rootDepth       int
+	rtti            *AscRTTI
+	tracker         *memoryTracker
+	nextPtrLocation int32
+	freeSpace       uint
+}
 
-		zlog.Debug("memory information for invocation",
-			zap.Uint32("pages_count", pages.ToUint32()),
-			zap.Uint("pages_bytes", pages.ToBytes()),
-			zap.Uint("date_size_bytes", memory.DataSize()),
-		)
+func newAscHeap(memory *wasmer.Memory, logger *zap.Logger) *AscHeap {
+	return &AscHeap{
+		memory:    memory,
+		logger:    logger,
+		freeSpace: memory.DataSize(),
 	}
+}
 
-	entrypointFunction, err := instance.Exports.GetRawFunction(functionName)
+// Write copies `bytes` in host managed memory and returns their pointer.
+func (h *AscHeap) Write(bytes []byte) (int32, error) {
+	ptr, err := h.reserve(len(bytes))
 	if err != nil {
-		return nil, fmt.Errorf("unable to get wasm module function %q from %q: %w", functionName, wasmFile, err)
+		return 0, err
 	}
 
-	if ztracer.Enabled() {
-		zlog.Debug("entrypoint function loaded", zap.Stringer("def", namedFunctionDefinition{functionName, entrypointFunction}))
+	if err := h.write(ptr, bytes); err != nil {
+		return 0, err
 	}
 
-	heap := newAscHeap(memory)
-	if r.memoryAllocFactory != nil {
-		heap.allocator = r.memoryAllocFactory(instance)
+	return ptr, nil
+}
+
+// allocate reserves `size` bytes through the allocator of the module when it has one (see
+// WithMemoryAllocationFactory), through the host managed memory otherwise.
+func (h *AscHeap) allocate(size int32) (int32, error) {
+	if h.allocator == nil {
+		return h.reserve(int(size))
 	}
 
-	result, err := r.callFunction(heap, entrypointFunction, parameters, returns)
+	out, err := h.allocator(size)
 	if err != nil {
-		return nil, fmt.Errorf("unable to execute wasm module function %q from %q: %w", functionName, wasmFile, err)
+		return 0, fmt.Errorf("allocate %d bytes: %w", size, err)
 	}
 
-	zlog.Info("execution result", zap.Reflect("result", result))
-	return result, nil
-}
-
-type AscHeap struct {
-	memory          *wasmer.Memory
-	allocator       wasmer.NativeFunction
-	nextPtrLocation int32
-	freeSpace       uint
-}
-
-func newAscHeap(memory *wasmer.Memory) *AscHeap {
-	if len(memory.Data()) != int(memory.DataSize()) {
-		panic("ALSKDJ")
-	}
-	return &AscHeap{
-		memory:    memory,
-		freeSpace: memory.DataSize(),
+	ptr, ok := out.(int32)
+	if !ok {
+		return 0, fmt.Errorf("allocator returned %T, expected i32", out)
 	}
+	return ptr, nil
 }
 
-func (h *AscHeap) Write(bytes []byte) int32 {
-	size := len(bytes)
-
+// reserve books `size` bytes of host managed memory, growing the memory if required, and
+// returns the pointer to the start of the reserved segment.
+func (h *AscHeap) reserve(size int) (int32, error) {
 	if uint(size) > h.freeSpace {
-		fmt.Println("memory grown")
 		numberOfPages := (uint(size) / wasmer.WasmPageSize) + 1
+		h.logger.Debug("growing memory", zap.Int("size", size), zap.Uint("pages", numberOfPages))
+
 		grown := h.memory.Grow(wasmer.Pages(numberOfPages))
 		if !grown {
-			panic("couldn't grow memory")
+			return 0, fmt.Errorf("couldn't grow memory")
 		}
 		h.freeSpace += (wasmer.WasmPageSize * numberOfPages)
+
+		if h.tracker != nil {
+			h.tracker.grown()
+		}
 	}
 
 	ptr := h.nextPtrLocation
 
-	memoryData := h.memory.Data()
-	copy(memoryData[ptr:], bytes)
-
 	h.nextPtrLocation += int32(size)
 	h.freeSpace -= uint(size)
 
-	return ptr
+	return ptr, nil
 }
 
 type AscPtr interface {
-	ToPtr(heap *AscHeap) (int32, int32)
-}
-
-type AscReturnValue struct {
-	name string
-	ptr  int32
-}
-
-func NewAscReturnValue(name string) *AscReturnValue {
-	return &AscReturnValue{
-		name: name,
-	}
-}
-
-func (v *AscReturnValue) ToPtr(heap *AscHeap) (int32, int32) {
-	bs := make([]byte, 8)
-	ptr := heap.Write(bs)
-	v.ptr = ptr
-	return ptr, int32(len(bs))
-}
-
-func (v *AscReturnValue) ReadData(env Environment) ([]byte, error) {
-	//fmt.Printf("reading data for %s @ %d\n", v.name, v.ptr)
-	ptr, err := env.ReadI32(v.ptr)
-	if err != nil {
-		return nil, fmt.Errorf("getting [%s] return value pointer: %w", v.name, err)
-
-	}
-	length, err := env.ReadI32(v.ptr + 4)
-	if err != nil {
-		return nil, fmt.Errorf("getting [%s] return value length: %w", v.name, err)
-	}
-
-	return env.ReadBytes(ptr, length)
+	ToPtr(heap *AscHeap) (ptr int32, size int32, err error)
 }
 
 type AscString string
 
-func (h AscString) ToPtr(heap *AscHeap) (int32, int32) {
+func (h AscString) ToPtr(heap *AscHeap) (int32, int32, error) {
 	bytes := []byte(h)
-	return heap.Write(bytes), int32(len(bytes))
+	ptr, err := heap.Write(bytes)
+	return ptr, int32(len(bytes)), err
 }
 
 type AscBytes []byte
 
-func (h AscBytes) ToPtr(heap *AscHeap) (int32, int32) {
-	ptr := heap.Write(h)
-	return ptr, int32(len(h))
+func (h AscBytes) ToPtr(heap *AscHeap) (int32, int32, error) {
+	ptr, err := heap.Write(h)
+	return ptr, int32(len(h)), err
 }
 
-func (r *Runtime) callFunction(heap *AscHeap, entrypoint *wasmer.Function, parameters []interface{}, returns []*AscReturnValue) (out interface{}, err error) {
-	//defer func() {
-	//	if r := recover(); r != nil {
-	//		switch x := r.(type) {
-	//		case string:
-	//			err = errors.New(x)
-	//		case error:
-	//			err = x
-	//		default:
-	//			err = errors.New("Unknown panic")
-	//		}
-	//	}
-	//}()
-
-	wasmParameters := toWASMParameters(heap, parameters, r.pointerWithSize)
-
+func (r *Runtime) callFunction(call *invocation, heap *AscHeap, functionName string, entrypoint *wasmer.Function, parameters []interface{}, returns []*AscReturnValue) (out interface{}, err error) {
 	for _, returnValue := range returns {
-		ptr, _ := returnValue.ToPtr(heap)
-		fmt.Println("return pointer created @:", ptr)
-		wasmParameters = append(wasmParameters, ptr)
+		if returnValue.err != nil {
+			return nil, returnValue.err
+		}
 	}
 
-	//mem := r.env.GetMemory()
-	out, err = entrypoint.Call(wasmParameters...)
-
-	return
-}
-
-func (r *Runtime) getReturnPtrLength(valueLocation int32) (ptr int32, length int32, err error) {
-	ptr, err = r.env.ReadI32(valueLocation)
-	if err != nil {
-		err = fmt.Errorf("getting return value pointer: %w", err)
-		return
-	}
-	length, err = r.env.ReadI32(valueLocation + 4)
+	wasmParameters, err := toWASMParameters(heap, parameters, r.pointerWithSize, r.codec)
 	if err != nil {
-		err = fmt.Errorf("getting return value length: %w", err)
-		return
+		return nil, err
 	}
-	return
-}
+	call.parameterBytes = call.tracker.stats.HostWrittenBytes
 
-func printMem(memory *wasmer.Memory) {
-	data := memory.Data()
-	for i, datum := range data {
-		if i > 1024 {
-			if datum == 0 {
-				continue
-			}
+	for _, returnValue := range returns {
+		ptr, _, err := returnValue.ToPtr(heap)
+		if err != nil {
+			return nil, fmt.Errorf("allocate output %q: %w", returnValue.Name(), err)
 		}
-		fmt.Print(datum, ", ")
+		r.logger.Debug("return pointer created", zap.String("name", returnValue.Name()), zap.Int32("ptr", ptr))
+		wasmParameters = append(wasmParameters, ptr)
 	}
-	println("")
-}
-
-func toWASMParameters(heap *AscHeap, parameters []interface{}, withSize bool) (out []interface{}) {
-	for _, parameter := range parameters {
-		wasmValue := toWASMValue(parameter)
-		size := int32(math.MaxInt32) //not super clean
-		if v, ok := wasmValue.(AscPtr); ok {
-			wasmValue, size = v.ToPtr(heap)
-		}
 
-		if ztracer.Enabled() {
-			zlog.Debug("converted parameter to wasm value", zap.Stringer("original", typedField{parameter}), zap.Stringer("wasm", typedField{wasmValue}))
-		}
-
-		out = append(out, wasmValue)
-
-		if withSize {
-			if size != int32(math.MaxInt32) {
-				out = append(out, size)
-			}
-		}
+	if err = validateParameters(functionName, entrypoint, wasmParameters); err != nil {
+		return nil, err
 	}
 
-	return
-}
+	start := time.Now()
+	out, err = entrypoint.Call(wasmParameters...)
+	call.guestDuration = time.Since(start) - call.hostDuration
+	if err != nil {
+		return nil, asProcExitError(err)
+	}
 
-func toWASMValue(in interface{}) interface{} {
-	switch v := in.(type) {
-	case bool:
-		if v == true {
-			return int32(1)
+	for _, returnValue := range returns {
+		if err = returnValue.decode(heap); err != nil {
+			return nil, err
 		}
-		return int32(0)
-	case int8:
-		return int32(v)
-	case uint8:
-		return int32(v)
-	case int16:
-		return int32(v)
-	case uint16:
-		return int32(v)
-	case int32:
-		return int32(v)
-	case uint32:
-		return int32(v)
-	case int64:
-		return int64(v)
-	case uint64:
-		return uint64(v)
-	case int:
-		// The WASM spec differentiates between int32 vs int64 depending on WASM32 or WASM64, but I assume we are always in the context of WASM32 here
-		return int32(v)
-	case uint:
-		// The WASM spec differentiates between int32 vs int64 depending on WASM32 or WASM64, but I assume we are always in the context of WASM32 here
-		return int32(v)
-	case float32, float64:
-		return v
-
-	case []byte:
-		return AscBytes(v)
-	case string:
-		return AscString(v)
 	}
 
-	panic(fmt.Errorf("unhandled type %T to WASM", in))
+	return
 }
 
 type hexBytes []byte
@@ -0,0 +1,102 @@
+package wasm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const panicTestModule = `
+(module
+  (import "env" "register_panic" (func $register_panic (param i32 i32 i32 i32 i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "index out of bounds")
+  (data (i32.const 1056) "src/lib.rs")
+
+  (func (export "run") (param $panic i32) (result i32)
+    (if (local.get $panic)
+      (then
+        (call $register_panic (i32.const 1024) (i32.const 19) (i32.const 1056) (i32.const 10) (i32.const 12) (i32.const 5))
+        (unreachable)))
+    (i32.const 1)))
+`
+
+func TestRuntime_GuestPanic(t *testing.T) {
+	wasmFile := writeTestModule(t, panicTestModule)
+	runtime := NewRuntime(&RustEnvironment{})
+
+	actual, err := runtime.Execute(wasmFile, "run", []interface{}{int32(0)})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), actual.Value)
+
+	_, err = runtime.Execute(wasmFile, "run", []interface{}{int32(1)})
+	var panicErr *GuestPanicError
+	require.True(t, errors.As(err, &panicErr), "expected a GuestPanicError, got %v", err)
+	assert.Equal(t, &GuestPanicError{"index out of bounds", "src/lib.rs", 12, 5}, panicErr)
+	assert.EqualError(t, err, `unable to execute wasm module function "run" from "`+wasmFile+`": wasm execution panicked at src/lib.rs:12:5: index out of bounds`)
+}
+
+const guestErrorTestModule = `
+(module
+  (import "env" "set_error" (func $set_error (param i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "amount must be positive")
+
+  (func (export "transfer") (param $amount i32) (param $error i32) (result i32)
+    (if (i32.gt_s (local.get $amount) (i32.const 0)) (then (return (i32.const 0))))
+    (i32.store (local.get $error) (i32.const 1024))
+    (i32.store offset=4 (local.get $error) (i32.const 23))
+    (i32.const 22))
+
+  (func (export "validate") (param $amount i32) (result i32)
+    (if (i32.le_s (local.get $amount) (i32.const 0)) (then (call $set_error (i32.const 1024) (i32.const 23))))
+    (local.get $amount)))
+`
+
+func TestRuntime_GuestError(t *testing.T) {
+	wasmFile := writeTestModule(t, guestErrorTestModule)
+	runtime := NewRuntime(&RustEnvironment{})
+
+	actual, err := runtime.Execute(wasmFile, "transfer", []interface{}{int32(10)}, NewErrorOutput("error"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), actual.Value)
+
+	_, err = runtime.Execute(wasmFile, "transfer", []interface{}{int32(-1)}, NewErrorOutput("error"))
+	var guestErr *GuestError
+	require.True(t, errors.As(err, &guestErr), "expected a GuestError, got %v", err)
+	assert.Equal(t, &GuestError{Code: 22, Message: "amount must be positive"}, guestErr)
+
+	actual, err = runtime.Execute(wasmFile, "validate", []interface{}{int32(3)})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), actual.Value)
+
+	_, err = runtime.Execute(wasmFile, "validate", []interface{}{int32(0)})
+	assert.EqualError(t, err, `wasm module function "validate" from "`+wasmFile+`" rejected the call: guest error: amount must be positive`)
+}
+
+// The strings are laid out as AssemblyScript objects, their size at ptr-4.
+const abortTestModule = `
+(module
+  (import "env" "abort" (func $abort (param i32 i32 i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1016) "\01\00\00\00\08\00\00\00o\00o\00p\00s\00")
+  (data (i32.const 2040) "\01\00\00\00\08\00\00\00a\00.\00t\00s\00")
+
+  (func (export "run") (param $message i32)
+    (call $abort (local.get $message) (i32.const 2048) (i32.const 3) (i32.const 7))))
+`
+
+func TestRuntime_Abort(t *testing.T) {
+	wasmFile := writeTestModule(t, abortTestModule)
+	runtime := NewRuntime(&RustEnvironment{})
+
+	_, err := runtime.Execute(wasmFile, "run", []interface{}{int32(1024)})
+	var abortErr *abortError
+	require.True(t, errors.As(err, &abortErr), "expected an abortError, got %v", err)
+	assert.EqualError(t, err, `unable to execute wasm module function "run" from "`+wasmFile+`": wasm execution aborted at a.ts:3:7: oops`)
+
+	_, err = runtime.Execute(wasmFile, "run", []interface{}{int32(0)})
+	assert.EqualError(t, err, `unable to execute wasm module function "run" from "`+wasmFile+`": wasm execution aborted at a.ts:3:7: `)
+}
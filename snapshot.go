@@ -0,0 +1,158 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+	"go.uber.org/zap"
+)
+
+// snapshotChunkSize is the granularity at which the memory is compared to its snapshot, only
+// the chunks that differ are written back on reset.
+const snapshotChunkSize = 4096
+
+// snapshot is the state of an instance captured by Instance.Snapshot: its linear memory, its
+// mutable globals and the host managed heap.
+type snapshot struct {
+	memory  []byte
+	globals []globalSnapshot
+
+	nextPtrLocation int32
+	freeSpace       uint
+	started         bool
+}
+
+type globalSnapshot struct {
+	name   string
+	global *wasmer.Global
+	kind   wasmer.ValueKind
+	value  interface{}
+}
+
+// Snapshot captures the state of the instance, restored by each call to Reset. It is meant to
+// be taken right after instantiation, once the module is initialized, so that every call
+// starts from the same state.
+//
+// Every mutable global defined by the module is captured, including the ones it does not
+// export such as the stack pointer of Rust modules, which is left as is by a trapped call.
+//
+// The WASI descriptors are captured too, which fails when the module has a file open or was
+// given host directories (see WASIConfig.PreopenDirs). The contents of the WASI Mounts are
+// shared by all the instances of the runtime, they are not part of the snapshot.
+func (i *Instance) Snapshot() error {
+	state := &snapshot{
+		memory:          append([]byte(nil), i.memory.Data()...),
+		nextPtrLocation: i.heap.nextPtrLocation,
+		freeSpace:       i.heap.freeSpace,
+		started:         i.started,
+	}
+
+	if i.wasi != nil {
+		if err := i.wasi.snapshot(); err != nil {
+			return fmt.Errorf("unable to snapshot wasi state of %q: %w", i.wasmFile, err)
+		}
+	}
+
+	for _, export := range i.module.Exports() {
+		// The mutable globals are all exported under these names by instrumentGlobals
+		if export.Type().Kind() != wasmer.GLOBAL || !strings.HasPrefix(export.Name(), globalExportPrefix) {
+			continue
+		}
+
+		global, err := i.instance.Exports.GetGlobal(export.Name())
+		if err != nil {
+			return fmt.Errorf("unable to get global %q of %q: %w", export.Name(), i.wasmFile, err)
+		}
+
+		value, err := global.Get()
+		if err != nil {
+			return fmt.Errorf("unable to read global %q of %q: %w", export.Name(), i.wasmFile, err)
+		}
+
+		state.globals = append(state.globals, globalSnapshot{
+			name:   export.Name(),
+			global: global,
+			kind:   global.Type().ValueType().Kind(),
+			value:  value,
+		})
+	}
+
+	i.snapshot = state
+	return nil
+}
+
+// Reset restores the state captured by Snapshot. Only the chunks of memory that differ from
+// the snapshot are written back, so resetting an instance whose calls touch little memory
+// is much cheaper than instantiating it again.
+//
+// A memory cannot shrink: when it grew since the snapshot, the pages added are zeroed and
+// left to the heap. The files the module opened through WASI since the snapshot are closed.
+func (i *Instance) Reset() error {
+	if i.snapshot == nil {
+		return errors.New("instance has no snapshot")
+	}
+
+	restored := i.snapshot.restoreMemory(i.memory.Data())
+
+	for _, global := range i.snapshot.globals {
+		if err := global.global.Set(global.value, global.kind); err != nil {
+			return fmt.Errorf("unable to restore global %q of %q: %w", global.name, i.wasmFile, err)
+		}
+	}
+
+	i.heap.nextPtrLocation = i.snapshot.nextPtrLocation
+	i.heap.freeSpace = i.snapshot.freeSpace + uint(len(i.memory.Data())-len(i.snapshot.memory))
+	i.started = i.snapshot.started
+
+	if i.wasi != nil {
+		i.wasi.reset()
+	}
+
+	if ztracer.Enabled() {
+		i.runtime.logger.Debug("instance reset", zap.String("wasm_file", i.wasmFile), zap.Int("restored_bytes", restored))
+	}
+
+	return nil
+}
+
+// restoreMemory writes back the chunks of `memory` differing from the snapshot, zeroing what
+// is past its end, and returns the number of bytes written.
+func (s *snapshot) restoreMemory(memory []byte) (restored int) {
+	for start := 0; start < len(memory); start += snapshotChunkSize {
+		end := start + snapshotChunkSize
+		if end > len(memory) {
+			end = len(memory)
+		}
+
+		current := memory[start:end]
+		if start >= len(s.memory) {
+			if !isZero(current) {
+				for i := range current {
+					current[i] = 0
+				}
+				restored += len(current)
+			}
+			continue
+		}
+
+		original := s.memory[start:end]
+		if !bytes.Equal(current, original) {
+			copy(current, original)
+			restored += len(current)
+		}
+	}
+
+	return restored
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
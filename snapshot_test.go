@@ -0,0 +1,148 @@
+package wasm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const snapshotTestModule = `
+(module
+  (memory (export "memory") 1)
+  (global $calls (export "calls") (mut i32) (i32.const 0))
+
+  (func (export "bump") (result i32)
+    (global.set $calls (i32.add (global.get $calls) (i32.const 1)))
+    (i32.store (i32.const 2048) (i32.add (i32.load (i32.const 2048)) (i32.const 10)))
+    (i32.add (global.get $calls) (i32.load (i32.const 2048))))
+
+  (func (export "grow") (result i32)
+    (drop (memory.grow (i32.const 1)))
+    (i32.store (i32.const 70000) (i32.const 7))
+    (memory.size)))
+`
+
+func TestInstance_Reset(t *testing.T) {
+	instance, err := NewRuntime(&RustEnvironment{}).Instantiate(writeTestModule(t, snapshotTestModule))
+	require.NoError(t, err)
+	defer instance.Close()
+
+	assert.EqualError(t, instance.Reset(), "instance has no snapshot")
+	require.NoError(t, instance.Snapshot())
+
+	actual, err := instance.Execute("bump", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(11), actual.Value)
+
+	actual, err = instance.Execute("bump", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(22), actual.Value)
+
+	require.NoError(t, instance.Reset())
+	actual, err = instance.Execute("bump", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(11), actual.Value)
+
+	actual, err = instance.Execute("grow", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), actual.Value)
+
+	require.NoError(t, instance.Reset())
+	data := instance.memory.Data()
+	assert.Len(t, data, 2*65536)
+	assert.True(t, isZero(data[65536:]))
+	assert.Equal(t, uint(2*65536), instance.heap.freeSpace)
+}
+
+const snapshotStackTestModule = `
+(module
+  (memory (export "memory") 1)
+  (global $sp (mut i32) (i32.const 4096))
+
+  (func (export "call") (param $trap i32) (result i32)
+    (global.set $sp (i32.sub (global.get $sp) (i32.const 16)))
+    (if (local.get $trap) (then (unreachable)))
+    (global.set $sp (i32.add (global.get $sp) (i32.const 16)))
+    (global.get $sp)))
+`
+
+// The stack pointer of the module is not exported and is left decremented by the trap.
+func TestInstancePool_ResetAfterTrap(t *testing.T) {
+	pool := NewRuntime(&RustEnvironment{}).NewInstancePool(writeTestModule(t, snapshotStackTestModule), 1)
+	defer pool.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := pool.Execute(context.Background(), "call", []interface{}{int32(1)})
+		require.Error(t, err)
+
+		actual, err := pool.Execute(context.Background(), "call", []interface{}{int32(0)})
+		require.NoError(t, err)
+		assert.Equal(t, int32(4096), actual.Value)
+	}
+}
+
+func TestSnapshot_RestoreMemory(t *testing.T) {
+	state := &snapshot{memory: make([]byte, 4*snapshotChunkSize)}
+	memory := make([]byte, 5*snapshotChunkSize)
+
+	assert.Equal(t, 0, state.restoreMemory(memory))
+
+	memory[1] = 1
+	memory[snapshotChunkSize+10] = 1
+	memory[4*snapshotChunkSize+1] = 1
+	assert.Equal(t, 3*snapshotChunkSize, state.restoreMemory(memory))
+	assert.True(t, isZero(memory))
+}
+
+func TestInstancePool(t *testing.T) {
+	pool := NewRuntime(&RustEnvironment{}).NewInstancePool(writeTestModule(t, snapshotTestModule), 1)
+	defer pool.Close()
+
+	for i := 0; i < 3; i++ {
+		actual, err := pool.Execute(context.Background(), "bump", nil)
+		require.NoError(t, err)
+		assert.Equal(t, int32(11), actual.Value)
+	}
+
+	first, err := pool.Get()
+	require.NoError(t, err)
+	second, err := pool.Get()
+	require.NoError(t, err)
+	assert.NotSame(t, first, second)
+
+	pool.Put(first)
+	pool.Put(second)
+
+	reused, err := pool.Get()
+	require.NoError(t, err)
+	assert.Same(t, first, reused)
+	pool.Put(reused)
+}
+
+func TestInstance_ResetWASI(t *testing.T) {
+	runtime := NewRuntime(&RustEnvironment{}, WithWASI(WASIConfig{
+		Mounts: map[string]VirtualFS{"/": NewMemFS()},
+	}))
+
+	instance, err := runtime.Instantiate(writeTestModule(t, wasiFileSizeTestModule))
+	require.NoError(t, err)
+	defer instance.Close()
+	require.NoError(t, instance.Snapshot())
+
+	wasi := instance.wasi.(*virtualWASI)
+	_, err = instance.Execute("run", []interface{}{int64(0), int64(16)})
+	require.NoError(t, err)
+	assert.Len(t, wasi.fds, 2)
+	assert.EqualError(t, instance.Snapshot(), `unable to snapshot wasi state of "`+instance.wasmFile+`": file "big.bin" is open, open files cannot be restored`)
+
+	require.NoError(t, instance.Reset())
+	assert.Len(t, wasi.fds, 1)
+	assert.Equal(t, int32(4), wasi.nextFD)
+
+	instance, err = NewRuntime(&RustEnvironment{}, WithWASI(WASIConfig{PreopenDirs: []string{t.TempDir()}})).Instantiate(writeTestModule(t, wasiFileSizeTestModule))
+	require.NoError(t, err)
+	defer instance.Close()
+	assert.EqualError(t, instance.Snapshot(), `unable to snapshot wasi state of "`+instance.wasmFile+`": the wasi state of modules given host directories cannot be restored, use Mounts instead`)
+}
@@ -1,7 +1,9 @@
 package assembly_scripts
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -12,9 +14,9 @@ import (
 	"github.com/wasmerio/wasmer-go/wasmer"
 )
 
+// TestAssemblyScript runs the modules compiled from `src` by `build_all.sh` (after a `yarn
+// install`), the ones not built are skipped.
 func TestAssemblyScript(t *testing.T) {
-	t.Skip()
-
 	tests := []struct {
 		wasmFile      string
 		functionName  string
@@ -67,6 +69,11 @@ func TestAssemblyScript(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.wasmFile, func(t *testing.T) {
+			wasmFile := filepath.Join("build", test.wasmFile)
+			if _, err := os.Stat(wasmFile); errors.Is(err, os.ErrNotExist) {
+				t.Skipf("%s is not built, run build_all.sh", wasmFile)
+			}
+
 			recorder := &callRecorder{}
 			env := wasm.RustEnvironment{CallRecorder: recorder}
 
@@ -78,13 +85,13 @@ func TestAssemblyScript(t *testing.T) {
 				return function
 			}
 
-			runtime := wasm.NewRuntime(&env, wasm.WithMemoryAllocationFactory(memoryAllocationFactory))
+			runtime := wasm.NewRuntime(&env, wasm.WithMemoryAllocationFactory(memoryAllocationFactory), wasm.WithRTTIDecoding())
 
-			actual, err := runtime.Execute(filepath.Join("build", test.wasmFile), test.functionName, test.parameters)
+			actual, err := runtime.Execute(wasmFile, test.functionName, test.parameters)
 
 			if test.expectedErr == nil {
 				require.NoError(t, err)
-				assert.Equal(t, test.expected, actual)
+				assert.Equal(t, test.expected, actual.Value)
 
 				if len(test.expectedCalls) > 0 {
 					assert.Equal(t, test.expectedCalls, recorder.calls)
@@ -53,15 +53,12 @@ func TestRustScript(t *testing.T) {
 			require.NoError(t, err)
 
 			for _, returnValue := range test.outputsPtr {
-				data, err := returnValue.ReadData(env)
-				require.NoError(t, err)
-				fmt.Println("received data as string:", string(data))
-
+				fmt.Println("received data as string:", string(returnValue.Value().([]byte)))
 			}
 
 			if test.expectedErr == nil {
 				require.NoError(t, err)
-				assert.Equal(t, test.expectedReturnValue, actual)
+				assert.Equal(t, test.expectedReturnValue, actual.Value)
 
 				if len(test.expectedCalls) > 0 {
 					assert.Equal(t, test.expectedCalls, recorder.calls)
@@ -0,0 +1,67 @@
+package wasm
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/streamingfast/wasm-runtime"
+
+// Span names, each Execute opens a `wasm.execute` span whose children are the `wasm.compile`
+// and `wasm.instantiate` spans when the runtime instantiates the module, then the `wasm.call`
+// span of the export. The host functions called by the export are children of the latter,
+// named after the function they run (e.g. `index.store.get`).
+const (
+	spanExecute     = "wasm.execute"
+	spanCompile     = "wasm.compile"
+	spanInstantiate = "wasm.instantiate"
+	spanCall        = "wasm.call"
+)
+
+// Span attributes.
+const (
+	attributeModule          = attribute.Key("wasm.module")
+	attributeModuleHash      = attribute.Key("wasm.module.hash")
+	attributeFunction        = attribute.Key("wasm.function")
+	attributeInvocationID    = attribute.Key("wasm.invocation_id")
+	attributeParameterCount  = attribute.Key("wasm.parameters.count")
+	attributeParameterBytes  = attribute.Key("wasm.parameters.bytes")
+	attributeHostCalls       = attribute.Key("wasm.host_calls")
+	attributeMemoryPages     = attribute.Key("wasm.memory.pages")
+	attributeMemoryGrowEvent = attribute.Key("wasm.memory.grow_events")
+	attributeTrapKind        = attribute.Key("wasm.trap.kind")
+	attributeHostFunction    = attribute.Key("wasm.host_function")
+)
+
+// WithTracerProvider opens spans through `provider` for the executions of the runtime, its
+// spans are exported by whatever exporter the provider is configured with (an in-memory one
+// in tests, OTLP in production, ...).
+func WithTracerProvider(provider trace.TracerProvider) RuntimeOption {
+	return func(r *Runtime) {
+		r.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// endSpan ends `span`, marking it as failed with `err` if set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startHostCallSpan opens the span of the call to the host function `name` made by `call`.
+func startHostCallSpan(call *invocation, name string) trace.Span {
+	_, span := call.runtime.tracer.Start(call.context.Context, name, trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(
+		attributeModule.String(call.module),
+		attributeFunction.String(call.function),
+		attributeHostFunction.String(name),
+	))
+	return span
+}
+
+func noopTracer() trace.Tracer {
+	return trace.NewNoopTracerProvider().Tracer(tracerName)
+}
@@ -0,0 +1,74 @@
+package wasm
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRuntime_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	wasmFile := writeTestModule(t, metricsTestModule)
+	runtime := NewRuntime(&RustEnvironment{}, WithTracerProvider(provider), WithGuestOutput(io.Discard))
+
+	_, err := runtime.ExecuteContext(context.Background(), wasmFile, "handle", []interface{}{int32(0)})
+	require.NoError(t, err)
+
+	spans := spansByName(exporter.GetSpans())
+	require.Len(t, spans, 5)
+
+	execute := spans[spanExecute]
+	assert.False(t, execute.Parent.IsValid())
+	for _, name := range []string{spanCompile, spanInstantiate, spanCall} {
+		assert.Equal(t, execute.SpanContext.SpanID(), spans[name].Parent.SpanID(), name)
+	}
+	assert.Equal(t, spans[spanCall].SpanContext.SpanID(), spans["env.println"].Parent.SpanID())
+
+	call := attributesOf(spans[spanCall])
+	assert.Equal(t, "module", call[attributeModule].AsString())
+	assert.Equal(t, "handle", call[attributeFunction].AsString())
+	assert.Equal(t, int64(1), call[attributeParameterCount].AsInt64())
+	assert.Equal(t, int64(1), call[attributeHostCalls].AsInt64())
+	assert.Equal(t, int64(2), call[attributeMemoryPages].AsInt64())
+	assert.Equal(t, int64(1), call[attributeMemoryGrowEvent].AsInt64())
+	assert.NotEmpty(t, call[attributeModuleHash].AsString())
+	assert.NotContains(t, call, attributeTrapKind)
+	assert.Equal(t, codes.Unset, spans[spanCall].Status.Code)
+
+	assert.Equal(t, "env.println", attributesOf(spans["env.println"])[attributeHostFunction].AsString())
+
+	exporter.Reset()
+	_, err = runtime.ExecuteContext(context.Background(), wasmFile, "handle", []interface{}{int32(2)})
+	require.Error(t, err)
+
+	spans = spansByName(exporter.GetSpans())
+	assert.Equal(t, "memory_access", attributesOf(spans[spanCall])[attributeTrapKind].AsString())
+	assert.Equal(t, codes.Error, spans[spanCall].Status.Code)
+	assert.Equal(t, codes.Error, spans[spanExecute].Status.Code)
+	assert.Equal(t, codes.Error, spans["env.println"].Status.Code)
+}
+
+func spansByName(stubs tracetest.SpanStubs) map[string]tracetest.SpanStub {
+	spans := map[string]tracetest.SpanStub{}
+	for _, stub := range stubs {
+		spans[stub.Name] = stub
+	}
+	return spans
+}
+
+func attributesOf(stub tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range stub.Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+	return attributes
+}
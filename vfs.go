@@ -0,0 +1,634 @@
+package wasm
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrReadOnlyFS is returned when modifying a VirtualFS that does not accept writes.
+var ErrReadOnlyFS = errors.New("read-only file system")
+
+// VirtualFS is a sandboxed filesystem exposed to WASI modules through WASIConfig.Mounts,
+// the module never reaches the host filesystem through it. Names are slash separated paths
+// relative to the root of the filesystem, following the rules of fs.ValidPath.
+type VirtualFS interface {
+	// OpenFile opens the named file, `flag` is a combination of the os.O_* flags.
+	OpenFile(name string, flag int, perm fs.FileMode) (VirtualFile, error)
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldName, newName string) error
+}
+
+// VirtualFile is a file opened from a VirtualFS.
+type VirtualFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+	Stat() (fs.FileInfo, error)
+	Truncate(size int64) error
+}
+
+func isWriteFlag(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
+
+// Default limits of a MemFS, the module controls the offsets and sizes it writes at.
+const (
+	DefaultMemFSMaxFileSize int64 = 64 * 1024 * 1024
+	DefaultMemFSMaxSize     int64 = 256 * 1024 * 1024
+)
+
+// MemFS is a VirtualFS keeping its files in memory. It also implements fs.FS, so what a
+// module wrote can be read back with the io/fs helpers.
+type MemFS struct {
+	lock  sync.RWMutex
+	nodes map[string]*memNode
+
+	// size is the total size of the files, bounded by maxSize, a limit of 0 meaning none.
+	size        int64
+	maxSize     int64
+	maxFileSize int64
+}
+
+type MemFSOption func(m *MemFS)
+
+// WithMemFSMaxFileSize bounds the size of each file, growing a file beyond fails with
+// syscall.EFBIG. A limit of 0 disables it, defaults to DefaultMemFSMaxFileSize.
+func WithMemFSMaxFileSize(bytes int64) MemFSOption {
+	return func(m *MemFS) {
+		m.maxFileSize = bytes
+	}
+}
+
+// WithMemFSMaxSize bounds the total size of the files, growing a file beyond fails with
+// syscall.EFBIG. A limit of 0 disables it, defaults to DefaultMemFSMaxSize.
+func WithMemFSMaxSize(bytes int64) MemFSOption {
+	return func(m *MemFS) {
+		m.maxSize = bytes
+	}
+}
+
+type memNode struct {
+	mode    fs.FileMode
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemFS(options ...MemFSOption) *MemFS {
+	m := &MemFS{
+		nodes: map[string]*memNode{
+			".": {mode: fs.ModeDir | 0755, modTime: time.Now()},
+		},
+		maxSize:     DefaultMemFSMaxSize,
+		maxFileSize: DefaultMemFSMaxFileSize,
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (VirtualFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node := m.nodes[name]
+	if node == nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+
+		if err := m.checkParent(name); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+
+		node = &memNode{mode: perm & fs.ModePerm, modTime: time.Now()}
+		m.nodes[name] = node
+	} else if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+
+	if node.mode.IsDir() && isWriteFlag(flag) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		m.size -= int64(len(node.data))
+		node.data = nil
+		node.modTime = time.Now()
+	}
+
+	return &memFile{fs: m, node: node, name: name, flag: flag}, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	node := m.nodes[name]
+	if node == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return node.info(name), nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	node := m.nodes[name]
+	if node == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	var entries []fs.DirEntry
+	for child, childNode := range m.nodes {
+		if child != "." && path.Dir(child) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(childNode.info(child)))
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *MemFS) Mkdir(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.nodes[name] != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+
+	if err := m.checkParent(name); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	m.nodes[name] = &memNode{mode: fs.ModeDir | perm&fs.ModePerm, modTime: time.Now()}
+	return nil
+}
+
+// MkdirAll creates the named directory along with any missing parent.
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.mkdirAll(name, perm)
+}
+
+func (m *MemFS) mkdirAll(name string, perm fs.FileMode) error {
+	if node := m.nodes[name]; node != nil {
+		if !node.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+
+	if err := m.mkdirAll(path.Dir(name), perm); err != nil {
+		return err
+	}
+
+	m.nodes[name] = &memNode{mode: fs.ModeDir | perm&fs.ModePerm, modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node := m.nodes[name]
+	if node == nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if node.mode.IsDir() && m.hasChildren(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+
+	m.size -= int64(len(node.data))
+	delete(m.nodes, name)
+	return nil
+}
+
+// removeAll removes the named file or directory along with its content, if any.
+func (m *MemFS) removeAll(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if node := m.nodes[name]; node != nil {
+		m.size -= int64(len(node.data))
+		delete(m.nodes, name)
+	}
+
+	for child, childNode := range m.nodes {
+		if strings.HasPrefix(child, name+"/") {
+			m.size -= int64(len(childNode.data))
+			delete(m.nodes, child)
+		}
+	}
+}
+
+func (m *MemFS) Rename(oldName, newName string) error {
+	if !fs.ValidPath(oldName) || !fs.ValidPath(newName) || oldName == "." || newName == "." {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrInvalid}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node := m.nodes[oldName]
+	if node == nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+
+	if oldName == newName {
+		return nil
+	}
+
+	if strings.HasPrefix(newName, oldName+"/") {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrInvalid}
+	}
+
+	if err := m.checkParent(newName); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+
+	if existing := m.nodes[newName]; existing != nil {
+		if err := checkReplace(node, existing); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+		}
+		if existing.mode.IsDir() && m.hasChildren(newName) {
+			return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: syscall.ENOTEMPTY}
+		}
+		m.size -= int64(len(existing.data))
+	}
+
+	moved := map[string]*memNode{newName: node}
+	for child, childNode := range m.nodes {
+		if strings.HasPrefix(child, oldName+"/") {
+			moved[newName+strings.TrimPrefix(child, oldName)] = childNode
+			delete(m.nodes, child)
+		}
+	}
+
+	delete(m.nodes, oldName)
+	for name, movedNode := range moved {
+		m.nodes[name] = movedNode
+	}
+
+	return nil
+}
+
+// WriteFile writes `data` to the named file, creating it along with any missing parent
+// directory if needed.
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if err := m.MkdirAll(path.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	file, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	return err
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	node := m.nodes[name]
+	if node == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+
+	return append([]byte(nil), node.data...), nil
+}
+
+// resize grows or shrinks the data of `node` to `size` bytes, within the limits of the
+// filesystem. The caller holds the write lock.
+func (m *MemFS) resize(node *memNode, size int64) error {
+	current := int64(len(node.data))
+	if size > current && (m.maxFileSize > 0 && size > m.maxFileSize || m.maxSize > 0 && m.size-current+size > m.maxSize) {
+		return syscall.EFBIG
+	}
+
+	if size <= current {
+		node.data = node.data[:size]
+	} else {
+		node.data = append(node.data, make([]byte, size-current)...)
+	}
+
+	m.size += size - current
+	return nil
+}
+
+func (m *MemFS) checkParent(name string) error {
+	parent := m.nodes[path.Dir(name)]
+	if parent == nil {
+		return fs.ErrNotExist
+	}
+	if !parent.mode.IsDir() {
+		return syscall.ENOTDIR
+	}
+	return nil
+}
+
+func (m *MemFS) hasChildren(name string) bool {
+	for child := range m.nodes {
+		if child != name && child != "." && path.Dir(child) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReplace reports whether a file of the mode of `node` may replace `existing`.
+func checkReplace(node, existing interface{ IsDir() bool }) error {
+	if existing.IsDir() && !node.IsDir() {
+		return syscall.EISDIR
+	}
+	if !existing.IsDir() && node.IsDir() {
+		return syscall.ENOTDIR
+	}
+	return nil
+}
+
+func (n *memNode) IsDir() bool {
+	return n.mode.IsDir()
+}
+
+func (n *memNode) info(name string) fs.FileInfo {
+	return &memFileInfo{path.Base(name), int64(len(n.data)), n.mode, n.modTime}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memFileInfo) Sys() interface{}   { return nil }
+
+type memFile struct {
+	fs      *MemFS
+	node    *memNode
+	name    string
+	flag    int
+	offset  int64
+	entries []fs.DirEntry
+	listed  bool
+	closed  bool
+}
+
+func (f *memFile) readable() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY
+}
+
+func (f *memFile) writable() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func (f *memFile) check(op string, allowed bool) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.node.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EISDIR}
+	}
+	if !allowed {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, offset int64) (int, error) {
+	if err := f.check("read", f.readable()); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	f.fs.lock.RLock()
+	defer f.fs.lock.RUnlock()
+
+	if offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.node.data[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.flag&os.O_APPEND != 0 {
+		f.fs.lock.RLock()
+		f.offset = int64(len(f.node.data))
+		f.fs.lock.RUnlock()
+	}
+
+	n, err := f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, offset int64) (int, error) {
+	if err := f.check("write", f.writable()); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	end := offset + int64(len(p))
+	if end < offset {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EFBIG}
+	}
+
+	if end > int64(len(f.node.data)) {
+		if err := f.fs.resize(f.node, end); err != nil {
+			return 0, &fs.PathError{Op: "write", Path: f.name, Err: err}
+		}
+	}
+
+	copy(f.node.data[offset:], p)
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		f.fs.lock.RLock()
+		offset += int64(len(f.node.data))
+		f.fs.lock.RUnlock()
+	}
+
+	if offset < 0 || whence < io.SeekStart || whence > io.SeekEnd {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if err := f.check("truncate", f.writable()); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if err := f.fs.resize(f.node, size); err != nil {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+
+	f.node.modTime = time.Now()
+	return nil
+}
+
+// ReadDir makes directories opened from a MemFS fs.ReadDirFile, as fs.FS requires.
+func (f *memFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	if !f.listed {
+		entries, err := f.fs.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.entries, f.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := f.entries
+		f.entries = f.entries[len(f.entries):]
+		return entries, nil
+	}
+
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(f.entries) {
+		n = len(f.entries)
+	}
+
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	f.fs.lock.RLock()
+	defer f.fs.lock.RUnlock()
+
+	return f.node.info(f.name), nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,383 @@
+package wasm
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// OverlayFS is a copy-on-write VirtualFS: reads fall through to a lower VirtualFS while
+// every modification lands in an in-memory upper layer, leaving the lower one untouched.
+// What the module wrote is available through Upper and Removed once it terminated.
+type OverlayFS struct {
+	lower VirtualFS
+	upper *MemFS
+
+	lock    sync.Mutex
+	removed map[string]bool
+}
+
+// NewOverlayFS returns an overlay over `lower`, its upper layer is created with `options`.
+func NewOverlayFS(lower VirtualFS, options ...MemFSOption) *OverlayFS {
+	return &OverlayFS{
+		lower:   lower,
+		upper:   NewMemFS(options...),
+		removed: map[string]bool{},
+	}
+}
+
+// Upper returns the layer holding the files and directories created or modified through
+// the overlay.
+func (o *OverlayFS) Upper() *MemFS {
+	return o.upper
+}
+
+// Removed returns the sorted names of the lower layer entries removed (or renamed) through
+// the overlay, their content is hidden as well.
+func (o *OverlayFS) Removed() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	names := make([]string, 0, len(o.removed))
+	for name := range o.removed {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (o *OverlayFS) OpenFile(name string, flag int, perm fs.FileMode) (VirtualFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if _, err := o.upper.Stat(name); err == nil {
+		return o.upper.OpenFile(name, flag, perm)
+	}
+
+	info, err := o.lowerStat(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if !isWriteFlag(flag) {
+		if err != nil {
+			return nil, err
+		}
+		return o.lower.OpenFile(name, flag, perm)
+	}
+
+	if err == nil {
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		}
+		if info.IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+		}
+
+		if err := o.copyUp(name, info, flag&os.O_TRUNC == 0); err != nil {
+			return nil, err
+		}
+		return o.upper.OpenFile(name, flag, perm)
+	}
+
+	if flag&os.O_CREATE == 0 {
+		return nil, err
+	}
+
+	if err := o.prepareParent(name); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return o.upper.OpenFile(name, flag, perm)
+}
+
+func (o *OverlayFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.stat(name)
+}
+
+func (o *OverlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.readDir(name)
+}
+
+func (o *OverlayFS) Mkdir(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if _, err := o.stat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+
+	if err := o.prepareParent(name); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return o.upper.Mkdir(name, perm)
+}
+
+func (o *OverlayFS) Remove(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	info, err := o.stat(name)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		entries, err := o.readDir(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+
+	o.removeTree(name)
+	return nil
+}
+
+func (o *OverlayFS) Rename(oldName, newName string) error {
+	if !fs.ValidPath(oldName) || !fs.ValidPath(newName) || oldName == "." || newName == "." || strings.HasPrefix(newName, oldName+"/") {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrInvalid}
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	info, err := o.stat(oldName)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: errors.Unwrap(err)}
+	}
+
+	if oldName == newName {
+		return nil
+	}
+
+	if existing, err := o.stat(newName); err == nil {
+		if err := checkReplace(info, existing); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+		}
+
+		if existing.IsDir() {
+			entries, err := o.readDir(newName)
+			if err != nil {
+				return err
+			}
+			if len(entries) > 0 {
+				return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: syscall.ENOTEMPTY}
+			}
+		}
+		o.removeTree(newName)
+	}
+
+	if err := o.prepareParent(newName); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+
+	if _, err := o.lowerStat(oldName); errors.Is(err, fs.ErrNotExist) {
+		// Only known from the upper layer, it can be moved as is
+		return o.upper.Rename(oldName, newName)
+	}
+
+	if err := o.copyTree(oldName, newName, info); err != nil {
+		return err
+	}
+
+	o.removeTree(oldName)
+	return nil
+}
+
+// hidden returns whether the lower layer entry `name` was removed, or is within a removed
+// directory.
+func (o *OverlayFS) hidden(name string) bool {
+	for {
+		if o.removed[name] {
+			return true
+		}
+		if name == "." {
+			return false
+		}
+		name = path.Dir(name)
+	}
+}
+
+func (o *OverlayFS) lowerStat(name string) (fs.FileInfo, error) {
+	if o.hidden(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return o.lower.Stat(name)
+}
+
+func (o *OverlayFS) stat(name string) (fs.FileInfo, error) {
+	info, err := o.upper.Stat(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return info, err
+	}
+	return o.lowerStat(name)
+}
+
+func (o *OverlayFS) readDir(name string) ([]fs.DirEntry, error) {
+	info, err := o.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	byName := map[string]fs.DirEntry{}
+	if lowerInfo, err := o.lowerStat(name); err == nil && lowerInfo.IsDir() {
+		entries, err := o.lower.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !o.removed[path.Join(name, entry.Name())] {
+				byName[entry.Name()] = entry
+			}
+		}
+	}
+
+	if upperInfo, err := o.upper.Stat(name); err == nil && upperInfo.IsDir() {
+		entries, err := o.upper.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			byName[entry.Name()] = entry
+		}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(byName))
+	for _, entry := range byName {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// prepareParent checks that the parent of `name` is a directory and creates it in the upper
+// layer if it only exists in the lower one.
+func (o *OverlayFS) prepareParent(name string) error {
+	parent := path.Dir(name)
+
+	info, err := o.stat(parent)
+	if err != nil {
+		return fs.ErrNotExist
+	}
+	if !info.IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	return o.upper.MkdirAll(parent, info.Mode().Perm())
+}
+
+func (o *OverlayFS) copyUp(name string, info fs.FileInfo, withContent bool) error {
+	if err := o.prepareParent(name); err != nil {
+		return &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	var data []byte
+	if withContent {
+		file, err := o.lower.OpenFile(name, os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		data, err = io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	return o.upper.WriteFile(name, data, info.Mode().Perm())
+}
+
+func (o *OverlayFS) copyTree(oldName, newName string, info fs.FileInfo) error {
+	if !info.IsDir() {
+		file, err := o.openMerged(oldName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+
+		return o.upper.WriteFile(newName, data, info.Mode().Perm())
+	}
+
+	if err := o.upper.Mkdir(newName, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	entries, err := o.readDir(oldName)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		childInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if err := o.copyTree(path.Join(oldName, entry.Name()), path.Join(newName, entry.Name()), childInfo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *OverlayFS) openMerged(name string) (VirtualFile, error) {
+	if _, err := o.upper.Stat(name); err == nil {
+		return o.upper.OpenFile(name, os.O_RDONLY, 0)
+	}
+	return o.lower.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (o *OverlayFS) removeTree(name string) {
+	o.upper.removeAll(name)
+	if _, err := o.lower.Stat(name); err == nil && !o.hidden(name) {
+		o.removed[name] = true
+	}
+}
@@ -0,0 +1,179 @@
+package wasm
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// NewReadOnlyFS exposes `fsys` as a VirtualFS refusing any modification with
+// ErrReadOnlyFS.
+func NewReadOnlyFS(fsys fs.FS) VirtualFS {
+	return &readOnlyFS{fsys}
+}
+
+// NewTarFS loads the tar archive read from `r` in memory and exposes it as a read-only
+// VirtualFS. Only directories and regular files are kept, links and special files are
+// ignored.
+func NewTarFS(r io.Reader) (VirtualFS, error) {
+	// The archive is provided by the host, not the module, its size is not limited
+	memFS := NewMemFS(WithMemFSMaxFileSize(0), WithMemFSMaxSize(0))
+
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar entry: %w", err)
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid tar entry name %q", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := memFS.MkdirAll(name, header.FileInfo().Mode()); err != nil {
+				return nil, fmt.Errorf("create directory %q: %w", name, err)
+			}
+
+		case tar.TypeReg:
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				return nil, fmt.Errorf("read tar file %q: %w", name, err)
+			}
+
+			if err := memFS.WriteFile(name, data, header.FileInfo().Mode()); err != nil {
+				return nil, fmt.Errorf("create file %q: %w", name, err)
+			}
+			memFS.nodes[name].modTime = header.ModTime
+		}
+	}
+
+	return NewReadOnlyFS(memFS), nil
+}
+
+// NewZipFS exposes the zip archive read from `r` as a read-only VirtualFS, files are
+// decompressed in memory when opened.
+func NewZipFS(r io.ReaderAt, size int64) (VirtualFS, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("read zip archive: %w", err)
+	}
+
+	return NewReadOnlyFS(reader), nil
+}
+
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+func (r *readOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (VirtualFile, error) {
+	if isWriteFlag(flag) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrReadOnlyFS}
+	}
+
+	file, err := r.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	readOnly := &readOnlyFile{File: file, name: name}
+	if info.IsDir() {
+		return readOnly, nil
+	}
+
+	if content, ok := file.(readSeekerAt); ok {
+		readOnly.content = content
+		return readOnly, nil
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	readOnly.content = bytes.NewReader(data)
+	return readOnly, nil
+}
+
+func (r *readOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(r.fsys, name)
+}
+
+func (r *readOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(r.fsys, name)
+}
+
+func (r *readOnlyFS) Mkdir(name string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: ErrReadOnlyFS}
+}
+
+func (r *readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnlyFS}
+}
+
+func (r *readOnlyFS) Rename(oldName, newName string) error {
+	return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: ErrReadOnlyFS}
+}
+
+type readSeekerAt interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+type readOnlyFile struct {
+	fs.File
+	name    string
+	content readSeekerAt
+}
+
+func (f *readOnlyFile) Read(p []byte) (int, error) {
+	if f.content == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	return f.content.Read(p)
+}
+
+func (f *readOnlyFile) ReadAt(p []byte, offset int64) (int, error) {
+	if f.content == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	return f.content.ReadAt(p, offset)
+}
+
+func (f *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	if f.content == nil {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: syscall.EISDIR}
+	}
+	return f.content.Seek(offset, whence)
+}
+
+func (f *readOnlyFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: ErrReadOnlyFS}
+}
+
+func (f *readOnlyFile) WriteAt(p []byte, offset int64) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: ErrReadOnlyFS}
+}
+
+func (f *readOnlyFile) Truncate(size int64) error {
+	return &fs.PathError{Op: "truncate", Path: f.name, Err: ErrReadOnlyFS}
+}
@@ -0,0 +1,130 @@
+package wasm
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemFS(t *testing.T) {
+	memFS := NewMemFS()
+	require.NoError(t, memFS.WriteFile("a/b/c.txt", []byte("hello"), 0644))
+
+	file, err := memFS.OpenFile("a/b/c.txt", os.O_RDWR|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.Write([]byte(" world"))
+	require.NoError(t, err)
+
+	_, err = file.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+	require.NoError(t, file.Close())
+
+	require.NoError(t, memFS.Rename("a/b", "a/d"))
+	require.NoError(t, fstest.TestFS(memFS, "a/d/c.txt"))
+
+	assert.ErrorIs(t, memFS.Remove("a"), syscall.ENOTEMPTY)
+	assert.ErrorIs(t, memFS.Mkdir("x/y", 0755), fs.ErrNotExist)
+	_, err = memFS.OpenFile("a/d/c.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	assert.ErrorIs(t, err, fs.ErrExist)
+}
+
+func TestMemFS_SizeLimits(t *testing.T) {
+	memFS := NewMemFS(WithMemFSMaxFileSize(8), WithMemFSMaxSize(12))
+	require.NoError(t, memFS.WriteFile("a.txt", []byte("12345678"), 0644))
+	assert.ErrorIs(t, memFS.WriteFile("b.txt", []byte("123456789"), 0644), syscall.EFBIG)
+
+	file, err := memFS.OpenFile("c.txt", os.O_RDWR|os.O_CREATE, 0644)
+	require.NoError(t, err)
+	assert.ErrorIs(t, file.Truncate(5), syscall.EFBIG, "total size is limited")
+	require.NoError(t, file.Truncate(4))
+	_, err = file.WriteAt([]byte("x"), 4)
+	assert.ErrorIs(t, err, syscall.EFBIG)
+	require.NoError(t, file.Close())
+
+	require.NoError(t, memFS.Remove("a.txt"))
+	require.NoError(t, memFS.WriteFile("c.txt", []byte("12345678"), 0644))
+	require.NoError(t, memFS.Rename("c.txt", "b.txt"))
+	require.NoError(t, memFS.WriteFile("a.txt", []byte("1234"), 0644))
+
+	unlimited := NewMemFS(WithMemFSMaxFileSize(0), WithMemFSMaxSize(0))
+	require.NoError(t, unlimited.WriteFile("a.txt", make([]byte, DefaultMemFSMaxFileSize+1), 0644))
+}
+
+func TestOverlayFS(t *testing.T) {
+	lower := fstest.MapFS{
+		"etc/config.txt": {Data: []byte("lower")},
+		"etc/table.csv":  {Data: []byte("a,b")},
+		"lib/data.bin":   {Data: []byte{1, 2, 3}},
+	}
+	overlay := NewOverlayFS(NewReadOnlyFS(lower))
+
+	file, err := overlay.OpenFile("etc/config.txt", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.Write([]byte("+upper"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	require.NoError(t, overlay.Remove("etc/table.csv"))
+	assert.ErrorIs(t, overlay.Rename("lib", "usr/lib"), fs.ErrNotExist, "parent of the rename target must exist")
+
+	require.NoError(t, overlay.Mkdir("usr", 0755))
+	require.NoError(t, overlay.Rename("lib", "usr/lib"))
+	require.NoError(t, fstest.TestFS(overlay.Upper(), "etc/config.txt", "usr/lib/data.bin"))
+
+	entries, err := overlay.ReadDir(".")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"etc", "usr"}, dirEntryNames(entries))
+
+	entries, err = overlay.ReadDir("etc")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"config.txt"}, dirEntryNames(entries))
+
+	config, err := overlay.Upper().ReadFile("etc/config.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "lower+upper", string(config))
+	assert.Equal(t, []string{"etc/table.csv", "lib"}, overlay.Removed())
+	assert.Equal(t, "lower", string(lower["etc/config.txt"].Data))
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	archive := &bytes.Buffer{}
+	writer := tar.NewWriter(archive)
+	require.NoError(t, writer.WriteHeader(&tar.Header{Name: "tables/", Typeflag: tar.TypeDir, Mode: 0755}))
+	require.NoError(t, writer.WriteHeader(&tar.Header{Name: "tables/prices.csv", Typeflag: tar.TypeReg, Mode: 0644, Size: 3}))
+	_, err := writer.Write([]byte("1,2"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	tarFS, err := NewTarFS(archive)
+	require.NoError(t, err)
+
+	file, err := tarFS.OpenFile("tables/prices.csv", os.O_RDONLY, 0)
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "1,2", string(content))
+
+	_, err = file.Write([]byte("3"))
+	assert.ErrorIs(t, err, ErrReadOnlyFS)
+	_, err = tarFS.OpenFile("tables/new.csv", os.O_CREATE|os.O_WRONLY, 0644)
+	assert.ErrorIs(t, err, ErrReadOnlyFS)
+	assert.ErrorIs(t, tarFS.Remove("tables/prices.csv"), ErrReadOnlyFS)
+}
+
+func dirEntryNames(entries []fs.DirEntry) (names []string) {
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return
+}
@@ -0,0 +1,197 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+	"go.uber.org/zap"
+)
+
+// WASIConfig configures the WASI environment given to modules built for `wasm32-wasi`.
+type WASIConfig struct {
+	// ProgramName is the first argument (`argv[0]`) seen by the module, defaults to the
+	// wasm file name.
+	ProgramName string
+	Args        []string
+	Env         map[string]string
+
+	// PreopenDirs are host directories made accessible to the module under the same path.
+	PreopenDirs []string
+
+	// MapDirs are host directories (values) made accessible to the module under an alias
+	// (keys).
+	MapDirs map[string]string
+
+	// Mounts are virtual filesystems (values) made accessible to the module under a guest
+	// path (keys). When set, WASI is implemented by the runtime itself so that the module
+	// never reaches the host filesystem, PreopenDirs and MapDirs must then be empty.
+	Mounts map[string]VirtualFS
+
+	// Stdout and Stderr receive what the module writes to its standard output and error,
+	// when nil the stream is inherited from the host process.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// WithWASI provides the `wasi_snapshot_preview1` imports to the module, configured by
+// `config`.
+func WithWASI(config WASIConfig) RuntimeOption {
+	return func(r *Runtime) {
+		r.wasi = &config
+	}
+}
+
+// ProcExitError is returned when the module terminated its execution by calling
+// `proc_exit`.
+type ProcExitError struct {
+	Code int32
+}
+
+func (e *ProcExitError) Error() string {
+	return fmt.Sprintf("wasi proc_exit with code %d", e.Code)
+}
+
+// wasiProvider gives the `wasi_snapshot_preview1` imports to a module, either through
+// wasmer or through the runtime's own virtual filesystem implementation.
+type wasiProvider interface {
+	importObject(store *wasmer.Store, module *wasmer.Module, host *hostContext) (*wasmer.ImportObject, error)
+	bind(memory *wasmer.Memory)
+	flush() error
+
+	// snapshot captures the descriptors open in the WASI state, restored by reset (see
+	// Instance.Snapshot).
+	snapshot() error
+	reset()
+}
+
+func newWASIProvider(config *WASIConfig, programName string, logger *zap.Logger) (wasiProvider, error) {
+	if len(config.Mounts) > 0 {
+		return newVirtualWASI(config, programName, logger)
+	}
+	return newWASIEnvironment(config, programName)
+}
+
+type wasiEnvironment struct {
+	config *WASIConfig
+	env    *wasmer.WasiEnvironment
+}
+
+func newWASIEnvironment(config *WASIConfig, programName string) (*wasiEnvironment, error) {
+	if config.ProgramName != "" {
+		programName = config.ProgramName
+	}
+
+	builder := wasmer.NewWasiStateBuilder(programName)
+	for _, arg := range config.Args {
+		builder.Argument(arg)
+	}
+
+	keys := make([]string, 0, len(config.Env))
+	for key := range config.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		builder.Environment(key, config.Env[key])
+	}
+
+	for _, dir := range config.PreopenDirs {
+		builder.PreopenDirectory(dir)
+	}
+
+	aliases := make([]string, 0, len(config.MapDirs))
+	for alias := range config.MapDirs {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		builder.MapDirectory(alias, config.MapDirs[alias])
+	}
+
+	if config.Stdout != nil {
+		builder.CaptureStdout()
+	} else {
+		builder.InheritStdout()
+	}
+
+	if config.Stderr != nil {
+		builder.CaptureStderr()
+	} else {
+		builder.InheritStderr()
+	}
+
+	env, err := builder.Finalize()
+	if err != nil {
+		return nil, fmt.Errorf("finalize wasi state: %w", err)
+	}
+
+	return &wasiEnvironment{config, env}, nil
+}
+
+func (e *wasiEnvironment) importObject(store *wasmer.Store, module *wasmer.Module, host *hostContext) (*wasmer.ImportObject, error) {
+	importObject, err := e.env.GenerateImportObject(store, module)
+	if err != nil {
+		return nil, fmt.Errorf("generate wasi imports: %w", err)
+	}
+
+	return importObject, nil
+}
+
+func (e *wasiEnvironment) bind(memory *wasmer.Memory) {}
+
+// snapshot fails when the module has access to host directories: the descriptors it opens
+// are held by wasmer, they could not be closed on reset.
+func (e *wasiEnvironment) snapshot() error {
+	if len(e.config.PreopenDirs) > 0 || len(e.config.MapDirs) > 0 {
+		return errors.New("the wasi state of modules given host directories cannot be restored, use Mounts instead")
+	}
+	return nil
+}
+
+func (e *wasiEnvironment) reset() {}
+
+// flush copies the captured standard output and error of the module to the configured
+// writers.
+func (e *wasiEnvironment) flush() error {
+	if e.config.Stdout != nil {
+		if _, err := e.config.Stdout.Write(e.env.ReadStdout()); err != nil {
+			return fmt.Errorf("write wasi stdout: %w", err)
+		}
+	}
+
+	if e.config.Stderr != nil {
+		if _, err := e.config.Stderr.Write(e.env.ReadStderr()); err != nil {
+			return fmt.Errorf("write wasi stderr: %w", err)
+		}
+	}
+
+	return nil
+}
+
+var procExitRegex = regexp.MustCompile(`^WASI exited with code: (-?\d+)`)
+
+// asProcExitError turns the trap raised by wasmer when the module calls `proc_exit` into a
+// ProcExitError, other errors are returned as is.
+func asProcExitError(err error) error {
+	var trapErr *wasmer.TrapError
+	if !errors.As(err, &trapErr) {
+		return err
+	}
+
+	matches := procExitRegex.FindStringSubmatch(trapErr.Error())
+	if matches == nil {
+		return err
+	}
+
+	code, parseErr := strconv.ParseInt(matches[1], 10, 32)
+	if parseErr != nil {
+		return err
+	}
+
+	return &ProcExitError{Code: int32(code)}
+}
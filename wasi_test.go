@@ -0,0 +1,215 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const wasiTestModule = `
+(module
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "environ_sizes_get" (func $environ_sizes_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "out\n")
+  (data (i32.const 1032) "err\n")
+
+  (func $write (param $fd i32) (param $ptr i32)
+    (i32.store (i32.const 2048) (local.get $ptr))
+    (i32.store (i32.const 2052) (i32.const 4))
+    (drop (call $fd_write (local.get $fd) (i32.const 2048) (i32.const 1) (i32.const 2056))))
+
+  (func (export "run") (param $code i32) (result i32)
+    (call $write (i32.const 1) (i32.const 1024))
+    (call $write (i32.const 2) (i32.const 1032))
+    (if (local.get $code) (then (call $proc_exit (local.get $code))))
+    (drop (call $environ_sizes_get (i32.const 2064) (i32.const 2068)))
+    (i32.load (i32.const 2064))))
+`
+
+func TestRuntime_WASI(t *testing.T) {
+	wasmFile := writeTestModule(t, wasiTestModule)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	runtime := NewRuntime(&RustEnvironment{}, WithWASI(WASIConfig{
+		Env:    map[string]string{"A": "1", "B": "2"},
+		Stdout: stdout,
+		Stderr: stderr,
+	}))
+
+	actual, err := runtime.Execute(wasmFile, "run", []interface{}{int32(0)})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), actual.Value)
+	assert.Equal(t, "out\n", stdout.String())
+	assert.Equal(t, "err\n", stderr.String())
+
+	_, err = runtime.Execute(wasmFile, "run", []interface{}{int32(3)})
+	var exitErr *ProcExitError
+	require.True(t, errors.As(err, &exitErr), "expected a ProcExitError, got %v", err)
+	assert.Equal(t, int32(3), exitErr.Code)
+	assert.Equal(t, "out\nout\n", stdout.String())
+}
+
+const wasiFilesystemTestModule = `
+(module
+  (import "wasi_snapshot_preview1" "path_open" (func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "path_create_directory" (func $path_create_directory (param i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_close" (func $fd_close (param i32) (result i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "config.txt")
+  (data (i32.const 1040) "out/result.txt")
+  (data (i32.const 1056) "written")
+  (data (i32.const 1072) "../escape")
+
+  (func (export "run") (result i32)
+    (local $errors i32)
+
+    ;; Copy config.txt to stdout
+    (local.set $errors (call $path_open (i32.const 3) (i32.const 0) (i32.const 1024) (i32.const 10) (i32.const 0) (i64.const 2) (i64.const 0) (i32.const 0) (i32.const 2000)))
+    (i32.store (i32.const 2008) (i32.const 4096))
+    (i32.store (i32.const 2012) (i32.const 256))
+    (local.set $errors (i32.add (local.get $errors) (call $fd_read (i32.load (i32.const 2000)) (i32.const 2008) (i32.const 1) (i32.const 2016))))
+    (i32.store (i32.const 2024) (i32.const 4096))
+    (i32.store (i32.const 2028) (i32.load (i32.const 2016)))
+    (local.set $errors (i32.add (local.get $errors) (call $fd_write (i32.const 1) (i32.const 2024) (i32.const 1) (i32.const 2032))))
+
+    ;; Write out/result.txt
+    (local.set $errors (i32.add (local.get $errors) (call $path_create_directory (i32.const 3) (i32.const 1040) (i32.const 3))))
+    (local.set $errors (i32.add (local.get $errors) (call $path_open (i32.const 3) (i32.const 0) (i32.const 1040) (i32.const 14) (i32.const 9) (i64.const 64) (i64.const 0) (i32.const 0) (i32.const 2004))))
+    (i32.store (i32.const 2040) (i32.const 1056))
+    (i32.store (i32.const 2044) (i32.const 7))
+    (local.set $errors (i32.add (local.get $errors) (call $fd_write (i32.load (i32.const 2004)) (i32.const 2040) (i32.const 1) (i32.const 2048))))
+    (local.set $errors (i32.add (local.get $errors) (call $fd_close (i32.load (i32.const 2004)))))
+
+    ;; Escaping the mount is refused
+    (i32.add
+      (i32.mul (local.get $errors) (i32.const 1000))
+      (call $path_open (i32.const 3) (i32.const 0) (i32.const 1072) (i32.const 9) (i32.const 0) (i64.const 2) (i64.const 0) (i32.const 0) (i32.const 2000)))))
+`
+
+func TestRuntime_WASIVirtualFS(t *testing.T) {
+	wasmFile := writeTestModule(t, wasiFilesystemTestModule)
+
+	lower := NewMemFS()
+	require.NoError(t, lower.WriteFile("config.txt", []byte("key=value\n"), 0644))
+	overlay := NewOverlayFS(NewReadOnlyFS(lower))
+
+	stdout := &bytes.Buffer{}
+	runtime := NewRuntime(&RustEnvironment{}, WithWASI(WASIConfig{
+		Mounts: map[string]VirtualFS{"/data": overlay},
+		Stdout: stdout,
+	}))
+
+	actual, err := runtime.Execute(wasmFile, "run", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(wasiErrnoNotcapable), actual.Value)
+	assert.Equal(t, "key=value\n", stdout.String())
+
+	written, err := overlay.Upper().ReadFile("out/result.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "written", string(written))
+
+	_, err = lower.Stat("out")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestRuntime_WASIVirtualFSProcExit(t *testing.T) {
+	wasmFile := writeTestModule(t, wasiTestModule)
+
+	stdout := &bytes.Buffer{}
+	runtime := NewRuntime(&RustEnvironment{}, WithWASI(WASIConfig{
+		Env:    map[string]string{"A": "1"},
+		Mounts: map[string]VirtualFS{"/": NewMemFS()},
+		Stdout: stdout,
+		Stderr: io.Discard,
+	}))
+
+	actual, err := runtime.Execute(wasmFile, "run", []interface{}{int32(0)})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), actual.Value)
+
+	_, err = runtime.Execute(wasmFile, "run", []interface{}{int32(4)})
+	var exitErr *ProcExitError
+	require.True(t, errors.As(err, &exitErr), "expected a ProcExitError, got %v", err)
+	assert.Equal(t, int32(4), exitErr.Code)
+	assert.Equal(t, "out\nout\n", stdout.String())
+}
+
+const wasiFileSizeTestModule = `
+(module
+  (import "wasi_snapshot_preview1" "path_open" (func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_pwrite" (func $fd_pwrite (param i32 i32 i32 i64 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_filestat_set_size" (func $fd_filestat_set_size (param i32 i64) (result i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "big.bin")
+
+  (func (export "run") (param $offset i64) (param $size i64) (result i32)
+    (drop (call $path_open (i32.const 3) (i32.const 0) (i32.const 1024) (i32.const 7) (i32.const 1) (i64.const -1) (i64.const 0) (i32.const 0) (i32.const 2000)))
+    (i32.store (i32.const 2008) (i32.const 1024))
+    (i32.store (i32.const 2012) (i32.const 7))
+    (i32.add
+      (i32.mul (call $fd_pwrite (i32.load (i32.const 2000)) (i32.const 2008) (i32.const 1) (local.get $offset) (i32.const 2016)) (i32.const 100))
+      (call $fd_filestat_set_size (i32.load (i32.const 2000)) (local.get $size)))))
+`
+
+const wasiOpenFilesTestModule = `
+(module
+  (import "wasi_snapshot_preview1" "path_open" (func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 1024) "file")
+
+  (func (export "run") (result i32)
+    (local $count i32)
+    (local $errno i32)
+    (block $done
+      (loop $open
+        (local.set $errno (call $path_open (i32.const 3) (i32.const 0) (i32.const 1024) (i32.const 4) (i32.const 1) (i64.const 2) (i64.const 0) (i32.const 0) (i32.const 2000)))
+        (br_if $done (local.get $errno))
+        (local.set $count (i32.add (local.get $count) (i32.const 1)))
+        (br $open)))
+    (i32.add (i32.mul (local.get $count) (i32.const 100)) (local.get $errno))))
+`
+
+func TestRuntime_WASIVirtualFSOpenFiles(t *testing.T) {
+	runtime := NewRuntime(&RustEnvironment{}, WithWASI(WASIConfig{
+		Mounts: map[string]VirtualFS{"/": NewMemFS()},
+	}))
+
+	actual, err := runtime.Execute(writeTestModule(t, wasiOpenFilesTestModule), "run", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(wasiMaxOpenFDs-1)*100+int32(wasiErrnoMfile), actual.Value)
+}
+
+func TestRuntime_WASIVirtualFSSizeLimits(t *testing.T) {
+	wasmFile := writeTestModule(t, wasiFileSizeTestModule)
+
+	memFS := NewMemFS(WithMemFSMaxFileSize(1024))
+	runtime := NewRuntime(&RustEnvironment{}, WithWASI(WASIConfig{
+		Mounts: map[string]VirtualFS{"/": memFS},
+	}))
+
+	actual, err := runtime.Execute(wasmFile, "run", []interface{}{int64(0), int64(512)})
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), actual.Value)
+
+	actual, err = runtime.Execute(wasmFile, "run", []interface{}{int64(1 << 40), int64(1 << 40)})
+	require.NoError(t, err)
+	assert.Equal(t, int32(wasiErrnoFbig)*100+int32(wasiErrnoFbig), actual.Value)
+
+	actual, err = runtime.Execute(wasmFile, "run", []interface{}{int64(math.MaxInt64), int64(0)})
+	require.NoError(t, err)
+	assert.Equal(t, int32(wasiErrnoFbig)*100, actual.Value)
+
+	info, err := memFS.Stat("big.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+}
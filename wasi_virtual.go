@@ -0,0 +1,1029 @@
+package wasm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+	"go.uber.org/zap"
+)
+
+const wasiModule = "wasi_snapshot_preview1"
+
+type wasiErrno int32
+
+const (
+	wasiErrnoSuccess    wasiErrno = 0
+	wasiErrnoBadf       wasiErrno = 8
+	wasiErrnoExist      wasiErrno = 20
+	wasiErrnoFault      wasiErrno = 21
+	wasiErrnoFbig       wasiErrno = 22
+	wasiErrnoInval      wasiErrno = 28
+	wasiErrnoIO         wasiErrno = 29
+	wasiErrnoIsdir      wasiErrno = 31
+	wasiErrnoMfile      wasiErrno = 33
+	wasiErrnoNoent      wasiErrno = 44
+	wasiErrnoNosys      wasiErrno = 52
+	wasiErrnoNotdir     wasiErrno = 54
+	wasiErrnoNotempty   wasiErrno = 55
+	wasiErrnoNotsup     wasiErrno = 58
+	wasiErrnoPerm       wasiErrno = 63
+	wasiErrnoRofs       wasiErrno = 69
+	wasiErrnoSpipe      wasiErrno = 70
+	wasiErrnoXdev       wasiErrno = 75
+	wasiErrnoNotcapable wasiErrno = 76
+)
+
+const (
+	wasiFiletypeCharacterDevice uint8 = 2
+	wasiFiletypeDirectory       uint8 = 3
+	wasiFiletypeRegularFile     uint8 = 4
+)
+
+const (
+	wasiOflagCreat     = 1 << 0
+	wasiOflagDirectory = 1 << 1
+	wasiOflagExcl      = 1 << 2
+	wasiOflagTrunc     = 1 << 3
+
+	wasiFdflagAppend = 1 << 0
+
+	wasiRightFdRead  = 1 << 1
+	wasiRightFdWrite = 1 << 6
+	wasiRightsAll    = 1<<29 - 1
+)
+
+const (
+	wasiClockRealtime = iota
+	wasiClockMonotonic
+	wasiClockProcessCPUTime
+	wasiClockThreadCPUTime
+)
+
+// wasiMaxOpenFDs is the number of descriptors a module can have open at once, preopened
+// directories included.
+const wasiMaxOpenFDs = 1024
+
+// virtualWASI implements `wasi_snapshot_preview1` in Go, giving the module access to the
+// VirtualFS mounts of the configuration only.
+type virtualWASI struct {
+	args   []string
+	env    []string
+	stdout io.Writer
+	stderr io.Writer
+	start  time.Time
+	memory *wasmer.Memory
+	logger *zap.Logger
+
+	fds    map[int32]*wasiFD
+	nextFD int32
+
+	// snapshotFDs and snapshotNextFD are the descriptors restored by reset.
+	snapshotFDs    map[int32]*wasiFD
+	snapshotNextFD int32
+}
+
+type wasiFD struct {
+	fs      VirtualFS
+	name    string
+	preopen string
+	dir     bool
+	append  bool
+	file    VirtualFile
+}
+
+func newVirtualWASI(config *WASIConfig, programName string, logger *zap.Logger) (*virtualWASI, error) {
+	if len(config.PreopenDirs) > 0 || len(config.MapDirs) > 0 {
+		return nil, errors.New("host directories cannot be combined with virtual filesystem mounts")
+	}
+
+	if config.ProgramName != "" {
+		programName = config.ProgramName
+	}
+
+	w := &virtualWASI{
+		args:   append([]string{programName}, config.Args...),
+		stdout: config.Stdout,
+		stderr: config.Stderr,
+		start:  time.Now(),
+		logger: logger,
+		fds:    map[int32]*wasiFD{},
+		nextFD: 3,
+	}
+
+	if w.stdout == nil {
+		w.stdout = os.Stdout
+	}
+	if w.stderr == nil {
+		w.stderr = os.Stderr
+	}
+
+	for key, value := range config.Env {
+		w.env = append(w.env, key+"="+value)
+	}
+	sort.Strings(w.env)
+
+	guestPaths := make([]string, 0, len(config.Mounts))
+	for guestPath := range config.Mounts {
+		guestPaths = append(guestPaths, guestPath)
+	}
+	sort.Strings(guestPaths)
+
+	for _, guestPath := range guestPaths {
+		if config.Mounts[guestPath] == nil {
+			return nil, fmt.Errorf("mount %q has no filesystem", guestPath)
+		}
+
+		w.open(&wasiFD{fs: config.Mounts[guestPath], name: ".", preopen: guestPath, dir: true})
+	}
+
+	return w, nil
+}
+
+func (w *virtualWASI) importObject(store *wasmer.Store, module *wasmer.Module, host *hostContext) (*wasmer.ImportObject, error) {
+	namespace := map[string]wasmer.IntoExtern{}
+	for _, importType := range module.Imports() {
+		if importType.Module() != wasiModule || importType.Type().Kind() != wasmer.FUNCTION {
+			continue
+		}
+
+		name := importType.Name()
+		function, found := wasiFunctions[name]
+		if !found {
+			w.logger.Debug("wasi function not supported by the virtual filesystem implementation", zap.String("name", name))
+			function = unsupportedWASIFunction(name, importType.Type().IntoFunctionType())
+		}
+
+		namespace[name] = wasmer.NewFunctionWithEnvironment(store, function.functionType(), host, func(env interface{}, args []wasmer.Value) ([]wasmer.Value, error) {
+			out, err := function.call(w, args)
+			if err != nil {
+				return env.(*hostContext).fail(err, function.results), nil
+			}
+			return out, nil
+		})
+	}
+
+	importObject := wasmer.NewImportObject()
+	importObject.Register(wasiModule, namespace)
+	return importObject, nil
+}
+
+func (w *virtualWASI) bind(memory *wasmer.Memory) {
+	w.memory = memory
+}
+
+func (w *virtualWASI) flush() error {
+	return nil
+}
+
+// snapshot records the descriptors open in w, restored by reset. Only directories may be
+// open then: the position of a file cannot be restored.
+func (w *virtualWASI) snapshot() error {
+	for _, fd := range w.fds {
+		if fd.file != nil {
+			return fmt.Errorf("file %q is open, open files cannot be restored", fd.name)
+		}
+	}
+
+	w.snapshotFDs, w.snapshotNextFD = copyFDs(w.fds), w.nextFD
+	return nil
+}
+
+// reset closes the files opened since the snapshot and restores its descriptors.
+func (w *virtualWASI) reset() {
+	for id, fd := range w.fds {
+		if _, found := w.snapshotFDs[id]; !found && fd.file != nil {
+			if err := fd.file.Close(); err != nil {
+				w.logger.Debug("unable to close file on reset", zap.String("name", fd.name), zap.Error(err))
+			}
+		}
+	}
+
+	w.fds, w.nextFD = copyFDs(w.snapshotFDs), w.snapshotNextFD
+}
+
+func copyFDs(fds map[int32]*wasiFD) map[int32]*wasiFD {
+	out := make(map[int32]*wasiFD, len(fds))
+	for id, fd := range fds {
+		copied := *fd
+		out[id] = &copied
+	}
+	return out
+}
+
+func (w *virtualWASI) open(fd *wasiFD) int32 {
+	id := w.nextFD
+	w.fds[id] = fd
+	w.nextFD++
+	return id
+}
+
+func (w *virtualWASI) dirFD(id int32) (*wasiFD, wasiErrno) {
+	fd, found := w.fds[id]
+	if !found {
+		return nil, wasiErrnoBadf
+	}
+	if !fd.dir {
+		return nil, wasiErrnoNotdir
+	}
+	return fd, wasiErrnoSuccess
+}
+
+func (w *virtualWASI) fileFD(id int32) (*wasiFD, wasiErrno) {
+	fd, found := w.fds[id]
+	if !found {
+		return nil, wasiErrnoBadf
+	}
+	if fd.dir {
+		return nil, wasiErrnoIsdir
+	}
+	return fd, wasiErrnoSuccess
+}
+
+// resolve returns the name, within the filesystem of `dir`, of the path found in memory,
+// refusing paths escaping the mount.
+func (w *virtualWASI) resolve(dir *wasiFD, ptr, length int32) (string, wasiErrno) {
+	raw, ok := w.slice(ptr, length)
+	if !ok {
+		return "", wasiErrnoFault
+	}
+
+	if bytes.HasPrefix(raw, []byte("/")) {
+		return "", wasiErrnoNotcapable
+	}
+
+	name := path.Join(dir.name, string(raw))
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", wasiErrnoNotcapable
+	}
+
+	return name, wasiErrnoSuccess
+}
+
+func (w *virtualWASI) slice(ptr, length int32) ([]byte, bool) {
+	out, err := memorySegment(w.memory.Data(), ptr, length)
+	return out, err == nil
+}
+
+func (w *virtualWASI) putU32(ptr int32, value uint32) bool {
+	out, ok := w.slice(ptr, 4)
+	if ok {
+		encoding.PutUint32(out, value)
+	}
+	return ok
+}
+
+func (w *virtualWASI) putU64(ptr int32, value uint64) bool {
+	out, ok := w.slice(ptr, 8)
+	if ok {
+		encoding.PutUint64(out, value)
+	}
+	return ok
+}
+
+func (w *virtualWASI) iovecs(ptr, count int32) ([][]byte, bool) {
+	if count < 0 || count > math.MaxInt32/8 {
+		return nil, false
+	}
+
+	vectors, ok := w.slice(ptr, count*8)
+	if !ok {
+		return nil, false
+	}
+
+	out := make([][]byte, count)
+	for i := range out {
+		buf, ok := w.slice(int32(encoding.Uint32(vectors[i*8:])), int32(encoding.Uint32(vectors[i*8+4:])))
+		if !ok {
+			return nil, false
+		}
+		out[i] = buf
+	}
+	return out, true
+}
+
+func (w *virtualWASI) putStrings(values []string, ptrs, buf int32) wasiErrno {
+	for i, value := range values {
+		out, ok := w.slice(buf, int32(len(value)+1))
+		if !ok || !w.putU32(ptrs+int32(i)*4, uint32(buf)) {
+			return wasiErrnoFault
+		}
+
+		copy(out, value)
+		out[len(value)] = 0
+		buf += int32(len(value) + 1)
+	}
+	return wasiErrnoSuccess
+}
+
+func (w *virtualWASI) putStringSizes(values []string, countPtr, sizePtr int32) wasiErrno {
+	size := 0
+	for _, value := range values {
+		size += len(value) + 1
+	}
+
+	if !w.putU32(countPtr, uint32(len(values))) || !w.putU32(sizePtr, uint32(size)) {
+		return wasiErrnoFault
+	}
+	return wasiErrnoSuccess
+}
+
+func (w *virtualWASI) putFilestat(ptr int32, fd *wasiFD, name string, info fs.FileInfo) wasiErrno {
+	out, ok := w.slice(ptr, 64)
+	if !ok {
+		return wasiErrnoFault
+	}
+
+	for i := range out {
+		out[i] = 0
+	}
+
+	if info == nil {
+		out[16] = wasiFiletypeCharacterDevice
+		return wasiErrnoSuccess
+	}
+
+	filetype := wasiFiletypeRegularFile
+	if info.IsDir() {
+		filetype = wasiFiletypeDirectory
+	}
+
+	modTime := uint64(info.ModTime().UnixNano())
+	encoding.PutUint64(out[8:], wasiInode(fd, name))
+	out[16] = filetype
+	encoding.PutUint64(out[24:], 1)
+	encoding.PutUint64(out[32:], uint64(info.Size()))
+	encoding.PutUint64(out[40:], modTime)
+	encoding.PutUint64(out[48:], modTime)
+	encoding.PutUint64(out[56:], modTime)
+	return wasiErrnoSuccess
+}
+
+// wasiInode derives a stable, non-zero, inode number from the name of a file, some libc
+// implementations skip directory entries with an inode of 0.
+func wasiInode(fd *wasiFD, name string) uint64 {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%p:%s", fd.fs, name)
+	return hash.Sum64() | 1
+}
+
+func wasiErrnoOf(err error) wasiErrno {
+	switch {
+	case err == nil:
+		return wasiErrnoSuccess
+	case errors.Is(err, ErrReadOnlyFS):
+		return wasiErrnoRofs
+	case errors.Is(err, syscall.ENOTEMPTY):
+		return wasiErrnoNotempty
+	case errors.Is(err, syscall.ENOTDIR):
+		return wasiErrnoNotdir
+	case errors.Is(err, syscall.EISDIR):
+		return wasiErrnoIsdir
+	case errors.Is(err, syscall.EFBIG):
+		return wasiErrnoFbig
+	case errors.Is(err, fs.ErrNotExist):
+		return wasiErrnoNoent
+	case errors.Is(err, fs.ErrExist):
+		return wasiErrnoExist
+	case errors.Is(err, fs.ErrPermission):
+		return wasiErrnoPerm
+	case errors.Is(err, fs.ErrInvalid):
+		return wasiErrnoInval
+	case errors.Is(err, fs.ErrClosed):
+		return wasiErrnoBadf
+	}
+	return wasiErrnoIO
+}
+
+type wasiFunction struct {
+	params  []wasmer.ValueKind
+	results []wasmer.ValueKind
+	call    func(w *virtualWASI, args []wasmer.Value) ([]wasmer.Value, error)
+}
+
+func (f wasiFunction) functionType() *wasmer.FunctionType {
+	return wasmer.NewFunctionType(wasmer.NewValueTypes(f.params...), wasmer.NewValueTypes(f.results...))
+}
+
+// wasiCall defines a function returning an errno, as almost all WASI functions do.
+func wasiCall(f func(w *virtualWASI, args []wasmer.Value) wasiErrno, params ...wasmer.ValueKind) wasiFunction {
+	return wasiFunction{params, []wasmer.ValueKind{wasmer.I32}, func(w *virtualWASI, args []wasmer.Value) ([]wasmer.Value, error) {
+		return []wasmer.Value{wasmer.NewI32(int32(f(w, args)))}, nil
+	}}
+}
+
+func unsupportedWASIFunction(name string, functionType *wasmer.FunctionType) wasiFunction {
+	var params, results []wasmer.ValueKind
+	for _, param := range functionType.Params() {
+		params = append(params, param.Kind())
+	}
+	for _, result := range functionType.Results() {
+		results = append(results, result.Kind())
+	}
+
+	return wasiFunction{params, results, func(w *virtualWASI, args []wasmer.Value) ([]wasmer.Value, error) {
+		if len(results) == 1 && results[0] == wasmer.I32 {
+			return []wasmer.Value{wasmer.NewI32(int32(wasiErrnoNosys))}, nil
+		}
+		return nil, fmt.Errorf("wasi function %q is not supported", name)
+	}}
+}
+
+var wasiFunctions = map[string]wasiFunction{
+	"args_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		return w.putStrings(w.args, args[0].I32(), args[1].I32())
+	}, wasmer.I32, wasmer.I32),
+
+	"args_sizes_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		return w.putStringSizes(w.args, args[0].I32(), args[1].I32())
+	}, wasmer.I32, wasmer.I32),
+
+	"environ_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		return w.putStrings(w.env, args[0].I32(), args[1].I32())
+	}, wasmer.I32, wasmer.I32),
+
+	"environ_sizes_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		return w.putStringSizes(w.env, args[0].I32(), args[1].I32())
+	}, wasmer.I32, wasmer.I32),
+
+	"clock_res_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		if args[0].I32() < wasiClockRealtime || args[0].I32() > wasiClockThreadCPUTime {
+			return wasiErrnoInval
+		}
+		if !w.putU64(args[1].I32(), 1) {
+			return wasiErrnoFault
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32),
+
+	"clock_time_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		var now uint64
+		switch args[0].I32() {
+		case wasiClockRealtime:
+			now = uint64(time.Now().UnixNano())
+		case wasiClockMonotonic, wasiClockProcessCPUTime, wasiClockThreadCPUTime:
+			now = uint64(time.Since(w.start).Nanoseconds())
+		default:
+			return wasiErrnoInval
+		}
+
+		if !w.putU64(args[2].I32(), now) {
+			return wasiErrnoFault
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I64, wasmer.I32),
+
+	"random_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		out, ok := w.slice(args[0].I32(), args[1].I32())
+		if !ok {
+			return wasiErrnoFault
+		}
+		if _, err := rand.Read(out); err != nil {
+			return wasiErrnoIO
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32),
+
+	"sched_yield": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		return wasiErrnoSuccess
+	}),
+
+	"proc_exit": {[]wasmer.ValueKind{wasmer.I32}, nil, func(w *virtualWASI, args []wasmer.Value) ([]wasmer.Value, error) {
+		return nil, &ProcExitError{Code: args[0].I32()}
+	}},
+
+	"fd_write": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		vectors, ok := w.iovecs(args[1].I32(), args[2].I32())
+		if !ok {
+			return wasiErrnoFault
+		}
+
+		var writer io.Writer
+		switch args[0].I32() {
+		case 1:
+			writer = w.stdout
+		case 2:
+			writer = w.stderr
+		default:
+			fd, errno := w.fileFD(args[0].I32())
+			if errno != wasiErrnoSuccess {
+				return errno
+			}
+			writer = fd.file
+		}
+
+		written := 0
+		for _, vector := range vectors {
+			n, err := writer.Write(vector)
+			written += n
+			if err != nil {
+				return wasiErrnoOf(err)
+			}
+		}
+
+		if !w.putU32(args[3].I32(), uint32(written)) {
+			return wasiErrnoFault
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32),
+
+	"fd_read": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		vectors, ok := w.iovecs(args[1].I32(), args[2].I32())
+		if !ok {
+			return wasiErrnoFault
+		}
+
+		read := 0
+		if args[0].I32() != 0 {
+			fd, errno := w.fileFD(args[0].I32())
+			if errno != wasiErrnoSuccess {
+				return errno
+			}
+
+			for _, vector := range vectors {
+				n, err := fd.file.Read(vector)
+				read += n
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return wasiErrnoOf(err)
+				}
+				if n < len(vector) {
+					break
+				}
+			}
+		}
+
+		if !w.putU32(args[3].I32(), uint32(read)) {
+			return wasiErrnoFault
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32),
+
+	"fd_pread": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		fd, errno := w.fileFD(args[0].I32())
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		vectors, ok := w.iovecs(args[1].I32(), args[2].I32())
+		if !ok {
+			return wasiErrnoFault
+		}
+
+		offset, read := args[3].I64(), 0
+		for _, vector := range vectors {
+			n, err := fd.file.ReadAt(vector, offset)
+			read += n
+			offset += int64(n)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return wasiErrnoOf(err)
+			}
+		}
+
+		if !w.putU32(args[4].I32(), uint32(read)) {
+			return wasiErrnoFault
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I64, wasmer.I32),
+
+	"fd_pwrite": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		fd, errno := w.fileFD(args[0].I32())
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		vectors, ok := w.iovecs(args[1].I32(), args[2].I32())
+		if !ok {
+			return wasiErrnoFault
+		}
+
+		offset, written := args[3].I64(), 0
+		for _, vector := range vectors {
+			n, err := fd.file.WriteAt(vector, offset)
+			written += n
+			offset += int64(n)
+			if err != nil {
+				return wasiErrnoOf(err)
+			}
+		}
+
+		if !w.putU32(args[4].I32(), uint32(written)) {
+			return wasiErrnoFault
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I64, wasmer.I32),
+
+	"fd_seek": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		if args[0].I32() <= 2 && args[0].I32() >= 0 {
+			return wasiErrnoSpipe
+		}
+
+		fd, errno := w.fileFD(args[0].I32())
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		offset, err := fd.file.Seek(args[1].I64(), int(args[2].I32()))
+		if err != nil {
+			return wasiErrnoOf(err)
+		}
+
+		if !w.putU64(args[3].I32(), uint64(offset)) {
+			return wasiErrnoFault
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I64, wasmer.I32, wasmer.I32),
+
+	"fd_tell": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		if args[0].I32() <= 2 && args[0].I32() >= 0 {
+			return wasiErrnoSpipe
+		}
+
+		fd, errno := w.fileFD(args[0].I32())
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		offset, err := fd.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return wasiErrnoOf(err)
+		}
+
+		if !w.putU64(args[1].I32(), uint64(offset)) {
+			return wasiErrnoFault
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32),
+
+	"fd_close": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		id := args[0].I32()
+		if id <= 2 && id >= 0 {
+			return wasiErrnoSuccess
+		}
+
+		fd, found := w.fds[id]
+		if !found {
+			return wasiErrnoBadf
+		}
+
+		delete(w.fds, id)
+		if fd.file != nil {
+			return wasiErrnoOf(fd.file.Close())
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32),
+
+	"fd_sync": wasiCall(wasiNoopOnFD, wasmer.I32),
+
+	"fd_datasync": wasiCall(wasiNoopOnFD, wasmer.I32),
+
+	"fd_advise": wasiCall(wasiNoopOnFD, wasmer.I32, wasmer.I64, wasmer.I64, wasmer.I32),
+
+	"fd_fdstat_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		filetype, flags := wasiFiletypeCharacterDevice, uint16(0)
+		if id := args[0].I32(); id < 0 || id > 2 {
+			fd, found := w.fds[id]
+			if !found {
+				return wasiErrnoBadf
+			}
+
+			filetype = wasiFiletypeRegularFile
+			if fd.dir {
+				filetype = wasiFiletypeDirectory
+			}
+			if fd.append {
+				flags = wasiFdflagAppend
+			}
+		}
+
+		out, ok := w.slice(args[1].I32(), 24)
+		if !ok {
+			return wasiErrnoFault
+		}
+
+		for i := range out {
+			out[i] = 0
+		}
+		out[0] = filetype
+		encoding.PutUint16(out[2:], flags)
+		encoding.PutUint64(out[8:], wasiRightsAll)
+		encoding.PutUint64(out[16:], wasiRightsAll)
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32),
+
+	"fd_fdstat_set_flags": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		if errno := wasiNoopOnFD(w, args); errno != wasiErrnoSuccess {
+			return errno
+		}
+		if args[1].I32() != 0 {
+			return wasiErrnoNotsup
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32),
+
+	"fd_filestat_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		id := args[0].I32()
+		if id <= 2 && id >= 0 {
+			return w.putFilestat(args[1].I32(), nil, "", nil)
+		}
+
+		fd, found := w.fds[id]
+		if !found {
+			return wasiErrnoBadf
+		}
+
+		var info fs.FileInfo
+		var err error
+		if fd.file != nil {
+			info, err = fd.file.Stat()
+		} else {
+			info, err = fd.fs.Stat(fd.name)
+		}
+		if err != nil {
+			return wasiErrnoOf(err)
+		}
+
+		return w.putFilestat(args[1].I32(), fd, fd.name, info)
+	}, wasmer.I32, wasmer.I32),
+
+	"fd_filestat_set_size": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		fd, errno := w.fileFD(args[0].I32())
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+		return wasiErrnoOf(fd.file.Truncate(args[1].I64()))
+	}, wasmer.I32, wasmer.I64),
+
+	"fd_prestat_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		fd, found := w.fds[args[0].I32()]
+		if !found || fd.preopen == "" {
+			return wasiErrnoBadf
+		}
+
+		out, ok := w.slice(args[1].I32(), 8)
+		if !ok {
+			return wasiErrnoFault
+		}
+
+		encoding.PutUint32(out, 0)
+		encoding.PutUint32(out[4:], uint32(len(fd.preopen)))
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32),
+
+	"fd_prestat_dir_name": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		fd, found := w.fds[args[0].I32()]
+		if !found || fd.preopen == "" {
+			return wasiErrnoBadf
+		}
+
+		out, ok := w.slice(args[1].I32(), args[2].I32())
+		if !ok {
+			return wasiErrnoFault
+		}
+		if len(out) < len(fd.preopen) {
+			return wasiErrnoInval
+		}
+
+		copy(out, fd.preopen)
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32, wasmer.I32),
+
+	"fd_readdir": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		fd, errno := w.dirFD(args[0].I32())
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		out, ok := w.slice(args[1].I32(), args[2].I32())
+		if !ok {
+			return wasiErrnoFault
+		}
+
+		entries, err := fd.fs.ReadDir(fd.name)
+		if err != nil {
+			return wasiErrnoOf(err)
+		}
+
+		cookie := args[3].I64()
+		if cookie < 0 {
+			return wasiErrnoInval
+		}
+
+		buffer := &bytes.Buffer{}
+		for i := cookie; i < int64(len(entries)) && buffer.Len() < len(out); i++ {
+			filetype := wasiFiletypeRegularFile
+			if entries[i].IsDir() {
+				filetype = wasiFiletypeDirectory
+			}
+
+			header := make([]byte, 24)
+			encoding.PutUint64(header, uint64(i+1))
+			encoding.PutUint64(header[8:], wasiInode(fd, path.Join(fd.name, entries[i].Name())))
+			encoding.PutUint32(header[16:], uint32(len(entries[i].Name())))
+			header[20] = filetype
+
+			buffer.Write(header)
+			buffer.WriteString(entries[i].Name())
+		}
+
+		used := copy(out, buffer.Bytes())
+		if !w.putU32(args[4].I32(), uint32(used)) {
+			return wasiErrnoFault
+		}
+		return wasiErrnoSuccess
+	}, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I64, wasmer.I32),
+
+	"path_open": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		dir, errno := w.dirFD(args[0].I32())
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		if len(w.fds) >= wasiMaxOpenFDs || w.nextFD == math.MaxInt32 {
+			return wasiErrnoMfile
+		}
+
+		name, errno := w.resolve(dir, args[2].I32(), args[3].I32())
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		oflags, rights, fdflags := args[4].I32(), args[5].I64(), args[7].I32()
+
+		info, err := dir.fs.Stat(name)
+		if err == nil && oflags&(wasiOflagCreat|wasiOflagExcl) == wasiOflagCreat|wasiOflagExcl {
+			return wasiErrnoExist
+		}
+
+		if oflags&wasiOflagDirectory != 0 || (err == nil && info.IsDir()) {
+			if err != nil {
+				return wasiErrnoOf(err)
+			}
+			if !info.IsDir() {
+				return wasiErrnoNotdir
+			}
+			if oflags&wasiOflagTrunc != 0 {
+				return wasiErrnoIsdir
+			}
+
+			return w.openResult(args[8].I32(), &wasiFD{fs: dir.fs, name: name, dir: true})
+		}
+
+		flag := 0
+		switch read, write := rights&wasiRightFdRead != 0, rights&wasiRightFdWrite != 0; {
+		case read && write:
+			flag = os.O_RDWR
+		case write:
+			flag = os.O_WRONLY
+		default:
+			flag = os.O_RDONLY
+		}
+
+		if oflags&wasiOflagCreat != 0 {
+			flag |= os.O_CREATE
+		}
+		if oflags&wasiOflagExcl != 0 {
+			flag |= os.O_EXCL
+		}
+		if oflags&wasiOflagTrunc != 0 {
+			flag |= os.O_TRUNC
+		}
+		if fdflags&wasiFdflagAppend != 0 {
+			flag |= os.O_APPEND
+		}
+
+		file, err := dir.fs.OpenFile(name, flag, 0644)
+		if err != nil {
+			return wasiErrnoOf(err)
+		}
+
+		return w.openResult(args[8].I32(), &wasiFD{fs: dir.fs, name: name, append: flag&os.O_APPEND != 0, file: file})
+	}, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I64, wasmer.I64, wasmer.I32, wasmer.I32),
+
+	"path_filestat_get": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		dir, errno := w.dirFD(args[0].I32())
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		name, errno := w.resolve(dir, args[2].I32(), args[3].I32())
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		info, err := dir.fs.Stat(name)
+		if err != nil {
+			return wasiErrnoOf(err)
+		}
+
+		return w.putFilestat(args[4].I32(), dir, name, info)
+	}, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32),
+
+	"path_create_directory": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		dir, name, errno := w.resolveArgs(args)
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+		return wasiErrnoOf(dir.fs.Mkdir(name, 0755))
+	}, wasmer.I32, wasmer.I32, wasmer.I32),
+
+	"path_remove_directory": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		dir, name, errno := w.resolveArgs(args)
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		info, err := dir.fs.Stat(name)
+		if err != nil {
+			return wasiErrnoOf(err)
+		}
+		if !info.IsDir() {
+			return wasiErrnoNotdir
+		}
+		return wasiErrnoOf(dir.fs.Remove(name))
+	}, wasmer.I32, wasmer.I32, wasmer.I32),
+
+	"path_unlink_file": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		dir, name, errno := w.resolveArgs(args)
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		info, err := dir.fs.Stat(name)
+		if err != nil {
+			return wasiErrnoOf(err)
+		}
+		if info.IsDir() {
+			return wasiErrnoIsdir
+		}
+		return wasiErrnoOf(dir.fs.Remove(name))
+	}, wasmer.I32, wasmer.I32, wasmer.I32),
+
+	"path_rename": wasiCall(func(w *virtualWASI, args []wasmer.Value) wasiErrno {
+		oldDir, oldName, errno := w.resolveArgs(args[0:3])
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		newDir, newName, errno := w.resolveArgs(args[3:6])
+		if errno != wasiErrnoSuccess {
+			return errno
+		}
+
+		if oldDir.fs != newDir.fs {
+			return wasiErrnoXdev
+		}
+		return wasiErrnoOf(oldDir.fs.Rename(oldName, newName))
+	}, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32, wasmer.I32),
+}
+
+// resolveArgs resolves the (fd, path_ptr, path_len) triplet most `path_*` functions start
+// with.
+func (w *virtualWASI) resolveArgs(args []wasmer.Value) (*wasiFD, string, wasiErrno) {
+	dir, errno := w.dirFD(args[0].I32())
+	if errno != wasiErrnoSuccess {
+		return nil, "", errno
+	}
+
+	name, errno := w.resolve(dir, args[1].I32(), args[2].I32())
+	return dir, name, errno
+}
+
+func (w *virtualWASI) openResult(ptr int32, fd *wasiFD) wasiErrno {
+	if _, ok := w.slice(ptr, 4); !ok {
+		if fd.file != nil {
+			fd.file.Close()
+		}
+		return wasiErrnoFault
+	}
+
+	w.putU32(ptr, uint32(w.open(fd)))
+	return wasiErrnoSuccess
+}
+
+func wasiNoopOnFD(w *virtualWASI, args []wasmer.Value) wasiErrno {
+	if id := args[0].I32(); id >= 0 && id <= 2 {
+		return wasiErrnoSuccess
+	}
+	if _, found := w.fds[args[0].I32()]; !found {
+		return wasiErrnoBadf
+	}
+	return wasiErrnoSuccess
+}
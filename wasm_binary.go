@@ -0,0 +1,1003 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// This file implements the subset of the WebAssembly binary format required to rewrite a
+// module (see PreInitialize and Profiler): the module is kept as a list of sections, the few
+// sections that are rewritten are decoded and encoded back, the others are copied as is.
+
+var wasmMagic = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+const (
+	sectionCustom    byte = 0
+	sectionType      byte = 1
+	sectionImport    byte = 2
+	sectionFunction  byte = 3
+	sectionTable     byte = 4
+	sectionMemory    byte = 5
+	sectionGlobal    byte = 6
+	sectionExport    byte = 7
+	sectionStart     byte = 8
+	sectionElement   byte = 9
+	sectionCode      byte = 10
+	sectionData      byte = 11
+	sectionDataCount byte = 12
+	sectionTag       byte = 13
+)
+
+// sectionOrder is the order in which non-custom sections must appear in a module.
+var sectionOrder = []byte{
+	sectionType, sectionImport, sectionFunction, sectionTable, sectionMemory, sectionTag, sectionGlobal,
+	sectionExport, sectionStart, sectionElement, sectionDataCount, sectionCode, sectionData,
+}
+
+const (
+	externFunction byte = 0x00
+	externTable    byte = 0x01
+	externMemory   byte = 0x02
+	externGlobal   byte = 0x03
+	externTag      byte = 0x04
+)
+
+const (
+	valueI32 byte = 0x7f
+	valueI64 byte = 0x7e
+	valueF32 byte = 0x7d
+	valueF64 byte = 0x7c
+)
+
+const functionTypeForm byte = 0x60
+
+const (
+	opUnreachable        byte = 0x00
+	opBlock              byte = 0x02
+	opLoop               byte = 0x03
+	opIf                 byte = 0x04
+	opTry                byte = 0x06
+	opCatch              byte = 0x07
+	opThrow              byte = 0x08
+	opRethrow            byte = 0x09
+	opThrowRef           byte = 0x0a
+	opEnd                byte = 0x0b
+	opBr                 byte = 0x0c
+	opReturn             byte = 0x0f
+	opCall               byte = 0x10
+	opCallIndirect       byte = 0x11
+	opReturnCall         byte = 0x12
+	opReturnCallIndirect byte = 0x13
+	opDelegate           byte = 0x18
+	opCatchAll           byte = 0x19
+	opTryTable           byte = 0x1f
+	opGlobalGet          byte = 0x23
+	opGlobalSet          byte = 0x24
+	opMemoryGrow         byte = 0x40
+	opI32Const           byte = 0x41
+	opI64Const           byte = 0x42
+	opF32Const           byte = 0x43
+	opF64Const           byte = 0x44
+	opI32Ne              byte = 0x47
+	opI32Add             byte = 0x6a
+	opRefNull            byte = 0xd0
+	opRefFunc            byte = 0xd2
+	opPrefixMisc         byte = 0xfc
+	opPrefixSIMD         byte = 0xfd
+	opPrefixAtomic       byte = 0xfe
+)
+
+// blockTypeEmpty is the type of blocks producing no value.
+const blockTypeEmpty byte = 0x40
+
+type wasmSection struct {
+	id      byte
+	payload []byte
+}
+
+type wasmModule struct {
+	sections []wasmSection
+}
+
+func parseWASMModule(data []byte) (*wasmModule, error) {
+	if !bytes.HasPrefix(data, wasmMagic) {
+		return nil, errors.New("not a wasm module (version 1)")
+	}
+
+	module := &wasmModule{}
+	reader := newWASMReader(data[len(wasmMagic):])
+	for !reader.done() {
+		id, err := reader.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		payload, err := reader.vector()
+		if err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, err)
+		}
+
+		module.sections = append(module.sections, wasmSection{id, payload})
+	}
+
+	return module, nil
+}
+
+func (m *wasmModule) encode() []byte {
+	out := append([]byte(nil), wasmMagic...)
+	for _, section := range m.sections {
+		out = append(out, section.id)
+		out = appendVector(out, section.payload)
+	}
+	return out
+}
+
+// section returns the payload of the section `id`, nil if the module has none.
+func (m *wasmModule) section(id byte) []byte {
+	for _, section := range m.sections {
+		if section.id == id {
+			return section.payload
+		}
+	}
+	return nil
+}
+
+// setSection replaces the payload of the section `id`, adding the section at its place if
+// the module has none.
+func (m *wasmModule) setSection(id byte, payload []byte) {
+	for i, section := range m.sections {
+		if section.id == id {
+			m.sections[i].payload = payload
+			return
+		}
+	}
+
+	rank := sectionRank(id)
+	position := len(m.sections)
+	for i, section := range m.sections {
+		if section.id != sectionCustom && sectionRank(section.id) > rank {
+			position = i
+			break
+		}
+	}
+
+	m.sections = append(m.sections, wasmSection{})
+	copy(m.sections[position+1:], m.sections[position:])
+	m.sections[position] = wasmSection{id, payload}
+}
+
+func (m *wasmModule) removeSection(id byte) {
+	sections := m.sections[:0]
+	for _, section := range m.sections {
+		if section.id != id {
+			sections = append(sections, section)
+		}
+	}
+	m.sections = sections
+}
+
+func sectionRank(id byte) int {
+	for rank, candidate := range sectionOrder {
+		if candidate == id {
+			return rank
+		}
+	}
+	return len(sectionOrder)
+}
+
+// customSection returns the payload of the custom section `name` (its name excluded), nil if
+// the module has none.
+func (m *wasmModule) customSection(name string) []byte {
+	for _, section := range m.sections {
+		if section.id != sectionCustom {
+			continue
+		}
+
+		reader := newWASMReader(section.payload)
+		if sectionName, err := reader.name(); err == nil && sectionName == name {
+			return section.payload[reader.offset:]
+		}
+	}
+	return nil
+}
+
+// setCustomSection replaces the payload of the custom section `name`.
+func (m *wasmModule) setCustomSection(name string, payload []byte) {
+	for i, section := range m.sections {
+		if section.id != sectionCustom {
+			continue
+		}
+
+		reader := newWASMReader(section.payload)
+		if sectionName, err := reader.name(); err == nil && sectionName == name {
+			m.sections[i].payload = append(appendVector(nil, []byte(name)), payload...)
+			return
+		}
+	}
+}
+
+type wasmFunctionType struct {
+	params  []byte
+	results []byte
+}
+
+func (t wasmFunctionType) equal(other wasmFunctionType) bool {
+	return bytes.Equal(t.params, other.params) && bytes.Equal(t.results, other.results)
+}
+
+func (m *wasmModule) types() ([]wasmFunctionType, error) {
+	reader := newWASMReader(m.section(sectionType))
+	if reader.done() {
+		return nil, nil
+	}
+
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	types := make([]wasmFunctionType, 0, count)
+	for i := uint32(0); i < count; i++ {
+		form, err := reader.byte()
+		if err != nil {
+			return nil, fmt.Errorf("type %d: %w", i, err)
+		}
+		if form != functionTypeForm {
+			return nil, fmt.Errorf("type %d: unsupported form %#x", i, form)
+		}
+
+		var functionType wasmFunctionType
+		if functionType.params, err = reader.vector(); err == nil {
+			functionType.results, err = reader.vector()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("type %d: %w", i, err)
+		}
+
+		types = append(types, functionType)
+	}
+
+	return types, nil
+}
+
+func encodeTypes(types []wasmFunctionType) []byte {
+	out := appendU32(nil, uint32(len(types)))
+	for _, functionType := range types {
+		out = append(out, functionTypeForm)
+		out = appendVector(out, functionType.params)
+		out = appendVector(out, functionType.results)
+	}
+	return out
+}
+
+// functions returns the type indexes of the functions defined by the module.
+func (m *wasmModule) functions() ([]uint32, error) {
+	reader := newWASMReader(m.section(sectionFunction))
+	if reader.done() {
+		return nil, nil
+	}
+
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	functions := make([]uint32, 0, count)
+	for i := uint32(0); i < count; i++ {
+		typeIndex, err := reader.u32()
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %w", i, err)
+		}
+		functions = append(functions, typeIndex)
+	}
+
+	return functions, nil
+}
+
+type wasmFunctionBody struct {
+	// locals is the raw declaration of the locals of the function.
+	locals []byte
+	// code is the raw expression of the function, its final `end` opcode included.
+	code []byte
+}
+
+func (m *wasmModule) functionBodies() ([]wasmFunctionBody, error) {
+	reader := newWASMReader(m.section(sectionCode))
+	if reader.done() {
+		return nil, nil
+	}
+
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	bodies := make([]wasmFunctionBody, 0, count)
+	for i := uint32(0); i < count; i++ {
+		payload, err := reader.vector()
+		if err != nil {
+			return nil, fmt.Errorf("function body %d: %w", i, err)
+		}
+
+		body := newWASMReader(payload)
+		if err := body.locals(); err != nil {
+			return nil, fmt.Errorf("function body %d: %w", i, err)
+		}
+
+		bodies = append(bodies, wasmFunctionBody{payload[:body.offset], payload[body.offset:]})
+	}
+
+	return bodies, nil
+}
+
+func encodeFunctionBodies(bodies []wasmFunctionBody) []byte {
+	out := appendU32(nil, uint32(len(bodies)))
+	for _, body := range bodies {
+		out = appendU32(out, uint32(len(body.locals)+len(body.code)))
+		out = append(out, body.locals...)
+		out = append(out, body.code...)
+	}
+	return out
+}
+
+type wasmImport struct {
+	module string
+	name   string
+	kind   byte
+}
+
+func (m *wasmModule) imports() ([]wasmImport, error) {
+	reader := newWASMReader(m.section(sectionImport))
+	if reader.done() {
+		return nil, nil
+	}
+
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	imports := make([]wasmImport, 0, count)
+	for i := uint32(0); i < count; i++ {
+		module, err := reader.name()
+		if err != nil {
+			return nil, err
+		}
+		name, err := reader.name()
+		if err != nil {
+			return nil, err
+		}
+		kind, err := reader.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case externFunction, externTag:
+			if kind == externTag {
+				if _, err = reader.byte(); err != nil {
+					return nil, err
+				}
+			}
+			_, err = reader.u32()
+		case externTable:
+			if _, err = reader.byte(); err == nil {
+				_, err = reader.limits()
+			}
+		case externMemory:
+			_, err = reader.limits()
+		case externGlobal:
+			_, err = reader.bytes(2)
+		default:
+			err = fmt.Errorf("unknown import kind %#x", kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("import %s.%s: %w", module, name, err)
+		}
+
+		imports = append(imports, wasmImport{module, name, kind})
+	}
+
+	return imports, nil
+}
+
+type wasmLimits struct {
+	min    uint32
+	max    uint32
+	hasMax bool
+}
+
+func (m *wasmModule) memories() ([]wasmLimits, error) {
+	reader := newWASMReader(m.section(sectionMemory))
+	if reader.done() {
+		return nil, nil
+	}
+
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	memories := make([]wasmLimits, 0, count)
+	for i := uint32(0); i < count; i++ {
+		limits, err := reader.limits()
+		if err != nil {
+			return nil, fmt.Errorf("memory %d: %w", i, err)
+		}
+		memories = append(memories, limits)
+	}
+
+	return memories, nil
+}
+
+func encodeMemories(memories []wasmLimits) []byte {
+	out := appendU32(nil, uint32(len(memories)))
+	for _, limits := range memories {
+		if limits.hasMax {
+			out = append(out, 0x01)
+			out = appendU32(out, limits.min)
+			out = appendU32(out, limits.max)
+		} else {
+			out = append(out, 0x00)
+			out = appendU32(out, limits.min)
+		}
+	}
+	return out
+}
+
+type wasmGlobal struct {
+	valueType byte
+	mutable   bool
+	init      []byte
+}
+
+func (m *wasmModule) globals() ([]wasmGlobal, error) {
+	reader := newWASMReader(m.section(sectionGlobal))
+	if reader.done() {
+		return nil, nil
+	}
+
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	globals := make([]wasmGlobal, 0, count)
+	for i := uint32(0); i < count; i++ {
+		header, err := reader.bytes(2)
+		if err != nil {
+			return nil, fmt.Errorf("global %d: %w", i, err)
+		}
+
+		init, err := reader.constExpr()
+		if err != nil {
+			return nil, fmt.Errorf("global %d: %w", i, err)
+		}
+
+		globals = append(globals, wasmGlobal{header[0], header[1] == 0x01, init})
+	}
+
+	return globals, nil
+}
+
+func encodeGlobals(globals []wasmGlobal) []byte {
+	out := appendU32(nil, uint32(len(globals)))
+	for _, global := range globals {
+		mutable := byte(0x00)
+		if global.mutable {
+			mutable = 0x01
+		}
+
+		out = append(out, global.valueType, mutable)
+		out = append(out, global.init...)
+	}
+	return out
+}
+
+// constExprOf returns the constant expression evaluating to `value` of type `valueType`.
+func constExprOf(valueType byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case int32:
+		if valueType == valueI32 {
+			return append(appendS64([]byte{opI32Const}, int64(v)), opEnd), nil
+		}
+	case int64:
+		if valueType == valueI64 {
+			return append(appendS64([]byte{opI64Const}, v), opEnd), nil
+		}
+	case float32:
+		if valueType == valueF32 {
+			out := []byte{opF32Const, 0, 0, 0, 0, opEnd}
+			encoding.PutUint32(out[1:], math.Float32bits(v))
+			return out, nil
+		}
+	case float64:
+		if valueType == valueF64 {
+			out := []byte{opF64Const, 0, 0, 0, 0, 0, 0, 0, 0, opEnd}
+			encoding.PutUint64(out[1:], math.Float64bits(v))
+			return out, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported value %v (%T) for type %#x", value, value, valueType)
+}
+
+type wasmExport struct {
+	name  string
+	kind  byte
+	index uint32
+}
+
+func (m *wasmModule) exports() ([]wasmExport, error) {
+	reader := newWASMReader(m.section(sectionExport))
+	if reader.done() {
+		return nil, nil
+	}
+
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	exports := make([]wasmExport, 0, count)
+	for i := uint32(0); i < count; i++ {
+		name, err := reader.name()
+		if err != nil {
+			return nil, err
+		}
+		kind, err := reader.byte()
+		if err != nil {
+			return nil, err
+		}
+		index, err := reader.u32()
+		if err != nil {
+			return nil, err
+		}
+
+		exports = append(exports, wasmExport{name, kind, index})
+	}
+
+	return exports, nil
+}
+
+func encodeExports(exports []wasmExport) []byte {
+	out := appendU32(nil, uint32(len(exports)))
+	for _, export := range exports {
+		out = appendVector(out, []byte(export.name))
+		out = append(out, export.kind)
+		out = appendU32(out, export.index)
+	}
+	return out
+}
+
+type wasmDataSegment struct {
+	passive bool
+	memory  uint32
+	offset  []byte
+	data    []byte
+}
+
+func (m *wasmModule) dataSegments() ([]wasmDataSegment, error) {
+	reader := newWASMReader(m.section(sectionData))
+	if reader.done() {
+		return nil, nil
+	}
+
+	count, err := reader.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]wasmDataSegment, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var segment wasmDataSegment
+
+		flags, err := reader.u32()
+		if err != nil {
+			return nil, fmt.Errorf("data segment %d: %w", i, err)
+		}
+
+		switch flags {
+		case 0:
+			segment.offset, err = reader.constExpr()
+		case 1:
+			segment.passive = true
+		case 2:
+			if segment.memory, err = reader.u32(); err == nil {
+				segment.offset, err = reader.constExpr()
+			}
+		default:
+			err = fmt.Errorf("unknown flags %d", flags)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("data segment %d: %w", i, err)
+		}
+
+		if segment.data, err = reader.vector(); err != nil {
+			return nil, fmt.Errorf("data segment %d: %w", i, err)
+		}
+
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+func encodeDataSegments(segments []wasmDataSegment) []byte {
+	out := appendU32(nil, uint32(len(segments)))
+	for _, segment := range segments {
+		switch {
+		case segment.passive:
+			out = appendU32(out, 1)
+		case segment.memory == 0:
+			out = appendU32(out, 0)
+			out = append(out, segment.offset...)
+		default:
+			out = appendU32(out, 2)
+			out = appendU32(out, segment.memory)
+			out = append(out, segment.offset...)
+		}
+		out = appendVector(out, segment.data)
+	}
+	return out
+}
+
+type wasmReader struct {
+	data   []byte
+	offset int
+}
+
+func newWASMReader(data []byte) *wasmReader {
+	return &wasmReader{data: data}
+}
+
+var errUnexpectedEnd = errors.New("unexpected end of wasm data")
+
+func (r *wasmReader) done() bool {
+	return r.offset >= len(r.data)
+}
+
+func (r *wasmReader) byte() (byte, error) {
+	if r.done() {
+		return 0, errUnexpectedEnd
+	}
+
+	b := r.data[r.offset]
+	r.offset++
+	return b, nil
+}
+
+func (r *wasmReader) bytes(length uint64) ([]byte, error) {
+	if length > uint64(len(r.data)-r.offset) {
+		return nil, errUnexpectedEnd
+	}
+
+	out := r.data[r.offset : r.offset+int(length)]
+	r.offset += int(length)
+	return out, nil
+}
+
+func (r *wasmReader) vector() ([]byte, error) {
+	length, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	return r.bytes(uint64(length))
+}
+
+func (r *wasmReader) name() (string, error) {
+	name, err := r.vector()
+	return string(name), err
+}
+
+func (r *wasmReader) u32() (uint32, error) {
+	value, err := r.uleb(32)
+	return uint32(value), err
+}
+
+func (r *wasmReader) uleb(bits uint) (uint64, error) {
+	var value uint64
+	for shift := uint(0); ; shift += 7 {
+		if shift >= bits+7 {
+			return 0, errors.New("integer representation too long")
+		}
+
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+
+		value |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if bits < 64 && value>>bits != 0 {
+				return 0, errors.New("integer too large")
+			}
+			return value, nil
+		}
+	}
+}
+
+func (r *wasmReader) sleb() (int64, error) {
+	var value int64
+	for shift := uint(0); ; shift += 7 {
+		if shift >= 70 {
+			return 0, errors.New("integer representation too long")
+		}
+
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+
+		value |= int64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if shift+7 < 64 && b&0x40 != 0 {
+				value |= -1 << (shift + 7)
+			}
+			return value, nil
+		}
+	}
+}
+
+// locals skips the locals declaration of a function body.
+func (r *wasmReader) locals() error {
+	count, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+		if _, err := r.byte(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *wasmReader) limits() (wasmLimits, error) {
+	flags, err := r.byte()
+	if err != nil {
+		return wasmLimits{}, err
+	}
+
+	if flags > 0x01 {
+		return wasmLimits{}, fmt.Errorf("unsupported limits flags %#x", flags)
+	}
+
+	var limits wasmLimits
+	if limits.min, err = r.u32(); err != nil {
+		return limits, err
+	}
+
+	if flags == 0x01 {
+		limits.hasMax = true
+		limits.max, err = r.u32()
+	}
+	return limits, err
+}
+
+// constExpr returns the raw bytes of the constant expression starting at the current offset,
+// `end` opcode included.
+func (r *wasmReader) constExpr() ([]byte, error) {
+	start := r.offset
+	for {
+		opcode, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opEnd:
+			return r.data[start:r.offset], nil
+		case opI32Const, opI64Const:
+			_, err = r.sleb()
+		case opF32Const:
+			_, err = r.bytes(4)
+		case opF64Const:
+			_, err = r.bytes(8)
+		case opGlobalGet, opRefFunc:
+			_, err = r.u32()
+		case opRefNull:
+			_, err = r.byte()
+		default:
+			err = fmt.Errorf("unsupported opcode %#x in constant expression", opcode)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// immediates skips the immediates of the instruction `opcode` read just before.
+func (r *wasmReader) immediates(opcode byte) (err error) {
+	switch {
+	case opcode <= 0x01, opcode == 0x05, opcode == opEnd, opcode == opReturn, opcode == 0x1a, opcode == 0x1b,
+		opcode >= 0x45 && opcode <= 0xc4, opcode == 0xd1, opcode == opCatchAll, opcode == opThrowRef:
+		return nil
+	case opcode == opBlock, opcode == opLoop, opcode == opIf, opcode == opTry:
+		_, err = r.sleb()
+	case opcode == opTryTable:
+		err = r.tryTableImmediates()
+	case opcode == 0x0e:
+		var count uint32
+		if count, err = r.u32(); err == nil {
+			err = r.skipU32s(count + 1)
+		}
+	case opcode == opCallIndirect, opcode == opReturnCallIndirect:
+		err = r.skipU32s(2)
+	case opcode == opCatch, opcode == opThrow, opcode == opRethrow, opcode == opDelegate:
+		_, err = r.u32()
+	case opcode == opBr, opcode == 0x0d, opcode == opCall, opcode == opReturnCall, opcode >= 0x20 && opcode <= 0x26,
+		opcode == 0x3f, opcode == 0x40, opcode == opRefFunc:
+		_, err = r.u32()
+	case opcode == 0x1c:
+		var count uint32
+		if count, err = r.u32(); err == nil {
+			_, err = r.bytes(uint64(count))
+		}
+	case opcode >= 0x28 && opcode <= 0x3e:
+		err = r.memoryArgument()
+	case opcode == opI32Const, opcode == opI64Const:
+		_, err = r.sleb()
+	case opcode == opF32Const:
+		_, err = r.bytes(4)
+	case opcode == opF64Const:
+		_, err = r.bytes(8)
+	case opcode == opRefNull:
+		_, err = r.byte()
+	case opcode == opPrefixMisc:
+		err = r.miscImmediates()
+	case opcode == opPrefixSIMD:
+		err = r.simdImmediates()
+	case opcode == opPrefixAtomic:
+		var sub uint32
+		if sub, err = r.u32(); err == nil {
+			if sub == 0x03 {
+				_, err = r.byte()
+			} else {
+				err = r.memoryArgument()
+			}
+		}
+	default:
+		err = &unsupportedOpcodeError{fmt.Sprintf("%#x", opcode)}
+	}
+	return err
+}
+
+// unsupportedOpcodeError is returned for the instructions unknown to the rewriter, the module
+// using them is not necessarily invalid.
+type unsupportedOpcodeError struct {
+	opcode string
+}
+
+func (e *unsupportedOpcodeError) Error() string {
+	return "unsupported opcode " + e.opcode + ", the module may be valid but uses instructions the runtime cannot rewrite"
+}
+
+func (r *wasmReader) tryTableImmediates() error {
+	if _, err := r.sleb(); err != nil {
+		return err
+	}
+
+	count, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case 0x00, 0x01:
+			// catch and catch_ref, a tag and a label
+			err = r.skipU32s(2)
+		case 0x02, 0x03:
+			// catch_all and catch_all_ref, a label
+			err = r.skipU32s(1)
+		default:
+			err = fmt.Errorf("unknown catch clause %#x", kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *wasmReader) miscImmediates() error {
+	sub, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case sub <= 0x07:
+		return nil
+	case sub == 0x08, sub == 0x0a, sub == 0x0c, sub == 0x0e:
+		return r.skipU32s(2)
+	case sub <= 0x11:
+		return r.skipU32s(1)
+	}
+	return &unsupportedOpcodeError{fmt.Sprintf("0xfc %d", sub)}
+}
+
+func (r *wasmReader) simdImmediates() error {
+	sub, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case sub <= 0x0b, sub == 0x5c, sub == 0x5d:
+		return r.memoryArgument()
+	case sub == 0x0c, sub == 0x0d:
+		_, err = r.bytes(16)
+	case sub >= 0x15 && sub <= 0x22:
+		_, err = r.byte()
+	case sub >= 0x54 && sub <= 0x5b:
+		if err = r.memoryArgument(); err == nil {
+			_, err = r.byte()
+		}
+	}
+	return err
+}
+
+func (r *wasmReader) memoryArgument() error {
+	align, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	if align&0x40 != 0 {
+		// Multiple memories, the alignment is followed by the memory index
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+	}
+
+	_, err = r.uleb(64)
+	return err
+}
+
+func (r *wasmReader) skipU32s(count uint32) error {
+	for i := uint32(0); i < count; i++ {
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendU32(out []byte, value uint32) []byte {
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		if value == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func appendS64(out []byte, value int64) []byte {
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		if (value == 0 && b&0x40 == 0) || (value == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func appendVector(out []byte, data []byte) []byte {
+	return append(appendU32(out, uint32(len(data))), data...)
+}